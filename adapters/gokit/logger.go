@@ -0,0 +1,135 @@
+/*
+This package provides a go-kit log.Logger (github.com/go-kit/log) which logs to a LogProvider:
+
+	logger := cl_gokit.New(provider, cl_gokit.Config{})
+	level.Error(logger).Log("msg", "upload failed", "err", err, "bytes", 1024)
+
+It implements the interface without depending on go-kit, since Go interfaces only need the method.
+Key/value pairs become context fields, except for the "level" key, which sets the level (as go-kit's
+level package does, and defaulting to Info), "msg" (or "message"), which is the message, and "err"
+(or "error"), which is passed to the provider along with the message when it's an error.
+*/
+package gokit
+
+import (
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+
+	"context"
+	"fmt"
+	"strings"
+)
+
+type Config struct {
+	// The level of calls without a "level" key; defaults to info
+	DefaultLevel string
+}
+
+type Logger struct {
+	// Nil for log.DefaultProvider(), whatever it is at the time
+	provider     providers.LogProvider
+	defaultLevel providers.LogLevel
+}
+
+// A logger which logs to provider, or to log.DefaultProvider() if it's nil
+func New(provider providers.LogProvider, config Config) (*Logger, error) {
+	if config.DefaultLevel == "" {
+		config.DefaultLevel = "info"
+	}
+	level, err := providers.ParseLevel(config.DefaultLevel)
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{provider: provider, defaultLevel: level}, nil
+}
+
+func (l *Logger) logProvider() providers.LogProvider {
+	if l.provider == nil {
+		return log.DefaultProvider()
+	}
+	return l.provider
+}
+
+// The level of a "level" value, which from go-kit's level package is a fmt.Stringer
+func parseLevel(val interface{}) (providers.LogLevel, bool) {
+	var name string
+	switch v := val.(type) {
+	case string:
+		name = v
+	case fmt.Stringer:
+		name = v.String()
+	default:
+		return 0, false
+	}
+	switch strings.ToLower(name) {
+	case "fatal", "crit", "critical":
+		return providers.Error, true
+	}
+	level, err := providers.ParseLevel(name)
+	return level, err == nil
+}
+
+// Log never fails, as providers report their own errors
+func (l *Logger) Log(keyvals ...interface{}) error {
+	level := l.defaultLevel
+	var message string
+	var err error
+	fields := make(log.Fields, len(keyvals)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, ok := keyvals[i].(string)
+		if !ok {
+			key = fmt.Sprint(keyvals[i])
+		}
+		// go-kit's missing value
+		var val interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+		switch key {
+		case "level":
+			if parsed, ok := parseLevel(val); ok {
+				level = parsed
+				continue
+			}
+		case "msg", "message":
+			if message == "" {
+				message = fmt.Sprint(val)
+				continue
+			}
+		case "err", "error":
+			if e, ok := val.(error); ok && err == nil {
+				err = e
+				continue
+			}
+		}
+		fields[key] = val
+	}
+
+	ctx := context.Background()
+	if len(fields) > 0 {
+		ctx = log.ContextWithFields(ctx, fields)
+	}
+	// A lone error is passed as it is, so providers such as merry can find it
+	var args []interface{}
+	switch {
+	case err == nil:
+		args = []interface{}{message}
+	case message == "":
+		args = []interface{}{err}
+	default:
+		args = []interface{}{message + ": ", err}
+	}
+
+	provider := l.logProvider()
+	switch level {
+	case providers.Error:
+		provider.Error(ctx, false, args...)
+	case providers.Warn:
+		provider.Warn(ctx, false, args...)
+	case providers.Info:
+		provider.Info(ctx, false, args...)
+	default:
+		provider.Debug(ctx, false, args...)
+	}
+	return nil
+}
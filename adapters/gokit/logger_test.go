@@ -0,0 +1,56 @@
+package gokit
+
+import (
+	"errors"
+	"testing"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/structured"
+	. "github.com/onsi/gomega"
+)
+
+// go-kit isn't a dependency of the package, only of its tests, which check that Logger satisfies it
+var _ kitlog.Logger = (*Logger)(nil)
+
+// Like the values from go-kit's level package
+type levelValue string
+
+func (v levelValue) String() string { return string(v) }
+
+func TestLog(t *testing.T) {
+	RegisterTestingT(t)
+	output := structured.LogProvider(nil)
+	logger, err := New(output, Config{})
+	Expect(err).To(BeNil())
+	failure := errors.New("it broke")
+
+	Expect(logger.Log("msg", "Started", "port", 8080)).To(Succeed())
+	Expect(logger.Log("level", levelValue("error"), "msg", "Upload failed", "err", failure, "bytes", 1024)).To(Succeed())
+	Expect(logger.Log("level", "warning", "error", failure)).To(Succeed())
+	Expect(logger.Log("level", levelValue("debug"), "message", "Details", 42, "odd")).To(Succeed())
+	Expect(logger.Log("level", "verbose", "err", "not an error")).To(Succeed())
+
+	Expect(output.LogCalls()).To(Equal([]*structured.LogCallArgs{
+		{ContextFields: log.Fields{"port": 8080}, Args: []interface{}{"Started"}, Level: providers.Info},
+		{ContextFields: log.Fields{"bytes": 1024}, Args: []interface{}{"Upload failed: ", failure}, Level: providers.Error},
+		{ContextFields: log.Fields{}, Args: []interface{}{failure}, Level: providers.Warn},
+		{ContextFields: log.Fields{"42": "odd"}, Args: []interface{}{"Details"}, Level: providers.Debug},
+		{ContextFields: log.Fields{"level": "verbose", "err": "not an error"}, Args: []interface{}{""}, Level: providers.Info},
+	}))
+}
+
+func TestDefaultLevel(t *testing.T) {
+	RegisterTestingT(t)
+	output := structured.LogProvider(nil)
+	logger, err := New(output, Config{DefaultLevel: "debug"})
+	Expect(err).To(BeNil())
+	logger.Log("msg", "Details")
+	logger.Log("level", "crit", "msg", "Down")
+	Expect(output.LogCalls()[0].Level).To(Equal(providers.Debug))
+	Expect(output.LogCalls()[1].Level).To(Equal(providers.Error))
+
+	_, err = New(output, Config{DefaultLevel: "loud"})
+	Expect(err).To(MatchError(`not a valid log level: "loud"`))
+}
@@ -0,0 +1,151 @@
+/*
+This package provides a logger for grpc-go's logging, which logs to a LogProvider:
+
+	grpclog.SetLoggerV2(cl_grpclog.New(provider, cl_grpclog.Config{Verbosity: 2}))
+
+It implements grpclog.LoggerV2 and grpclog.DepthLoggerV2 without depending on grpc-go, since Go
+interfaces only need the methods. Like grpc's own logger, V(l) is true for l up to Verbosity
+(GRPC_GO_LOG_VERBOSITY_LEVEL for grpc's), and grpc checks it before its more detailed Info calls.
+Fatal calls are logged at Error, then wait for the provider before exiting, as grpc expects.
+*/
+package grpclog
+
+import (
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+type Config struct {
+	// The highest V-level that's logged; grpc uses 0 to 2
+	Verbosity int
+	// Added to every call, e.g. {"system": "grpc"}
+	Fields log.Fields
+}
+
+type Logger struct {
+	// Nil for log.DefaultProvider(), whatever it is at the time
+	provider  providers.LogProvider
+	verbosity int
+	ctx       context.Context
+
+	// For tests
+	exit func(int)
+}
+
+// A logger which logs to provider, or to log.DefaultProvider() if it's nil
+func New(provider providers.LogProvider, config Config) *Logger {
+	ctx := context.Background()
+	if len(config.Fields) > 0 {
+		ctx = log.ContextWithFields(ctx, config.Fields)
+	}
+	return &Logger{
+		provider:  provider,
+		verbosity: config.Verbosity,
+		ctx:       ctx,
+		exit:      os.Exit,
+	}
+}
+
+func (l *Logger) logProvider() providers.LogProvider {
+	if l.provider == nil {
+		return log.DefaultProvider()
+	}
+	return l.provider
+}
+
+// Like fmt.Sprintln, but without the newline, as grpc's logger does
+func sprintln(args []interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
+
+func (l *Logger) fatal(ctx context.Context, message string) {
+	l.logProvider().Error(ctx, false, message)
+	l.logProvider().Wait()
+	l.exit(1)
+}
+
+func (l *Logger) Info(args ...interface{}) {
+	l.logProvider().Info(l.ctx, false, args...)
+}
+
+func (l *Logger) Infoln(args ...interface{}) {
+	l.logProvider().Info(l.ctx, false, sprintln(args))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logProvider().Info(l.ctx, false, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warning(args ...interface{}) {
+	l.logProvider().Warn(l.ctx, false, args...)
+}
+
+func (l *Logger) Warningln(args ...interface{}) {
+	l.logProvider().Warn(l.ctx, false, sprintln(args))
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.logProvider().Warn(l.ctx, false, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Error(args ...interface{}) {
+	l.logProvider().Error(l.ctx, false, args...)
+}
+
+func (l *Logger) Errorln(args ...interface{}) {
+	l.logProvider().Error(l.ctx, false, sprintln(args))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logProvider().Error(l.ctx, false, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Fatal(args ...interface{}) {
+	l.fatal(l.ctx, fmt.Sprint(args...))
+}
+
+func (l *Logger) Fatalln(args ...interface{}) {
+	l.fatal(l.ctx, sprintln(args))
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.fatal(l.ctx, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) V(level int) bool {
+	return level <= l.verbosity
+}
+
+// The Depth methods are for grpc's wrappers, which pass how many frames up the stack the call they
+// log was made; that caller is attached with log.ContextWithCaller, for reported_at
+
+// The context for a Depth call, with the caller depth frames above the Depth method's
+func (l *Logger) callerContext(depth int) context.Context {
+	// Skipping this function and the Depth method
+	if _, file, line, ok := runtime.Caller(depth + 2); ok {
+		return log.ContextWithCaller(l.ctx, file, line)
+	}
+	return l.ctx
+}
+
+func (l *Logger) InfoDepth(depth int, args ...interface{}) {
+	l.logProvider().Info(l.callerContext(depth), false, args...)
+}
+
+func (l *Logger) WarningDepth(depth int, args ...interface{}) {
+	l.logProvider().Warn(l.callerContext(depth), false, args...)
+}
+
+func (l *Logger) ErrorDepth(depth int, args ...interface{}) {
+	l.logProvider().Error(l.callerContext(depth), false, args...)
+}
+
+func (l *Logger) FatalDepth(depth int, args ...interface{}) {
+	l.fatal(l.callerContext(depth), fmt.Sprint(args...))
+}
@@ -0,0 +1,103 @@
+package grpclog
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/reported_at"
+	"github.com/myhelix/contextlogger/providers/structured"
+	. "github.com/onsi/gomega"
+	grpclogv2 "google.golang.org/grpc/grpclog"
+)
+
+// grpc-go isn't a dependency of the package, only of its tests, which check that Logger satisfies it
+var _ grpclogv2.DepthLoggerV2 = (*Logger)(nil)
+
+// Counts the Waits that Fatal calls make before exiting
+type waitCounter struct {
+	providers.LogProvider
+	waits int
+}
+
+func (w *waitCounter) Wait() {
+	w.waits++
+	w.LogProvider.Wait()
+}
+
+func setup(t *testing.T, config Config) (*Logger, *structured.StructuredOutputLogProvider, *waitCounter) {
+	RegisterTestingT(t)
+	output := structured.LogProvider(nil)
+	counter := &waitCounter{LogProvider: output}
+	return New(counter, config), output, counter
+}
+
+func TestLevels(t *testing.T) {
+	logger, output, _ := setup(t, Config{Fields: log.Fields{"system": "grpc"}})
+
+	logger.Info("[core] ", "Channel created")
+	logger.Infoln("Subchannel", 3, "ready")
+	logger.Warningf("Retrying in %v", "1s")
+	logger.Errorln("Transport", "closed")
+	logger.ErrorDepth(1, "From depth")
+
+	grpc := log.Fields{"system": "grpc"}
+	Expect(output.LogCalls()).To(Equal([]*structured.LogCallArgs{
+		{ContextFields: grpc, Args: []interface{}{"[core] ", "Channel created"}, Level: providers.Info},
+		{ContextFields: grpc, Args: []interface{}{"Subchannel 3 ready"}, Level: providers.Info},
+		{ContextFields: grpc, Args: []interface{}{"Retrying in 1s"}, Level: providers.Warn},
+		{ContextFields: grpc, Args: []interface{}{"Transport closed"}, Level: providers.Error},
+		{ContextFields: grpc, Args: []interface{}{"From depth"}, Level: providers.Error},
+	}))
+}
+
+func TestVerbosity(t *testing.T) {
+	logger, _, _ := setup(t, Config{})
+	Expect(logger.V(0)).To(BeTrue())
+	Expect(logger.V(1)).To(BeFalse())
+
+	logger, _, _ = setup(t, Config{Verbosity: 2})
+	Expect(logger.V(2)).To(BeTrue())
+	Expect(logger.V(3)).To(BeFalse())
+}
+
+func TestFatal(t *testing.T) {
+	logger, output, counter := setup(t, Config{})
+	var exited []int
+	logger.exit = func(code int) { exited = append(exited, code) }
+
+	logger.Fatalf("Listen failed: %v", "address in use")
+	logger.FatalDepth(2, "Again")
+	Expect(output.LogCalls()).To(Equal([]*structured.LogCallArgs{
+		{ContextFields: log.Fields{}, Args: []interface{}{"Listen failed: address in use"}, Level: providers.Error},
+		{ContextFields: log.Fields{}, Args: []interface{}{"Again"}, Level: providers.Error},
+	}))
+	Expect(counter.waits).To(Equal(2))
+	Expect(exited).To(Equal([]int{1, 1}))
+}
+
+// Logs through a helper, as grpc's wrappers do, passing the depth of the helper's caller
+func warnFromHelper(logger *Logger, msg string) {
+	logger.WarningDepth(1, msg)
+}
+
+func TestDepth(t *testing.T) {
+	RegisterTestingT(t)
+	output := structured.LogProvider(nil)
+	logger := New(reported_at.LogProvider(output, reported_at.Config{}), Config{})
+	logger.exit = func(int) {}
+
+	_, file, line, _ := runtime.Caller(0)
+	logger.InfoDepth(0, "Direct")
+	warnFromHelper(logger, "Helper")
+	logger.ErrorDepth(0, "Error")
+	logger.FatalDepth(0, "Fatal")
+
+	calls := output.LogCalls()
+	Expect(calls).To(HaveLen(4))
+	for i, call := range calls {
+		Expect(call.ContextFields["reportedAt"]).To(Equal(fmt.Sprintf("%s:%d", file, line+i+1)), call.Args)
+	}
+}
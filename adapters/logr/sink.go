@@ -0,0 +1,167 @@
+/*
+This package provides a logr.LogSink (github.com/go-logr/logr) which logs to a LogProvider, so that
+libraries logging through logr, such as client-go and controller-runtime, reach the provider chain:
+
+	ctrl.SetLogger(cl_logr.New(provider, cl_logr.Config{}))
+
+Info calls are logged at Info or Debug depending on their V-level, and Error calls at Error, with
+the error as well as the message. Key/value pairs from WithValues and the call become context
+fields, and names from WithName are joined with "/" into a "logger" field. The caller of the
+Logger (adjusted by WithCallDepth) is attached with log.ContextWithCaller, for reported_at.
+*/
+package logr
+
+import (
+	"github.com/go-logr/logr"
+
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// The field holding the names from WithName
+const NameField = "logger"
+
+type Config struct {
+	// V-levels at or above this are logged at Debug, and those below it at Info; nil means 1, so
+	// that only V(0) is Info, and 0 logs everything at Debug
+	DebugLevel *int
+	// V-levels above this aren't enabled, so logr skips them without building their key/value
+	// pairs; nil enables every level, leaving the providers to filter by level
+	MaxVerbosity *int
+	// Send Error calls as reports, as log.ErrorReport does
+	ReportErrors bool
+}
+
+type sink struct {
+	// Nil for log.DefaultProvider(), whatever it is at the time
+	provider   providers.LogProvider
+	debugLevel int
+	// Negative when every level is enabled
+	maxVerbosity int
+	report       bool
+	name         string
+	// Frames between the Logger's caller and the sink, from logr and WithCallDepth
+	callDepth int
+	// Holds the fields from WithValues and WithName
+	ctx context.Context
+}
+
+// A Logger whose sink logs to provider, or to log.DefaultProvider() if it's nil
+func New(provider providers.LogProvider, config Config) logr.Logger {
+	return logr.New(LogSink(provider, config))
+}
+
+func LogSink(provider providers.LogProvider, config Config) logr.LogSink {
+	s := &sink{
+		provider:     provider,
+		debugLevel:   1,
+		maxVerbosity: -1,
+		report:       config.ReportErrors,
+		ctx:          context.Background(),
+	}
+	if config.DebugLevel != nil {
+		s.debugLevel = *config.DebugLevel
+	}
+	if config.MaxVerbosity != nil && *config.MaxVerbosity >= 0 {
+		s.maxVerbosity = *config.MaxVerbosity
+	}
+	return s
+}
+
+func (s *sink) logProvider() providers.LogProvider {
+	if s.provider == nil {
+		return log.DefaultProvider()
+	}
+	return s.provider
+}
+
+// Add key/value pairs as fields; a key without a value gets "(MISSING)", as with fmt
+func withValues(ctx context.Context, keysAndValues []interface{}) context.Context {
+	if len(keysAndValues) == 0 {
+		return ctx
+	}
+	fields := make(log.Fields, (len(keysAndValues)+1)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = fmt.Sprint(keysAndValues[i])
+		}
+		var val interface{} = "(MISSING)"
+		if i+1 < len(keysAndValues) {
+			val = keysAndValues[i+1]
+			if marshaler, ok := val.(logr.Marshaler); ok {
+				val = marshaler.MarshalLog()
+			}
+		}
+		fields[key] = val
+	}
+	return log.ContextWithFields(ctx, fields)
+}
+
+func (s *sink) Init(info logr.RuntimeInfo) {
+	s.callDepth = info.CallDepth
+}
+
+// The context for a call to Info or Error, with its key/value pairs and where it came from
+func (s *sink) callContext(keysAndValues []interface{}) context.Context {
+	ctx := withValues(s.ctx, keysAndValues)
+	// Skipping this function and Info or Error
+	if _, file, line, ok := runtime.Caller(2 + s.callDepth); ok {
+		ctx = log.ContextWithCaller(ctx, file, line)
+	}
+	return ctx
+}
+
+// Everything up to MaxVerbosity is enabled, as providers filter by level themselves
+func (s *sink) Enabled(level int) bool {
+	return s.maxVerbosity < 0 || level <= s.maxVerbosity
+}
+
+func (s *sink) Info(level int, msg string, keysAndValues ...interface{}) {
+	ctx := s.callContext(keysAndValues)
+	if level < s.debugLevel {
+		s.logProvider().Info(ctx, false, msg)
+	} else {
+		s.logProvider().Debug(ctx, false, msg)
+	}
+}
+
+func (s *sink) Error(err error, msg string, keysAndValues ...interface{}) {
+	ctx := s.callContext(keysAndValues)
+	// A lone error is passed as it is, so providers such as merry can find it
+	switch {
+	case err == nil:
+		s.logProvider().Error(ctx, s.report, msg)
+	case msg == "":
+		s.logProvider().Error(ctx, s.report, err)
+	default:
+		s.logProvider().Error(ctx, s.report, msg+": ", err)
+	}
+}
+
+func (s *sink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	child := *s
+	child.ctx = withValues(s.ctx, keysAndValues)
+	return &child
+}
+
+func (s *sink) WithCallDepth(depth int) logr.LogSink {
+	child := *s
+	child.callDepth += depth
+	return &child
+}
+
+func (s *sink) WithName(name string) logr.LogSink {
+	child := *s
+	if s.name == "" {
+		child.name = name
+	} else {
+		child.name = s.name + "/" + name
+	}
+	child.ctx = log.ContextWithFields(s.ctx, log.Fields{NameField: child.name})
+	return &child
+}
@@ -0,0 +1,177 @@
+package logr
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/reported_at"
+	"github.com/myhelix/contextlogger/providers/structured"
+	. "github.com/onsi/gomega"
+)
+
+func setup(t *testing.T, config Config) (logr.Logger, *structured.StructuredOutputLogProvider) {
+	RegisterTestingT(t)
+	output := structured.LogProvider(nil)
+	return New(output, config), output
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestVerbosity(t *testing.T) {
+	logger, output := setup(t, Config{})
+	logger.Info("zero")
+	logger.V(1).Info("one")
+	logger.V(1).V(2).Info("three")
+	Expect(logger.V(10).Enabled()).To(BeTrue())
+	Expect(output.LogCalls()).To(Equal([]*structured.LogCallArgs{
+		{ContextFields: log.Fields{}, Args: []interface{}{"zero"}, Level: providers.Info},
+		{ContextFields: log.Fields{}, Args: []interface{}{"one"}, Level: providers.Debug},
+		{ContextFields: log.Fields{}, Args: []interface{}{"three"}, Level: providers.Debug},
+	}))
+
+	logger, output = setup(t, Config{DebugLevel: intPtr(3)})
+	logger.V(2).Info("two")
+	logger.V(3).Info("three")
+	Expect(output.LogCalls()[0].Level).To(Equal(providers.Info))
+	Expect(output.LogCalls()[1].Level).To(Equal(providers.Debug))
+
+	logger, output = setup(t, Config{DebugLevel: intPtr(0)})
+	logger.Info("zero")
+	Expect(output.LogCalls()[0].Level).To(Equal(providers.Debug))
+}
+
+func TestMaxVerbosity(t *testing.T) {
+	logger, output := setup(t, Config{MaxVerbosity: intPtr(1)})
+	Expect(logger.Enabled()).To(BeTrue())
+	Expect(logger.V(1).Enabled()).To(BeTrue())
+	Expect(logger.V(2).Enabled()).To(BeFalse())
+	logger.V(1).Info("one")
+	logger.V(2).Info("two")
+	logger.V(2).Error(errors.New("failed"), "Still logged")
+	Expect(output.LogCalls()).To(HaveLen(2))
+	Expect(output.LogCalls()[0].Args).To(Equal([]interface{}{"one"}))
+	Expect(output.LogCalls()[1].Level).To(Equal(providers.Error))
+
+	logger, output = setup(t, Config{MaxVerbosity: intPtr(0)})
+	logger.V(1).Info("one")
+	logger.Info("zero")
+	Expect(output.LogCalls()).To(HaveLen(1))
+}
+
+type secret string
+
+func (secret) MarshalLog() interface{} { return "***" }
+
+func TestValues(t *testing.T) {
+	logger, output := setup(t, Config{})
+
+	parent := logger.WithValues("user", "sam", "attempt", 1)
+	child := parent.WithValues("attempt", 2)
+	child.Info("Child", "password", secret("hunter2"), 42, "numeric key", "odd")
+	parent.Info("Parent")
+
+	Expect(output.LogCalls()).To(Equal([]*structured.LogCallArgs{
+		{ContextFields: log.Fields{"user": "sam", "attempt": 2, "password": "***", "42": "numeric key", "odd": "(MISSING)"}, Args: []interface{}{"Child"}, Level: providers.Info},
+		{ContextFields: log.Fields{"user": "sam", "attempt": 1}, Args: []interface{}{"Parent"}, Level: providers.Info},
+	}))
+}
+
+func TestNames(t *testing.T) {
+	logger, output := setup(t, Config{})
+
+	controller := logger.WithName("controller")
+	controller.WithName("pods").Info("Reconciling")
+	controller.Info("Started")
+	logger.Info("Unnamed")
+
+	calls := output.LogCalls()
+	Expect(calls[0].ContextFields).To(Equal(log.Fields{"logger": "controller/pods"}))
+	Expect(calls[1].ContextFields).To(Equal(log.Fields{"logger": "controller"}))
+	Expect(calls[2].ContextFields).To(Equal(log.Fields{}))
+}
+
+func TestErrors(t *testing.T) {
+	logger, output := setup(t, Config{})
+	err := errors.New("it broke")
+
+	logger.WithName("sync").Error(err, "Sync failed", "attempt", 3)
+	logger.Error(err, "")
+	logger.Error(nil, "No error")
+	Expect(output.LogCalls()).To(Equal([]*structured.LogCallArgs{
+		{ContextFields: log.Fields{"logger": "sync", "attempt": 3}, Args: []interface{}{"Sync failed: ", err}, Level: providers.Error},
+		{ContextFields: log.Fields{}, Args: []interface{}{err}, Level: providers.Error},
+		{ContextFields: log.Fields{}, Args: []interface{}{"No error"}, Level: providers.Error},
+	}))
+
+	logger, output = setup(t, Config{ReportErrors: true})
+	logger.Error(err, "Reported")
+	logger.Info("Not reported")
+	Expect(output.LogCalls()[0].Report).To(BeTrue())
+	Expect(output.LogCalls()[1].Report).To(BeFalse())
+}
+
+func TestDefaultProvider(t *testing.T) {
+	RegisterTestingT(t)
+	output := structured.LogProvider(nil)
+	original := log.DefaultProvider()
+	defer log.SetDefaultProvider(original)
+
+	logger := New(nil, Config{})
+	log.SetDefaultProvider(output)
+	logger.Info("Hello")
+	Expect(output.LogCalls()).To(HaveLen(1))
+}
+
+// The contract documented on logr.LogSink and logr.CallDepthLogSink
+
+var _ logr.CallDepthLogSink = (*sink)(nil)
+
+func TestEnabled(t *testing.T) {
+	logger, output := setup(t, Config{})
+	s := LogSink(output, Config{})
+	for level := 0; level <= 10; level++ {
+		Expect(s.Enabled(level)).To(BeTrue())
+		Expect(logger.V(level).Enabled()).To(BeTrue())
+	}
+	// logr treats negative V-levels as 0
+	logger.V(-1).Info("Negative")
+	Expect(output.LogCalls()[0].Level).To(Equal(providers.Info))
+}
+
+// Logs through a helper, as WithCallDepth is for
+func logFromHelper(logger logr.Logger, msg string) {
+	logger.WithCallDepth(1).Info(msg)
+}
+
+func TestCallDepth(t *testing.T) {
+	RegisterTestingT(t)
+	output := structured.LogProvider(nil)
+	logger := New(reported_at.LogProvider(output, reported_at.Config{}), Config{})
+
+	_, file, line, _ := runtime.Caller(0)
+	logger.Info("Direct")
+	logger.Error(errors.New("it broke"), "Error")
+	logFromHelper(logger, "Helper")
+	logger.WithName("named").WithValues("user", "sam").Info("Derived")
+
+	calls := output.LogCalls()
+	Expect(calls).To(HaveLen(4))
+	for i, call := range calls {
+		Expect(call.ContextFields["reportedAt"]).To(Equal(fmt.Sprintf("%s:%d", file, line+i+1)), call.Args)
+	}
+
+	// Init gets logr's own call depth, which WithCallDepth adds to
+	s := LogSink(output, Config{}).(*sink)
+	Expect(s.callDepth).To(BeZero())
+	s.Init(logr.RuntimeInfo{CallDepth: 1})
+	Expect(s.callDepth).To(Equal(1))
+	Expect(s.WithCallDepth(2).(*sink).callDepth).To(Equal(3))
+	Expect(s.callDepth).To(Equal(1))
+}
@@ -0,0 +1,242 @@
+/*
+This package builds a LogProvider chain from a declarative YAML or JSON document, instead of
+nesting provider constructors by hand. Each provider package registers a Factory under a name in
+its init function, so a provider is available here as soon as its package is imported:
+
+	import (
+		"github.com/myhelix/contextlogger/config"
+		_ "github.com/myhelix/contextlogger/providers/logrus"
+		_ "github.com/myhelix/contextlogger/providers/merry"
+		_ "github.com/myhelix/contextlogger/providers/reported_at"
+	)
+
+	provider, err := config.BuildFile("logging.yaml")
+
+The document lists the chain in the order log calls flow through it:
+
+	providers:
+	  - name: reported_at
+	  - name: merry
+	  - name: logrus
+	    options:
+	      level: info
+	      format: json
+
+A chain built this way is meant to be used until the process exits; to replace one, build it with
+OpenChain instead, which also returns a Closer for the files and listeners its providers opened.
+*/
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/myhelix/contextlogger/providers"
+
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Kind int
+
+const (
+	// Enrichers add data to the context for the providers after them (e.g. merry, reported_at),
+	// so they have to come before any sink in the chain.
+	Enricher Kind = iota
+	// Sinks send log data somewhere (e.g. logrus, rollbar).
+	Sink
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Enricher:
+		return "enricher"
+	case Sink:
+		return "sink"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// A Factory constructs a provider which passes through to nextProvider, taking its settings from
+// options. Problems with individual options should be reported via options, and then returned
+// from options.Err() if the factory can't go on.
+type Factory func(nextProvider providers.LogProvider, options *Options) (providers.LogProvider, error)
+
+type registration struct {
+	kind    Kind
+	factory Factory
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]registration)
+)
+
+// Register makes a provider available under name; it panics if the name is already taken, since
+// that can only be a programming error.
+func Register(name string, kind Kind, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if factory == nil {
+		panic("config: Register factory is nil for " + name)
+	}
+	if _, exists := registry[name]; exists {
+		panic("config: Register called twice for " + name)
+	}
+	registry[name] = registration{kind, factory}
+}
+
+// Registered returns the names of all registered providers, sorted.
+func Registered() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookup(name string) (registration, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	reg, ok := registry[name]
+	return reg, ok
+}
+
+type Chain struct {
+	// In the order log calls flow through them; the first one is called directly by log
+	Providers []ProviderConfig `yaml:"providers" json:"providers"`
+}
+
+type ProviderConfig struct {
+	Name    string                 `yaml:"name" json:"name"`
+	Options map[string]interface{} `yaml:"options" json:"options"`
+}
+
+// Build parses a YAML or JSON document (JSON being a subset of YAML) and builds the chain it
+// describes.
+func Build(document []byte) (providers.LogProvider, error) {
+	chain, err := Parse(document)
+	if err != nil {
+		return nil, err
+	}
+	return BuildChain(chain)
+}
+
+// Parse reads the chain described by a YAML or JSON document, without building it.
+func Parse(document []byte) (Chain, error) {
+	var chain Chain
+	decoder := yaml.NewDecoder(bytes.NewReader(document))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&chain); err != nil {
+		if err == io.EOF {
+			return chain, errors.New("config: document is empty")
+		}
+		return chain, fmt.Errorf("config: %w", err)
+	}
+	return chain, nil
+}
+
+func BuildFile(path string) (providers.LogProvider, error) {
+	document, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return Build(document)
+}
+
+// BuildChain builds a chain which is used until the process exits, so never needs closing; see
+// OpenChain for one which does.
+func BuildChain(chain Chain) (providers.LogProvider, error) {
+	provider, _, err := OpenChain(chain)
+	return provider, err
+}
+
+// OpenChain builds a chain, also returning a Closer for what its providers opened, such as output
+// files and listeners. Close it once the chain is no longer used, after its final Wait.
+func OpenChain(chain Chain) (providers.LogProvider, io.Closer, error) {
+	if len(chain.Providers) == 0 {
+		return nil, nil, errors.New("config: no providers listed")
+	}
+
+	// Validate everything we can before constructing anything, since construction may have
+	// side effects (opening files, etc.), which have to be undone if it fails
+	regs := make([]registration, len(chain.Providers))
+	firstSink := -1
+	for i, pc := range chain.Providers {
+		reg, ok := lookup(pc.Name)
+		if !ok {
+			if pc.Name == "" {
+				return nil, nil, fmt.Errorf("config: providers[%d]: missing name", i)
+			}
+			return nil, nil, fmt.Errorf("config: providers[%d]: unknown provider %q (registered: %s; is its package imported?)",
+				i, pc.Name, strings.Join(Registered(), ", "))
+		}
+		if reg.kind == Enricher && firstSink >= 0 {
+			return nil, nil, fmt.Errorf("config: providers[%d] (%s) must come before providers[%d] (%s); "+
+				"the data it adds would never reach that sink",
+				i, pc.Name, firstSink, chain.Providers[firstSink].Name)
+		}
+		if reg.kind == Sink && firstSink < 0 {
+			firstSink = i
+		}
+		regs[i] = reg
+	}
+
+	// Construct from the end of the chain backwards, since each provider needs its successor
+	var provider providers.LogProvider
+	closer := &chainCloser{options: make([]*Options, len(chain.Providers))}
+	for i := len(chain.Providers) - 1; i >= 0; i-- {
+		pc := chain.Providers[i]
+		options := NewOptions(pc.Options)
+		closer.options[i] = options
+		p, err := regs[i].factory(provider, options)
+		if optionsErr := options.Err(); optionsErr != nil {
+			err = optionsErr
+		} else if err == nil {
+			err = options.unused()
+		}
+		if err != nil {
+			closer.Close()
+			return nil, nil, fmt.Errorf("config: providers[%d] (%s): %w", i, pc.Name, err)
+		}
+		provider = p
+	}
+	for i, options := range closer.options {
+		for _, f := range options.built {
+			if err := f(); err != nil {
+				// Undo what the providers have done so far, e.g. stop listening
+				closer.Close()
+				return nil, nil, fmt.Errorf("config: providers[%d] (%s): %w", i, chain.Providers[i].Name, err)
+			}
+		}
+	}
+	return provider, closer, nil
+}
+
+type chainCloser struct {
+	// Of each provider in the chain, for their OnClose functions
+	options []*Options
+}
+
+// Close the providers from the start of the chain, the way log calls flow through them
+func (c *chainCloser) Close() (err error) {
+	for i, options := range c.options {
+		if options == nil {
+			continue
+		}
+		if closeErr := options.close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("config: providers[%d]: %w", i, closeErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,200 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	_ "github.com/myhelix/contextlogger/providers/logrus"
+	_ "github.com/myhelix/contextlogger/providers/merry"
+	_ "github.com/myhelix/contextlogger/providers/reported_at"
+	_ "github.com/myhelix/contextlogger/providers/rollbar"
+
+	"github.com/ansel1/merry"
+	. "github.com/onsi/gomega"
+)
+
+func outputFile(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "out.log")
+}
+
+func readOutput(path string) string {
+	output, err := ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	return string(output)
+}
+
+func TestBuildYAML(t *testing.T) {
+	RegisterTestingT(t)
+	path := outputFile(t)
+
+	provider, err := config.Build([]byte(`
+providers:
+  - name: reported_at
+  - name: merry
+  - name: logrus
+    options:
+      level: info
+      format: json
+      output: file://` + path + `
+`))
+	Expect(err).To(BeNil())
+
+	provider.Debug(context.Background(), false, "hidden")
+	provider.Info(context.Background(), false, merry.New("it broke").WithValue("how", "badly"))
+	output := readOutput(path)
+	Expect(output).NotTo(ContainSubstring("hidden"))
+	Expect(output).To(ContainSubstring(`"how":"badly"`))
+	Expect(output).To(ContainSubstring(`"msg":"it broke"`))
+	Expect(output).To(MatchRegexp(`"reportedAt":".+:\d+"`))
+}
+
+func TestBuildJSON(t *testing.T) {
+	RegisterTestingT(t)
+	path := outputFile(t)
+
+	provider, err := config.Build([]byte(`{
+		"providers": [
+			{"name": "logrus", "options": {"level": "debug", "output": "file://` + path + `"}}
+		]
+	}`))
+	Expect(err).To(BeNil())
+
+	provider.Debug(context.Background(), false, "shown")
+	Expect(readOutput(path)).To(MatchRegexp(`level=debug msg=shown`))
+}
+
+func TestBuildLogfmt(t *testing.T) {
+	RegisterTestingT(t)
+	path := outputFile(t)
+
+	provider, err := config.Build([]byte(`{"providers": [{"name": "logrus", "options": {"format": "logfmt", "output": "file://` + path + `"}}]}`))
+	Expect(err).To(BeNil())
+
+	provider.Info(log.ContextWithFields(context.Background(), log.Fields{"empty": "", "quote": `say "hi"`}), false, "shown")
+	Expect(readOutput(path)).To(HaveSuffix(`level=info msg=shown empty= quote="say \"hi\""` + "\n"))
+}
+
+func TestBuildErrors(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, test := range []struct {
+		document string
+		problem  string
+	}{
+		{``, `config: document is empty`},
+		{`providers: []`, `config: no providers listed`},
+		{`provider: [{name: logrus}]`, `field provider not found`},
+		{`providers: [{name: logrus, option: {}}]`, `field option not found`},
+		{`providers: [{name: syslog}]`, `providers[0]: unknown provider "syslog" (registered: `},
+		{`providers: [{options: {}}]`, `providers[0]: missing name`},
+		{`providers: [{name: logrus, options: {level: loud}}]`, `providers[0] (logrus): not a valid logrus Level: "loud"`},
+		{`providers: [{name: logrus, options: {format: xml}}]`, `option "format" must be one of text|json|logfmt, not "xml"`},
+		{`providers: [{name: logrus, options: {output: /tmp/log}}]`, `option "output": unknown output "/tmp/log"`},
+		{`providers: [{name: logrus, options: {levle: debug, colour: red}}]`, `providers[0] (logrus): unknown option(s) "colour", "levle"`},
+		{`providers: [{name: merry}, {name: rollbar}]`, `providers[1] (rollbar): missing required option "token"`},
+		{`providers: [{name: reported_at, options: {ignoreStackFrames: "("}}]`, `option "ignoreStackFrames": error parsing regexp`},
+		{`providers: [{name: logrus}, {name: merry}]`, `providers[1] (merry) must come before providers[0] (logrus)`},
+	} {
+		provider, err := config.Build([]byte(test.document))
+		Expect(provider).To(BeNil(), test.document)
+		Expect(err).NotTo(BeNil(), test.document)
+		Expect(err.Error()).To(ContainSubstring(test.problem), test.document)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(config.Registered()).To(ContainElements("logrus", "merry", "reported_at", "rollbar"))
+	Expect(func() {
+		config.Register("logrus", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+			return nextProvider, nil
+		})
+	}).To(Panic())
+}
+
+func TestOnBuilt(t *testing.T) {
+	RegisterTestingT(t)
+
+	var calls []string
+	config.Register("test_on_built", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		name := options.RequiredString("name")
+		fail := options.Bool("fail", false)
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+		options.OnBuilt(func() error {
+			calls = append(calls, name)
+			if fail {
+				return errors.New("failed to start")
+			}
+			options.OnClose(func() error {
+				calls = append(calls, "close "+name)
+				return nil
+			})
+			return nil
+		})
+		return nextProvider, nil
+	})
+
+	// Nothing is started if any provider fails to build
+	_, err := config.Build([]byte(`providers: [{name: test_on_built}, {name: test_on_built, options: {name: second}}]`))
+	Expect(err).To(MatchError(`config: providers[0] (test_on_built): missing required option "name"`))
+	Expect(calls).To(BeEmpty())
+
+	_, err = config.Build([]byte(`providers: [{name: test_on_built, options: {name: first}}, {name: test_on_built, options: {name: second}}]`))
+	Expect(err).To(BeNil())
+	Expect(calls).To(Equal([]string{"first", "second"}))
+
+	// What was started before a failure is closed again
+	calls = nil
+	_, err = config.Build([]byte(`providers: [{name: test_on_built, options: {name: first}}, {name: test_on_built, options: {name: second, fail: true}}]`))
+	Expect(err).To(MatchError(`config: providers[1] (test_on_built): failed to start`))
+	Expect(calls).To(Equal([]string{"first", "second", "close first"}))
+}
+
+func TestOpenChain(t *testing.T) {
+	RegisterTestingT(t)
+	path := outputFile(t)
+
+	var output io.Writer
+	config.Register("test_writer", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		output = options.Writer("output", nil)
+		return nextProvider, options.Err()
+	})
+
+	chain, err := config.Parse([]byte(`providers: [{name: merry}, {name: test_writer, options: {output: "file://` + path + `"}}]`))
+	Expect(err).To(BeNil())
+	_, closer, err := config.OpenChain(chain)
+	Expect(err).To(BeNil())
+	_, err = output.Write([]byte("written\n"))
+	Expect(err).To(BeNil())
+	Expect(closer.Close()).To(Succeed())
+	_, err = output.Write([]byte("after close\n"))
+	Expect(err).To(MatchError(os.ErrClosed))
+	Expect(readOutput(path)).To(Equal("written\n"))
+
+	// Files are closed if a provider before them in the chain fails to build
+	_, err = config.Build([]byte(`providers: [{name: rollbar}, {name: test_writer, options: {output: "file://` + path + `"}}]`))
+	Expect(err).To(MatchError(ContainSubstring(`missing required option "token"`)))
+	_, err = output.Write([]byte("after failure\n"))
+	Expect(err).To(MatchError(os.ErrClosed))
+
+	// The standard streams are left open
+	_, closer, err = config.OpenChain(config.Chain{Providers: []config.ProviderConfig{
+		{Name: "test_writer", Options: map[string]interface{}{"output": "stderr"}},
+	}})
+	Expect(err).To(BeNil())
+	Expect(closer.Close()).To(Succeed())
+	Expect(output).To(Equal(os.Stderr))
+	_, err = os.Stderr.Write(nil)
+	Expect(err).To(BeNil())
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"github.com/myhelix/contextlogger/providers"
+
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Environment variables read by FromEnv
+const (
+	// Minimum level to log; defaults to info
+	EnvLevel = "CONTEXTLOGGER_LEVEL"
+	// json, text or logfmt; defaults to text
+	EnvFormat = "CONTEXTLOGGER_FORMAT"
+	// stderr, stdout or file:///path; defaults to stderr
+	EnvOutput = "CONTEXTLOGGER_OUTPUT"
+	// If true, capture where each log call was reported from (see providers/reported_at)
+	EnvReportCapture = "CONTEXTLOGGER_REPORT_CAPTURE"
+)
+
+// EnvChain describes the standard chain (reported_at, merry, logrus) as configured by the
+// CONTEXTLOGGER_* environment variables.
+func EnvChain() (Chain, error) {
+	var chain Chain
+
+	if capture := os.Getenv(EnvReportCapture); capture != "" {
+		enabled, err := strconv.ParseBool(capture)
+		if err != nil {
+			return chain, fmt.Errorf("config: %s must be a boolean, not %q", EnvReportCapture, capture)
+		}
+		if enabled {
+			chain.Providers = append(chain.Providers, ProviderConfig{Name: "reported_at"})
+		}
+	}
+
+	output := map[string]interface{}{}
+	for option, variable := range map[string]string{
+		"level":  EnvLevel,
+		"format": EnvFormat,
+		"output": EnvOutput,
+	} {
+		if val := os.Getenv(variable); val != "" {
+			output[option] = val
+		}
+	}
+
+	chain.Providers = append(chain.Providers,
+		ProviderConfig{Name: "merry"},
+		ProviderConfig{Name: "logrus", Options: output},
+	)
+	return chain, nil
+}
+
+// FromEnv builds the chain described by EnvChain. The providers it uses must be registered,
+// i.e. their packages imported, as with Build.
+func FromEnv() (providers.LogProvider, error) {
+	chain, err := EnvChain()
+	if err != nil {
+		return nil, err
+	}
+	provider, err := BuildChain(chain)
+	if err != nil {
+		return nil, fmt.Errorf("%w (configured from CONTEXTLOGGER_* environment variables)", err)
+	}
+	return provider, nil
+}
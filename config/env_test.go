@@ -0,0 +1,82 @@
+package config_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+
+	"github.com/ansel1/merry"
+	. "github.com/onsi/gomega"
+)
+
+func setenv(t *testing.T, env map[string]string) {
+	for _, variable := range []string{config.EnvLevel, config.EnvFormat, config.EnvOutput, config.EnvReportCapture} {
+		previous, wasSet := os.LookupEnv(variable)
+		if val, ok := env[variable]; ok {
+			os.Setenv(variable, val)
+		} else {
+			os.Unsetenv(variable)
+		}
+		variable := variable
+		t.Cleanup(func() {
+			if wasSet {
+				os.Setenv(variable, previous)
+			} else {
+				os.Unsetenv(variable)
+			}
+		})
+	}
+}
+
+func TestEnvChainDefaults(t *testing.T) {
+	RegisterTestingT(t)
+	setenv(t, nil)
+
+	chain, err := config.EnvChain()
+	Expect(err).To(BeNil())
+	Expect(chain).To(Equal(config.Chain{Providers: []config.ProviderConfig{
+		{Name: "merry"},
+		{Name: "logrus", Options: map[string]interface{}{}},
+	}}))
+}
+
+func TestConfigureFromEnv(t *testing.T) {
+	RegisterTestingT(t)
+	path := outputFile(t)
+	setenv(t, map[string]string{
+		config.EnvLevel:         "warn",
+		config.EnvFormat:        "json",
+		config.EnvOutput:        "file://" + path,
+		config.EnvReportCapture: "1",
+	})
+	defaultProvider := log.DefaultProvider()
+	defer log.SetDefaultProvider(defaultProvider)
+
+	Expect(log.ConfigureFromEnv()).To(Succeed())
+	log.Info("hidden")
+	log.Warn(merry.New("it broke").WithValue("how", "badly"))
+
+	output := readOutput(path)
+	Expect(output).NotTo(ContainSubstring("hidden"))
+	Expect(output).To(ContainSubstring(`"how":"badly"`))
+	Expect(output).To(ContainSubstring(`"level":"warning"`))
+	Expect(output).To(ContainSubstring(`"reportedAt":`))
+}
+
+func TestConfigureFromEnvErrors(t *testing.T) {
+	RegisterTestingT(t)
+	defaultProvider := log.DefaultProvider()
+
+	setenv(t, map[string]string{config.EnvReportCapture: "yes please"})
+	Expect(log.ConfigureFromEnv()).To(MatchError(`config: CONTEXTLOGGER_REPORT_CAPTURE must be a boolean, not "yes please"`))
+
+	setenv(t, map[string]string{config.EnvFormat: "xml"})
+	err := log.ConfigureFromEnv()
+	Expect(err).NotTo(BeNil())
+	Expect(err.Error()).To(ContainSubstring(`option "format" must be one of text|json|logfmt, not "xml"`))
+	Expect(err.Error()).To(ContainSubstring(`CONTEXTLOGGER_* environment variables`))
+
+	Expect(log.DefaultProvider()).To(BeIdenticalTo(defaultProvider))
+}
@@ -0,0 +1,295 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options gives a Factory typed access to the options of one provider in the chain. Problems
+// (missing or malformed values) are collected rather than returned, so a factory can read all its
+// options up front and the user sees every problem at once; any option that a factory never
+// looked at is reported as unknown.
+type Options struct {
+	values  map[string]interface{}
+	used    map[string]bool
+	errs    []string
+	built   []func() error
+	closers []func() error
+}
+
+// NewOptions is for constructing options programmatically, e.g. to call a Factory directly.
+func NewOptions(values map[string]interface{}) *Options {
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	return &Options{values: values, used: make(map[string]bool)}
+}
+
+// Add a problem found by the factory itself, e.g. an option value its constructor rejected
+func (o *Options) Errorf(format string, args ...interface{}) {
+	o.errs = append(o.errs, fmt.Sprintf(format, args...))
+}
+
+func (o *Options) get(key string) (interface{}, bool) {
+	o.used[key] = true
+	val, ok := o.values[key]
+	return val, ok && val != nil
+}
+
+func (o *Options) Has(key string) bool {
+	_, ok := o.get(key)
+	return ok
+}
+
+func (o *Options) String(key, defaultValue string) string {
+	val, ok := o.get(key)
+	if !ok {
+		return defaultValue
+	}
+	switch v := val.(type) {
+	case string:
+		return v
+	case int, int64, float64, bool:
+		return fmt.Sprint(v)
+	}
+	o.Errorf("option %q must be a string, not %T", key, val)
+	return defaultValue
+}
+
+func (o *Options) RequiredString(key string) string {
+	if val, ok := o.get(key); !ok || val == "" {
+		o.Errorf("missing required option %q", key)
+		return ""
+	}
+	return o.String(key, "")
+}
+
+func (o *Options) Bool(key string, defaultValue bool) bool {
+	val, ok := o.get(key)
+	if !ok {
+		return defaultValue
+	}
+	switch v := val.(type) {
+	case bool:
+		return v
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	o.Errorf("option %q must be a boolean, not %v", key, val)
+	return defaultValue
+}
+
+func (o *Options) Int(key string, defaultValue int) int {
+	val, ok := o.get(key)
+	if !ok {
+		return defaultValue
+	}
+	switch v := val.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		if v == float64(int(v)) {
+			return int(v)
+		}
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	o.Errorf("option %q must be an integer, not %v", key, val)
+	return defaultValue
+}
+
+func (o *Options) Float(key string, defaultValue float64) float64 {
+	val, ok := o.get(key)
+	if !ok {
+		return defaultValue
+	}
+	switch v := val.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	o.Errorf("option %q must be a number, not %v", key, val)
+	return defaultValue
+}
+
+// Durations are given as strings understood by time.ParseDuration, e.g. "1m30s"
+func (o *Options) Duration(key string, defaultValue time.Duration) time.Duration {
+	val, ok := o.get(key)
+	if !ok {
+		return defaultValue
+	}
+	if s, ok := val.(string); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d
+		}
+	}
+	o.Errorf("option %q must be a duration such as \"10s\", not %v", key, val)
+	return defaultValue
+}
+
+// Strings accepts either a list, or a single comma-separated string
+func (o *Options) Strings(key string, defaultValue []string) []string {
+	val, ok := o.get(key)
+	if !ok {
+		return defaultValue
+	}
+	switch v := val.(type) {
+	case string:
+		var result []string
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				result = append(result, s)
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				o.Errorf("option %q must be a list of strings, but contains %v", key, item)
+				return defaultValue
+			}
+			result = append(result, s)
+		}
+		return result
+	case []string:
+		return v
+	}
+	o.Errorf("option %q must be a list of strings, not %v", key, val)
+	return defaultValue
+}
+
+func (o *Options) StringMap(key string) map[string]string {
+	val, ok := o.get(key)
+	if !ok {
+		return nil
+	}
+	switch v := val.(type) {
+	case map[string]string:
+		return v
+	case map[string]interface{}:
+		result := make(map[string]string, len(v))
+		for k, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				o.Errorf("option %q must map to strings, but %q is %v", key, k, item)
+				return nil
+			}
+			result[k] = s
+		}
+		return result
+	}
+	o.Errorf("option %q must be a mapping of strings, not %v", key, val)
+	return nil
+}
+
+// Writer accepts "stderr", "stdout" or a "file:///path/to/file" URL; files are appended to, and
+// closed if the chain fails to build, or when it's closed.
+func (o *Options) Writer(key string, defaultValue io.Writer) io.Writer {
+	val := o.String(key, "")
+	if val == "" {
+		return defaultValue
+	}
+	writer, err := OpenWriter(val)
+	if err != nil {
+		o.Errorf("option %q: %v", key, err)
+		return defaultValue
+	}
+	if file, ok := writer.(*os.File); ok && file != os.Stderr && file != os.Stdout {
+		o.OnClose(file.Close)
+	}
+	return writer
+}
+
+func OpenWriter(spec string) (io.Writer, error) {
+	switch {
+	case spec == "stderr":
+		return os.Stderr, nil
+	case spec == "stdout":
+		return os.Stdout, nil
+	case strings.HasPrefix(spec, "file://"):
+		path := strings.TrimPrefix(spec, "file://")
+		if path == "" {
+			return nil, errors.New("file URL has no path")
+		}
+		return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	}
+	return nil, fmt.Errorf("unknown output %q (expected stderr, stdout or file:///path)", spec)
+}
+
+// OneOf returns the option value, which must be one of choices; the first choice is the default.
+func (o *Options) OneOf(key string, choices ...string) string {
+	val := o.String(key, choices[0])
+	for _, choice := range choices {
+		if val == choice {
+			return val
+		}
+	}
+	o.Errorf("option %q must be one of %s, not %q", key, strings.Join(choices, "|"), val)
+	return choices[0]
+}
+
+// OnBuilt has f called once the whole chain has been built, for side effects that shouldn't
+// happen if another provider in the chain fails to build, such as listening on a port; if f
+// fails, so does the build, and the OnClose functions registered so far are called.
+func (o *Options) OnBuilt(f func() error) {
+	o.built = append(o.built, f)
+}
+
+// OnClose has f called when the chain is closed (see OpenChain), or if it fails to build, to undo
+// what the factory or its OnBuilt functions did, such as closing a listener.
+func (o *Options) OnClose(f func() error) {
+	o.closers = append(o.closers, f)
+}
+
+// Call the OnClose functions, last first, returning the first error
+func (o *Options) close() (err error) {
+	for i := len(o.closers) - 1; i >= 0; i-- {
+		if closeErr := o.closers[i](); err == nil {
+			err = closeErr
+		}
+	}
+	o.closers = nil
+	return err
+}
+
+func (o *Options) Err() error {
+	if len(o.errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(o.errs, "; "))
+}
+
+func (o *Options) unused() error {
+	var unknown []string
+	for key := range o.values {
+		if !o.used[key] {
+			unknown = append(unknown, strconv.Quote(key))
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown option(s) %s", strings.Join(unknown, ", "))
+}
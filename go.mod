@@ -5,10 +5,17 @@ go 1.16
 require (
 	github.com/ansel1/merry v1.8.0
 	github.com/go-errors/errors v1.5.1
+	github.com/go-kit/log v0.2.1
+	github.com/go-logr/logr v1.2.4
+	github.com/golang/snappy v0.0.4
 	github.com/myhelix/rollbar v0.4.3
 	github.com/newrelic/go-agent v1.11.0
 	github.com/onsi/ginkgo/v2 v2.1.0
 	github.com/onsi/gomega v1.17.0
+	github.com/rs/zerolog v1.26.1
 	github.com/sirupsen/logrus v1.9.3
 	github.com/stretchr/testify v1.8.3
+	go.uber.org/zap v1.21.0
+	google.golang.org/grpc v1.44.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,40 @@
+/*
+This package writes JSON values for the providers that build their own documents, or makes them safe
+to marshal for those that don't, so that one value which can't be encoded doesn't lose the whole
+document.
+*/
+package jsonenc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Write ,"key":val
+func WritePair(buf *bytes.Buffer, key string, val interface{}) {
+	buf.WriteByte(',')
+	WriteValue(buf, key)
+	buf.WriteByte(':')
+	WriteValue(buf, val)
+}
+
+// Write val as JSON; errors are written as their message, and values that json can't encode as
+// they'd be printed with %+v
+func WriteValue(buf *bytes.Buffer, val interface{}) {
+	buf.Write(Safe(val))
+}
+
+// Safe encodes val as WriteValue would, for documents which are marshalled whole (e.g. with fields
+// in a map), where one value json can't encode would otherwise lose the whole document
+func Safe(val interface{}) json.RawMessage {
+	if err, ok := val.(error); ok {
+		val = err.Error()
+	}
+	encoded, err := json.Marshal(val)
+	if err != nil {
+		// e.g. a channel, or a float that's NaN
+		encoded, _ = json.Marshal(fmt.Sprintf("%+v", val))
+	}
+	return encoded
+}
@@ -0,0 +1,26 @@
+package log
+
+import (
+	"github.com/myhelix/contextlogger/config"
+)
+
+/*
+ConfigureFromEnv builds the standard provider chain from the CONTEXTLOGGER_* environment variables
+(see config.EnvChain) and installs it as the default provider. The logrus, merry and reported_at
+provider packages have to be imported somewhere in the program for this to work, since log can't
+import them itself:
+
+	import (
+		_ "github.com/myhelix/contextlogger/providers/logrus"
+		_ "github.com/myhelix/contextlogger/providers/merry"
+		_ "github.com/myhelix/contextlogger/providers/reported_at"
+	)
+*/
+func ConfigureFromEnv() error {
+	provider, err := config.FromEnv()
+	if err != nil {
+		return err
+	}
+	SetDefaultProvider(provider)
+	return nil
+}
@@ -12,7 +12,11 @@ import (
 	"github.com/myhelix/contextlogger/providers/dummy"
 
 	"context"
+	"encoding/hex"
+	"net/http"
 	"os"
+	"sort"
+	"sync"
 )
 
 type Metrics map[string]interface{}
@@ -37,6 +41,9 @@ func init() {
 type contextLogProviderKey struct{}
 type contextLogFieldsKey struct{}
 type contextStackKey struct{}
+type contextRequestKey struct{}
+type contextSpanKey struct{}
+type contextCallerKey struct{}
 
 /*
 ContextLoggers are designed to be passed around for convenience within a given project; APIs
@@ -129,28 +136,70 @@ func (c contextLogger) WithFields(fields Fields) ContextLogger {
 	return contextLogger{ContextWithFields(c.Context, fields), c.provider}
 }
 
+// The fields of a context, and those of the context it was derived from, so the order they were
+// added in can be worked out when a provider asks for it, rather than on every ContextWithFields
+type contextFields struct {
+	fields Fields
+	parent *contextFields
+
+	once sync.Once
+	keys []string
+}
+
+func (c *contextFields) orderedKeys() []string {
+	c.once.Do(func() {
+		var parentKeys []string
+		var parentFields Fields
+		if c.parent != nil {
+			parentKeys = c.parent.orderedKeys()
+			parentFields = c.parent.fields
+		}
+		keys := make([]string, len(parentKeys), len(c.fields))
+		copy(keys, parentKeys)
+		for k := range c.fields {
+			if _, exists := parentFields[k]; !exists {
+				keys = append(keys, k)
+			}
+		}
+		// A map has no order, so fields added together are sorted
+		sort.Strings(keys[len(parentKeys):])
+		c.keys = keys
+	})
+	return c.keys
+}
+
 // This is mostly for use by LogProviders; adds fields to a raw context.Context
 // If you're looking to derive from the default ContextLogger, you want log.WithFields
 func ContextWithFields(ctx context.Context, fields Fields) context.Context {
 	var combinedFields = make(Fields)
-	if existingFields, ok := ctx.Value(contextLogFieldsKey{}).(Fields); ok {
-		for k, v := range existingFields {
+	parent, _ := ctx.Value(contextLogFieldsKey{}).(*contextFields)
+	if parent != nil {
+		for k, v := range parent.fields {
 			combinedFields[k] = v
 		}
 	}
 	for k, v := range fields {
 		combinedFields[k] = v
 	}
-	return context.WithValue(ctx, contextLogFieldsKey{}, combinedFields)
+	return context.WithValue(ctx, contextLogFieldsKey{}, &contextFields{fields: combinedFields, parent: parent})
 }
 
 func FieldsFromContext(ctx context.Context) Fields {
-	if fields, ok := ctx.Value(contextLogFieldsKey{}).(Fields); ok {
-		return fields
+	if c, ok := ctx.Value(contextLogFieldsKey{}).(*contextFields); ok {
+		return c.fields
 	}
 	return make(Fields)
 }
 
+// The keys of FieldsFromContext, in the order they were first added; fields added together (in one
+// ContextWithFields) are sorted. The slice is shared, so mustn't be modified.
+func OrderedKeys(ctx context.Context) []string {
+	if c, ok := ctx.Value(contextLogFieldsKey{}).(*contextFields); ok {
+		return c.orderedKeys()
+	}
+	return nil
+}
+
 func ContextWithStack(ctx context.Context, stack []uintptr) context.Context {
 	return context.WithValue(ctx, contextStackKey{}, stack)
 }
@@ -162,6 +211,65 @@ func StackFromContext(ctx context.Context) []uintptr {
 	return nil
 }
 
+type caller struct {
+	file string
+	line int
+}
+
+// Attach where the log call was made from, for providers (e.g. reported_at) that would otherwise
+// look for it on the stack; for adapters, which know better than the stack who their caller is
+func ContextWithCaller(ctx context.Context, file string, line int) context.Context {
+	return context.WithValue(ctx, contextCallerKey{}, caller{file, line})
+}
+
+func CallerFromContext(ctx context.Context) (file string, line int, ok bool) {
+	c, ok := ctx.Value(contextCallerKey{}).(caller)
+	return c.file, c.line, ok
+}
+
+// Attach the HTTP request being handled, for reporting providers to include with errors
+func ContextWithRequest(ctx context.Context, req *http.Request) context.Context {
+	return context.WithValue(ctx, contextRequestKey{}, req)
+}
+
+func RequestFromContext(ctx context.Context) *http.Request {
+	if req, ok := ctx.Value(contextRequestKey{}).(*http.Request); ok {
+		return req
+	}
+	return nil
+}
+
+// Identifies the trace span (e.g. from OpenTelemetry, or a W3C traceparent header) that a log call
+// is part of, for providers which can link logs to traces
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	// W3C trace flags; bit 0 means sampled
+	TraceFlags byte
+}
+
+func (s SpanContext) IsValid() bool {
+	return s.TraceID != [16]byte{} && s.SpanID != [8]byte{}
+}
+
+func (s SpanContext) TraceIDString() string {
+	return hex.EncodeToString(s.TraceID[:])
+}
+
+func (s SpanContext) SpanIDString() string {
+	return hex.EncodeToString(s.SpanID[:])
+}
+
+func ContextWithSpanContext(ctx context.Context, span SpanContext) context.Context {
+	return context.WithValue(ctx, contextSpanKey{}, span)
+}
+
+// The span attached by ContextWithSpanContext, if it is valid
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	span, ok := ctx.Value(contextSpanKey{}).(SpanContext)
+	return span, ok && span.IsValid()
+}
+
 func FromContext(ctx context.Context) ContextLogger {
 	if provider, ok := ctx.Value(contextLogProviderKey{}).(providers.LogProvider); ok {
 		return contextLogger{ctx, provider}
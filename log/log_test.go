@@ -0,0 +1,62 @@
+package log
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestOrderedKeys(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	Expect(OrderedKeys(ctx)).To(BeEmpty())
+
+	parent := ContextWithFields(ctx, Fields{"user": "sam", "request": "abc"})
+	child := ContextWithFields(parent, Fields{"order": 42, "user": "alex"})
+	// Each is worked out once, whichever is asked for first
+	Expect(OrderedKeys(child)).To(Equal([]string{"request", "user", "order"}))
+	Expect(OrderedKeys(parent)).To(Equal([]string{"request", "user"}))
+	Expect(FieldsFromContext(child)).To(Equal(Fields{"user": "alex", "request": "abc", "order": 42}))
+	Expect(FieldsFromContext(parent)).To(Equal(Fields{"user": "sam", "request": "abc"}))
+
+	// Changing the map afterwards doesn't change the context
+	fields := Fields{"attempt": 1}
+	ctx = ContextWithFields(child, fields)
+	fields["later"] = true
+	Expect(OrderedKeys(ctx)).To(Equal([]string{"request", "user", "order", "attempt"}))
+
+	// Providers may ask for the keys from several goroutines at once
+	ctx = ContextWithFields(child, Fields{"concurrent": true})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Expect(OrderedKeys(ctx)).To(HaveLen(4))
+		}()
+	}
+	wg.Wait()
+}
+
+// Fields are added far more often than their order is wanted, so working it out is left to
+// OrderedKeys
+func BenchmarkContextWithFields(b *testing.B) {
+	ctx := ContextWithFields(context.Background(), Fields{"request": "abc", "user": "sam", "route": "/orders"})
+	fields := Fields{"attempt": 1, "order": 42}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ContextWithFields(ctx, fields)
+	}
+}
+
+func BenchmarkOrderedKeys(b *testing.B) {
+	ctx := ContextWithFields(context.Background(), Fields{"request": "abc", "user": "sam", "route": "/orders"})
+	fields := Fields{"attempt": 1, "order": 42}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		OrderedKeys(ContextWithFields(ctx, fields))
+	}
+}
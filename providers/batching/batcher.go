@@ -0,0 +1,148 @@
+/*
+This package collects items (e.g. encoded log entries) into batches for providers which send them
+somewhere, so that each call to the logger doesn't mean a network round trip. A batch is handed to
+the send function when it reaches a size limit, when its first item has waited FlushInterval, or
+when Flush is called (which providers should do from Wait).
+
+Batches are sent one at a time, in order, by a background goroutine; if sending falls behind by
+more than QueueSize batches, further batches are dropped rather than blocking the logger. Retry
+helps send functions cope with transient failures, such as an HTTP endpoint that is overloaded.
+*/
+package batching
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	// Send the batch once it has this many items; 0 for no limit
+	MaxItems int
+	// Send the batch before adding an item would take it over this many bytes; 0 for no limit
+	MaxBytes int
+	// Send a batch this long after its first item was added; defaults to 1s
+	FlushInterval time.Duration
+	// How many batches can be waiting to be sent; defaults to 10
+	QueueSize int
+}
+
+type Batcher struct {
+	// Accessed atomically, so first for alignment
+	dropped int64
+
+	config Config
+	send   func(items []interface{})
+
+	mutex sync.Mutex
+	items []interface{}
+	bytes int
+	timer *time.Timer
+	// Incremented each time a batch is started, so a late timer doesn't cut the next one short
+	generation int
+
+	queue chan []interface{}
+	// Batches queued or being sent, so Flush knows when we're done
+	pending     int
+	pendingCond *sync.Cond
+}
+
+func New(config Config, send func(items []interface{})) *Batcher {
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 10
+	}
+	b := &Batcher{
+		config:      config,
+		send:        send,
+		queue:       make(chan []interface{}, config.QueueSize),
+		pendingCond: sync.NewCond(new(sync.Mutex)),
+	}
+	go b.sendBatches()
+	return b
+}
+
+// Add an item, counting size bytes towards MaxBytes
+func (b *Batcher) Add(item interface{}, size int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.config.MaxBytes > 0 && len(b.items) > 0 && b.bytes+size > b.config.MaxBytes {
+		b.flush()
+	}
+	b.items = append(b.items, item)
+	b.bytes += size
+	if len(b.items) == 1 {
+		b.generation++
+		generation := b.generation
+		b.timer = time.AfterFunc(b.config.FlushInterval, func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			if b.generation == generation {
+				b.flush()
+			}
+		})
+	}
+	if b.config.MaxItems > 0 && len(b.items) >= b.config.MaxItems {
+		b.flush()
+	}
+}
+
+// Must be called with mutex held
+func (b *Batcher) flush() {
+	if len(b.items) == 0 {
+		return
+	}
+	b.timer.Stop()
+	// Invalidate the timer, in case it has already fired and is waiting for the mutex
+	b.generation++
+	batch := b.items
+	b.items = nil
+	b.bytes = 0
+
+	b.pendingCond.L.Lock()
+	b.pending++
+	b.pendingCond.L.Unlock()
+	select {
+	case b.queue <- batch:
+	default:
+		atomic.AddInt64(&b.dropped, int64(len(batch)))
+		b.done()
+	}
+}
+
+func (b *Batcher) done() {
+	b.pendingCond.L.Lock()
+	b.pending--
+	if b.pending == 0 {
+		b.pendingCond.Broadcast()
+	}
+	b.pendingCond.L.Unlock()
+}
+
+func (b *Batcher) sendBatches() {
+	for batch := range b.queue {
+		b.send(batch)
+		b.done()
+	}
+}
+
+// Send the current batch, and wait until it and any others queued have been sent
+func (b *Batcher) Flush() {
+	b.mutex.Lock()
+	b.flush()
+	b.mutex.Unlock()
+
+	b.pendingCond.L.Lock()
+	for b.pending > 0 {
+		b.pendingCond.Wait()
+	}
+	b.pendingCond.L.Unlock()
+}
+
+// How many items have been dropped because the queue was full
+func (b *Batcher) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
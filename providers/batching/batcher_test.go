@@ -0,0 +1,92 @@
+package batching
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// Batches sent so far
+var sent [][]interface{}
+var sentMutex sync.Mutex
+
+func setup(t *testing.T, config Config) *Batcher {
+	RegisterTestingT(t)
+
+	sent = nil
+	return New(config, func(items []interface{}) {
+		sentMutex.Lock()
+		defer sentMutex.Unlock()
+		sent = append(sent, items)
+	})
+}
+
+func batches() [][]interface{} {
+	sentMutex.Lock()
+	defer sentMutex.Unlock()
+	return sent
+}
+
+func TestMaxItems(t *testing.T) {
+	b := setup(t, Config{MaxItems: 2, FlushInterval: time.Hour})
+
+	for i := 0; i < 5; i++ {
+		b.Add(i, 1)
+	}
+	Eventually(batches).Should(Equal([][]interface{}{{0, 1}, {2, 3}}))
+	b.Flush()
+	Expect(batches()).To(Equal([][]interface{}{{0, 1}, {2, 3}, {4}}))
+}
+
+func TestMaxBytes(t *testing.T) {
+	b := setup(t, Config{MaxBytes: 10, FlushInterval: time.Hour})
+
+	b.Add("a", 4)
+	b.Add("b", 6)
+	b.Add("c", 1)
+	// Bigger than MaxBytes on its own, so it gets a batch to itself
+	b.Add("d", 20)
+	b.Add("e", 1)
+	b.Flush()
+	Expect(batches()).To(Equal([][]interface{}{{"a", "b"}, {"c"}, {"d"}, {"e"}}))
+
+	// Nothing more to send
+	b.Flush()
+	Expect(batches()).To(HaveLen(4))
+}
+
+func TestFlushInterval(t *testing.T) {
+	b := setup(t, Config{FlushInterval: 50 * time.Millisecond})
+
+	start := time.Now()
+	b.Add("a", 1)
+	b.Add("b", 1)
+	Eventually(batches).Should(Equal([][]interface{}{{"a", "b"}}))
+	Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+
+	// A new batch gets its own interval
+	b.Add("c", 1)
+	Consistently(batches, 25*time.Millisecond).Should(HaveLen(1))
+	Eventually(batches).Should(HaveLen(2))
+}
+
+func TestDropped(t *testing.T) {
+	RegisterTestingT(t)
+
+	block := make(chan struct{})
+	var sentCount int
+	b := New(Config{MaxItems: 1, QueueSize: 1}, func(items []interface{}) {
+		<-block
+		sentCount += len(items)
+	})
+	// One being sent, one queued, and the rest dropped
+	for i := 0; i < 5; i++ {
+		b.Add(i, 1)
+	}
+	Eventually(b.Dropped).Should(BeNumerically(">=", 3))
+	close(block)
+	b.Flush()
+	Expect(int64(sentCount) + b.Dropped()).To(BeNumerically("==", 5))
+}
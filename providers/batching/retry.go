@@ -0,0 +1,49 @@
+package batching
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry calls attempt until it succeeds, or has been retried maxRetries times, and returns its last
+// error. attempt also returns how long to wait before trying again: 0 for the backoff (which
+// starts at the given duration and doubles each time), or negative if the error is permanent.
+// Longer waits (e.g. from Retry-After) are cut to the longest the backoff would reach, so a server
+// can't hold up Wait for longer than the configuration allows.
+func Retry(maxRetries int, backoff time.Duration, attempt func() (time.Duration, error)) error {
+	maxWait := backoff
+	for i := 1; i < maxRetries && maxWait < time.Hour; i++ {
+		maxWait *= 2
+	}
+	for retries := 0; ; retries++ {
+		wait, err := attempt()
+		if err == nil || wait < 0 || retries >= maxRetries {
+			return err
+		}
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		} else if wait > maxWait {
+			wait = maxWait
+		}
+		time.Sleep(wait)
+	}
+}
+
+// RetryDelay classifies an unsuccessful HTTP response for Retry: 408, 429 and server errors
+// (which are often from an overloaded or restarting server) are retried after any Retry-After
+// delay, and the rest are permanent, as is 501, since the server will never support the request.
+func RetryDelay(resp *http.Response) time.Duration {
+	switch {
+	case resp.StatusCode == http.StatusNotImplemented:
+		return -1
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode/100 == 5:
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		return 0
+	}
+	return -1
+}
@@ -0,0 +1,77 @@
+package batching
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRetry(t *testing.T) {
+	RegisterTestingT(t)
+
+	var attempts int
+	failing := errors.New("failing")
+	err := Retry(3, time.Millisecond, func() (time.Duration, error) {
+		attempts++
+		if attempts < 3 {
+			return 0, failing
+		}
+		return 0, nil
+	})
+	Expect(err).To(BeNil())
+	Expect(attempts).To(Equal(3))
+
+	attempts = 0
+	err = Retry(2, time.Millisecond, func() (time.Duration, error) {
+		attempts++
+		return 0, failing
+	})
+	Expect(err).To(Equal(failing))
+	Expect(attempts).To(Equal(3))
+
+	// Permanent errors aren't retried
+	attempts = 0
+	err = Retry(2, time.Millisecond, func() (time.Duration, error) {
+		attempts++
+		return -1, failing
+	})
+	Expect(err).To(Equal(failing))
+	Expect(attempts).To(Equal(1))
+
+	// A server asking for a long wait only gets as long as the backoff would reach (4ms here)
+	attempts = 0
+	start := time.Now()
+	err = Retry(3, time.Millisecond, func() (time.Duration, error) {
+		attempts++
+		if attempts < 3 {
+			return 24 * time.Hour, failing
+		}
+		return 0, nil
+	})
+	Expect(err).To(BeNil())
+	Expect(attempts).To(Equal(3))
+	Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+}
+
+func TestRetryDelay(t *testing.T) {
+	RegisterTestingT(t)
+
+	response := func(status int, retryAfter string) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: make(http.Header)}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return resp
+	}
+	Expect(RetryDelay(response(http.StatusServiceUnavailable, ""))).To(Equal(time.Duration(0)))
+	Expect(RetryDelay(response(http.StatusTooManyRequests, "3"))).To(Equal(3 * time.Second))
+	// HTTP dates aren't worth the trouble; the backoff will do
+	Expect(RetryDelay(response(http.StatusTooManyRequests, "Wed, 21 Oct 2015 07:28:00 GMT"))).To(Equal(time.Duration(0)))
+	Expect(RetryDelay(response(http.StatusBadRequest, ""))).To(BeNumerically("<", 0))
+	Expect(RetryDelay(response(http.StatusInternalServerError, ""))).To(Equal(time.Duration(0)))
+	Expect(RetryDelay(response(http.StatusRequestTimeout, ""))).To(Equal(time.Duration(0)))
+	Expect(RetryDelay(response(http.StatusNotImplemented, ""))).To(BeNumerically("<", 0))
+}
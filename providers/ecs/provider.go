@@ -0,0 +1,338 @@
+/*
+This package writes JSON log lines following the Elastic Common Schema, for Kibana dashboards that
+expect ECS field names. The time, level and message become @timestamp, log.level and message, and
+well-known fields from other providers are renamed according to the mapping (see DefaultMapping),
+with reportedAt from the reported_at provider becoming log.origin.file.name and
+log.origin.file.line. Errors among the arguments fill in error.message and error.type, a request
+attached with log.ContextWithRequest fills in http.request.method, url.full and so on, and a span
+from log.ContextWithSpanContext fills in trace.id and span.id.
+
+Dotted names are written as nested objects, as ECS documents are, so {"http.request.id": "abc"}
+becomes {"http":{"request":{"id":"abc"}}}. Fields that aren't in the mapping keep their names.
+*/
+package ecs
+
+import (
+	"github.com/myhelix/contextlogger/internal/jsonenc"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The version of ECS the output follows, written as ecs.version
+const Version = "8.11.0"
+
+// Drop a field, when used as its mapping
+const Omit = "-"
+
+// Renames for fields written by this package's neighbours; Config.Mapping adds to (and can
+// override) these
+var DefaultMapping = map[string]string{
+	// From the merry provider
+	"~stackTrace": "error.stack_trace",
+	"userMessage": "labels.user_message",
+	// From RecordEvent
+	"eventName": "event.action",
+	// A common convention in our services
+	"requestId": "http.request.id",
+}
+
+// Parsed into log.origin.file.name and log.origin.file.line, unless it's in Config.Mapping
+const reportedAtField = "reportedAt"
+
+type Config struct {
+	// Defaults to os.Stdout
+	Output io.Writer
+	Level  string
+	// ECS names for application-specific fields, e.g. {"customerId": "user.id"}; use Omit to
+	// leave a field out
+	Mapping map[string]string
+	// Written as service.name, if set
+	ServiceName string
+}
+
+type provider struct {
+	providers.LogProvider
+	output      io.Writer
+	writeMutex  sync.Mutex
+	level       providers.LogLevel
+	mapping     map[string]string
+	serviceName string
+
+	// For tests
+	now func() time.Time
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	level, err := providers.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+	mapping := make(map[string]string)
+	for field, name := range DefaultMapping {
+		mapping[field] = name
+	}
+	for field, name := range config.Mapping {
+		if name == "" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+			return nil, fmt.Errorf("ecs: mapping for %q is not a valid field name: %q", field, name)
+		}
+		mapping[field] = name
+	}
+	return &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		output:      config.Output,
+		level:       level,
+		mapping:     mapping,
+		serviceName: config.ServiceName,
+		now:         time.Now,
+	}, nil
+}
+
+// A JSON object being built from dotted names
+type object map[string]interface{}
+
+// Set the value at a dotted path, creating objects along the way. Fails (returning false) if
+// something other than an object is in the way, or if the path is already set.
+func (o object) set(path string, val interface{}) bool {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, exists := o[part]
+		if !exists {
+			next = make(object)
+			o[part] = next
+		}
+		child, ok := next.(object)
+		if !ok {
+			return false
+		}
+		o = child
+	}
+	last := parts[len(parts)-1]
+	if _, exists := o[last]; exists {
+		return false
+	}
+	o[last] = val
+	return true
+}
+
+func (p *provider) document(ctx context.Context, level providers.LogLevel, message string, args []interface{}, metrics map[string]interface{}) object {
+	doc := make(object)
+	doc.set("@timestamp", p.now().UTC().Format(time.RFC3339Nano))
+	doc.set("log.level", level.String())
+	doc.set("message", message)
+	doc.set("ecs.version", Version)
+	if p.serviceName != "" {
+		doc.set("service.name", p.serviceName)
+	}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			doc.set("error.message", err.Error())
+			doc.set("error.type", fmt.Sprintf("%T", err))
+			break
+		}
+	}
+	if span, ok := log.SpanContextFromContext(ctx); ok {
+		doc.set("trace.id", span.TraceIDString())
+		doc.set("span.id", span.SpanIDString())
+	}
+	if req := log.RequestFromContext(ctx); req != nil {
+		setRequest(doc, req)
+	}
+
+	fields := log.FieldsFromContext(ctx)
+	keys := log.OrderedKeys(ctx)
+	metricKeys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		metricKeys = append(metricKeys, key)
+	}
+	sort.Strings(metricKeys)
+
+	// Metrics first, so they take precedence over fields of the same name; the standard fields
+	// above take precedence over both
+	for _, key := range metricKeys {
+		p.setField(doc, key, metrics[key])
+	}
+	for _, key := range keys {
+		p.setField(doc, key, fields[key])
+	}
+	return doc
+}
+
+func (p *provider) setField(doc object, key string, val interface{}) {
+	name, mapped := p.mapping[key]
+	if name == Omit {
+		return
+	}
+	if !mapped {
+		if key == reportedAtField {
+			if s, ok := val.(string); ok {
+				if i := strings.LastIndexByte(s, ':'); i > 0 {
+					if line, err := strconv.Atoi(s[i+1:]); err == nil {
+						doc.set("log.origin.file.name", s[:i])
+						doc.set("log.origin.file.line", line)
+						return
+					}
+				}
+			}
+		}
+		name = key
+	}
+	if err, ok := val.(error); ok {
+		val = err.Error()
+	}
+	if !doc.set(name, val) {
+		// Clashes with another field (e.g. "http" when we have http.request.method), so keep it
+		// under labels, where it's less likely to
+		doc.set("labels."+strings.Replace(key, ".", "_", -1), val)
+	}
+}
+
+func setRequest(doc object, req *http.Request) {
+	doc.set("http.request.method", req.Method)
+	doc.set("http.version", strings.TrimPrefix(req.Proto, "HTTP/"))
+	url := *req.URL
+	if url.Host == "" {
+		// A server request, whose URL only has the path and query
+		url.Host = req.Host
+		url.Scheme = "http"
+		if req.TLS != nil {
+			url.Scheme = "https"
+		}
+	}
+	url.User = nil
+	doc.set("url.full", url.String())
+	doc.set("url.path", url.Path)
+	if userAgent := req.UserAgent(); userAgent != "" {
+		doc.set("user_agent.original", userAgent)
+	}
+	if referer := req.Referer(); referer != "" {
+		doc.set("http.request.referrer", referer)
+	}
+	if req.RemoteAddr != "" {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		doc.set("client.ip", host)
+	}
+}
+
+// Encode with @timestamp, log and message first, as the ECS logging spec recommends for people
+// reading the raw lines, then everything else sorted
+func encode(doc object) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	first := []string{"@timestamp", "log", "message"}
+	var rest []string
+	for key := range doc {
+		if key != "@timestamp" && key != "log" && key != "message" {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	for i, key := range append(first, rest...) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeValue(&buf, key)
+		buf.WriteByte(':')
+		writeValue(&buf, doc[key])
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func writeValue(buf *bytes.Buffer, val interface{}) {
+	if o, ok := val.(object); ok {
+		// Encoded ourselves, so one bad value doesn't lose the whole object
+		keys := make([]string, 0, len(o))
+		for key := range o {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeValue(buf, key)
+			buf.WriteByte(':')
+			writeValue(buf, o[key])
+		}
+		buf.WriteByte('}')
+		return
+	}
+	jsonenc.WriteValue(buf, val)
+}
+
+func (p *provider) write(line []byte) {
+	p.writeMutex.Lock()
+	_, err := p.output.Write(line)
+	p.writeMutex.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write to log, %v\n", err)
+	}
+}
+
+func (p *provider) log(ctx context.Context, level providers.LogLevel, args []interface{}) {
+	if level > p.level {
+		return
+	}
+	p.write(encode(p.document(ctx, level, fmt.Sprint(args...), args, nil)))
+}
+
+func (p *provider) record(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	if providers.Info > p.level {
+		return
+	}
+	if eventName != "" {
+		ctx = log.ContextWithFields(ctx, log.Fields{"eventName": eventName})
+	}
+	p.write(encode(p.document(ctx, providers.Info, "Reporting metrics", nil, metrics)))
+}
+
+func (p *provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Error, args)
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Warn, args)
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Info, args)
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Debug, args)
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName, metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
@@ -0,0 +1,167 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	. "github.com/onsi/gomega"
+)
+
+var output *bytes.Buffer
+var testProvider providers.LogProvider
+
+var testTime = time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+func setup(t *testing.T, config Config) {
+	RegisterTestingT(t)
+
+	output = new(bytes.Buffer)
+	config.Output = output
+	if config.Level == "" {
+		config.Level = "debug"
+	}
+	p, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	p.(*provider).now = func() time.Time { return testTime }
+	testProvider = p
+}
+
+func lines() []string {
+	lines := strings.SplitAfter(output.String(), "\n")
+	Expect(lines[len(lines)-1]).To(BeEmpty())
+	return lines[:len(lines)-1]
+}
+
+type uploadError struct{}
+
+func (uploadError) Error() string { return "disk full" }
+
+func TestLines(t *testing.T) {
+	setup(t, Config{Level: "info", ServiceName: "orders"})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		"~stackTrace": "main.upload\n\t/app/upload.go:20\n",
+		"userMessage": "Please try again",
+		"requestId":   "abc",
+		"attempt":     2,
+	})
+	testProvider.Error(ctx, false, "Upload failed: ", uploadError{})
+	testProvider.Debug(ctx, false, "Filtered")
+	testProvider.RecordEvent(log.ContextWithFields(context.Background(), log.Fields{"db.rows": 3}), "upload", log.Metrics{"bytes": 1024})
+
+	Expect(lines()).To(Equal([]string{
+		`{"@timestamp":"2020-01-02T03:04:05.000000006Z","log":{"level":"error"},"message":"Upload failed: disk full",` +
+			`"attempt":2,"ecs":{"version":"8.11.0"},` +
+			`"error":{"message":"disk full","stack_trace":"main.upload\n\t/app/upload.go:20\n","type":"ecs.uploadError"},` +
+			`"http":{"request":{"id":"abc"}},"labels":{"user_message":"Please try again"},"service":{"name":"orders"}}` + "\n",
+		`{"@timestamp":"2020-01-02T03:04:05.000000006Z","log":{"level":"info"},"message":"Reporting metrics",` +
+			`"bytes":1024,"db":{"rows":3},"ecs":{"version":"8.11.0"},"event":{"action":"upload"},"service":{"name":"orders"}}` + "\n",
+	}))
+}
+
+func TestRequestAndTrace(t *testing.T) {
+	setup(t, Config{})
+
+	req := httptest.NewRequest("GET", "/orders?page=2", nil)
+	req.Header.Set("User-Agent", "curl/7.64.1")
+	ctx := log.ContextWithRequest(context.Background(), req)
+	ctx = log.ContextWithSpanContext(ctx, log.SpanContext{
+		TraceID: [16]byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	})
+	ctx = log.ContextWithFields(ctx, log.Fields{"http": "clashes", "reportedAt": "/app/orders.go:42"})
+	testProvider.Info(ctx, false, "Handled")
+
+	var decoded map[string]interface{}
+	Expect(json.Unmarshal(output.Bytes(), &decoded)).To(Succeed())
+	Expect(decoded).To(Equal(map[string]interface{}{
+		"@timestamp": "2020-01-02T03:04:05.000000006Z",
+		"log": map[string]interface{}{
+			"level":  "info",
+			"origin": map[string]interface{}{"file": map[string]interface{}{"name": "/app/orders.go", "line": float64(42)}},
+		},
+		"message":    "Handled",
+		"ecs":        map[string]interface{}{"version": "8.11.0"},
+		"http":       map[string]interface{}{"request": map[string]interface{}{"method": "GET"}, "version": "1.1"},
+		"url":        map[string]interface{}{"full": "http://example.com/orders?page=2", "path": "/orders"},
+		"user_agent": map[string]interface{}{"original": "curl/7.64.1"},
+		"client":     map[string]interface{}{"ip": "192.0.2.1"},
+		"trace":      map[string]interface{}{"id": "4bf92f3577b34da6a3ce929d0e0e4736"},
+		"span":       map[string]interface{}{"id": "00f067aa0ba902b7"},
+		"labels":     map[string]interface{}{"http": "clashes"},
+	}))
+}
+
+func TestMapping(t *testing.T) {
+	setup(t, Config{Mapping: map[string]string{
+		"customerId": "user.id",
+		"password":   Omit,
+		"requestId":  "request_id",
+		"reportedAt": "log.origin.function",
+	}})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		"customerId": 7, "password": "hunter2", "requestId": "abc", "reportedAt": "main.go:1",
+	})
+	testProvider.Info(ctx, false, "Mapped")
+	Expect(lines()).To(Equal([]string{
+		`{"@timestamp":"2020-01-02T03:04:05.000000006Z","log":{"level":"info","origin":{"function":"main.go:1"}},"message":"Mapped",` +
+			`"ecs":{"version":"8.11.0"},"request_id":"abc","user":{"id":7}}` + "\n",
+	}))
+
+	_, err := LogProvider(nil, Config{Level: "info", Mapping: map[string]string{"x": "user..id"}})
+	Expect(err).To(MatchError(`ecs: mapping for "x" is not a valid field name: "user..id"`))
+}
+
+func TestReportedAt(t *testing.T) {
+	setup(t, Config{})
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"reportedAt": "/src/app/main.go:42"})
+	testProvider.Warn(ctx, false, errors.New("here"))
+
+	var decoded struct {
+		Log struct {
+			Origin struct {
+				File struct {
+					Name string
+					Line int
+				}
+			}
+		}
+	}
+	Expect(json.Unmarshal(output.Bytes(), &decoded)).To(Succeed())
+	Expect(decoded.Log.Origin.File.Name).To(Equal("/src/app/main.go"))
+	Expect(decoded.Log.Origin.File.Line).To(Equal(42))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+
+	path := filepath.Join(t.TempDir(), "ecs.log")
+	provider, err := config.Build([]byte(`
+providers:
+  - name: ecs
+    options:
+      output: file://` + path + `
+      level: warn
+      mapping: {customerId: user.id}
+`))
+	Expect(err).To(BeNil())
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"customerId": "c1"})
+	provider.Info(ctx, false, "Filtered")
+	provider.Warn(ctx, false, "Written")
+	written, err := ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	Expect(string(written)).To(HaveSuffix(`"message":"Written","ecs":{"version":"8.11.0"},"user":{"id":"c1"}}` + "\n"))
+	Expect(string(written)).NotTo(ContainSubstring("Filtered"))
+}
@@ -0,0 +1,19 @@
+package ecs
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"os"
+)
+
+func init() {
+	config.Register("ecs", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		return LogProvider(nextProvider, Config{
+			Output:      options.Writer("output", os.Stdout),
+			Level:       options.String("level", "info"),
+			Mapping:     options.StringMap("mapping"),
+			ServiceName: options.String("serviceName", ""),
+		})
+	})
+}
@@ -0,0 +1,96 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// An index name template, as alternating literal text and date patterns (converted to
+// time.Format layouts)
+type indexTemplate []indexPart
+
+type indexPart struct {
+	text   string
+	layout bool
+}
+
+// The date pattern letters we understand, longest first so yyyy isn't taken as two yy
+var dateTokens = []struct{ pattern, layout string }{
+	{"yyyy", "2006"},
+	{"yy", "06"},
+	{"MM", "01"},
+	{"dd", "02"},
+	{"HH", "15"},
+}
+
+func parseIndexTemplate(template string) (indexTemplate, error) {
+	var parts indexTemplate
+	rest := template
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			parts = append(parts, indexPart{text: rest})
+			break
+		}
+		if open > 0 {
+			parts = append(parts, indexPart{text: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("elasticsearch: unclosed { in index %q", template)
+		}
+		layout, err := dateLayout(rest[open+1 : open+end])
+		if err != nil {
+			return nil, fmt.Errorf("elasticsearch: index %q: %v", template, err)
+		}
+		parts = append(parts, indexPart{text: layout, layout: true})
+		rest = rest[open+end+1:]
+	}
+	// Elasticsearch's own rules for index names, which only the literal parts could break
+	for _, part := range parts {
+		if !part.layout && (part.text != strings.ToLower(part.text) || strings.ContainsAny(part.text, `\/*?"<>| ,#:`)) {
+			return nil, fmt.Errorf("elasticsearch: index %q must be lowercase, without any of \\/*?\"<>| ,#:", template)
+		}
+	}
+	return parts, nil
+}
+
+// Convert a date pattern such as yyyy.MM.dd to a layout such as 2006.01.02
+func dateLayout(pattern string) (string, error) {
+	var layout strings.Builder
+	for pattern != "" {
+		matched := false
+		for _, token := range dateTokens {
+			if strings.HasPrefix(pattern, token.pattern) {
+				layout.WriteString(token.layout)
+				pattern = pattern[len(token.pattern):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		switch c := pattern[0]; c {
+		case '.', '-', '_':
+			layout.WriteByte(c)
+			pattern = pattern[1:]
+		default:
+			return "", fmt.Errorf("unsupported date pattern at %q (expected yyyy, yy, MM, dd or HH)", pattern)
+		}
+	}
+	return layout.String(), nil
+}
+
+func (t indexTemplate) name(now time.Time) string {
+	var name strings.Builder
+	for _, part := range t {
+		if part.layout {
+			name.WriteString(now.Format(part.text))
+		} else {
+			name.WriteString(part.text)
+		}
+	}
+	return name.String()
+}
@@ -0,0 +1,323 @@
+/*
+This package indexes log entries in Elasticsearch or OpenSearch, through the _bulk API. Each entry
+is a document with @timestamp, level and message, then the context fields; Record and RecordEvent
+index "Reporting metrics" documents with the metrics as fields too. Documents go in a time-based
+index named from a template, e.g. logs-{yyyy.MM.dd} for one index per (UTC) day.
+
+Documents are batched, and sent every FlushInterval or when Wait is called. If the bulk request
+fails with a 429 or 5xx, or some documents in it are rejected with a 429 or 5xx (e.g. because
+a node's write queue is full), just those are retried with exponential backoff. Documents which
+still can't be indexed, or are rejected outright (e.g. for not matching the index mapping), are
+dropped and counted; see Dropped.
+*/
+package elasticsearch
+
+import (
+	"github.com/myhelix/contextlogger/internal/jsonenc"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/batching"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultIndex = "logs-{yyyy.MM.dd}"
+
+type Config struct {
+	// Base URL of the cluster; defaults to http://localhost:9200
+	URL string
+	// Index name template, with a date pattern in braces using yyyy, yy, MM, dd and HH (in UTC);
+	// defaults to DefaultIndex
+	Index string
+	// For basic authentication, if set
+	Username string
+	Password string
+	// Sent with every request, e.g. an Authorization header with an API key
+	Headers map[string]string
+	Level   string
+
+	// Send a batch once it has this many documents; defaults to 1000
+	MaxBatchDocs int
+	// Send a batch once it has this many bytes of documents; defaults to 5MiB
+	MaxBatchBytes int
+	// Defaults to 1s
+	FlushInterval time.Duration
+	// How many times to retry failed documents; defaults to 5, negative for none
+	MaxRetries int
+	// Wait before the first retry, doubling each time; defaults to 1s
+	RetryBackoff time.Duration
+	// Defaults to a client with a 30s timeout
+	HTTPClient *http.Client
+}
+
+type Provider struct {
+	providers.LogProvider
+	// Accessed atomically, so first for alignment; documents that failed to index
+	failed int64
+
+	config  Config
+	level   providers.LogLevel
+	index   indexTemplate
+	batcher *batching.Batcher
+
+	// For tests
+	now func() time.Time
+}
+
+// A bulk action and document, each encoded as a line
+type document struct {
+	action []byte
+	source []byte
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (*Provider, error) {
+	level, err := providers.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config.URL == "" {
+		config.URL = "http://localhost:9200"
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	if config.Index == "" {
+		config.Index = DefaultIndex
+	}
+	index, err := parseIndexTemplate(config.Index)
+	if err != nil {
+		return nil, err
+	}
+	if config.MaxBatchDocs <= 0 {
+		config.MaxBatchDocs = 1000
+	}
+	if config.MaxBatchBytes <= 0 {
+		config.MaxBatchBytes = 5 << 20
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 5
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = time.Second
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	p := &Provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		config:      config,
+		level:       level,
+		index:       index,
+		now:         time.Now,
+	}
+	p.batcher = batching.New(batching.Config{
+		MaxItems:      config.MaxBatchDocs,
+		MaxBytes:      config.MaxBatchBytes,
+		FlushInterval: config.FlushInterval,
+	}, p.send)
+	return p, nil
+}
+
+// How many documents have been dropped, because they were rejected, couldn't be sent, or were
+// logged faster than they could be sent
+func (p *Provider) Dropped() int64 {
+	return atomic.LoadInt64(&p.failed) + p.batcher.Dropped()
+}
+
+func (p *Provider) add(ctx context.Context, level providers.LogLevel, message string, metrics map[string]interface{}) {
+	now := p.now().UTC()
+	action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": p.index.name(now)}})
+
+	fields := log.FieldsFromContext(ctx)
+	keys := log.OrderedKeys(ctx)
+	var buf bytes.Buffer
+	buf.WriteString(`{"@timestamp":"` + now.Format(time.RFC3339Nano) + `","level":"` + level.String() + `","message":`)
+	jsonenc.WriteValue(&buf, message)
+	for _, key := range keys {
+		if _, isMetric := metrics[key]; !isMetric && !reserved[key] {
+			jsonenc.WritePair(&buf, key, fields[key])
+		}
+	}
+	metricKeys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		if !reserved[key] {
+			metricKeys = append(metricKeys, key)
+		}
+	}
+	sort.Strings(metricKeys)
+	for _, key := range metricKeys {
+		jsonenc.WritePair(&buf, key, metrics[key])
+	}
+	buf.WriteByte('}')
+
+	doc := &document{action, buf.Bytes()}
+	p.batcher.Add(doc, len(doc.action)+len(doc.source)+2)
+}
+
+// Keys of the standard document fields, which context fields and metrics can't replace
+var reserved = map[string]bool{"@timestamp": true, "level": true, "message": true}
+
+func (p *Provider) log(ctx context.Context, level providers.LogLevel, args []interface{}) {
+	if level > p.level {
+		return
+	}
+	p.add(ctx, level, fmt.Sprint(args...), nil)
+}
+
+func (p *Provider) record(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	if providers.Info > p.level {
+		return
+	}
+	if eventName != "" {
+		ctx = log.ContextWithFields(ctx, log.Fields{"eventName": eventName})
+	}
+	p.add(ctx, providers.Info, "Reporting metrics", metrics)
+}
+
+func (p *Provider) send(items []interface{}) {
+	docs := make([]*document, len(items))
+	for i, item := range items {
+		docs[i] = item.(*document)
+	}
+	err := batching.Retry(p.config.MaxRetries, p.config.RetryBackoff, func() (time.Duration, error) {
+		var wait time.Duration
+		var err error
+		docs, wait, err = p.bulk(docs)
+		return wait, err
+	})
+	if err != nil {
+		atomic.AddInt64(&p.failed, int64(len(docs)))
+		fmt.Fprintf(os.Stderr, "Failed to index %d documents in Elasticsearch, %v\n", len(docs), err)
+	}
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	// Each has one key, the action
+	Items []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// Make one bulk request, for batching.Retry. Returns the documents that should be retried, or all
+// of them if the request itself failed.
+func (p *Provider) bulk(docs []*document) ([]*document, time.Duration, error) {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		body.Write(doc.action)
+		body.WriteByte('\n')
+		body.Write(doc.source)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest("POST", p.config.URL+"/_bulk", &body)
+	if err != nil {
+		return docs, -1, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if p.config.Username != "" {
+		req.SetBasicAuth(p.config.Username, p.config.Password)
+	}
+	for key, value := range p.config.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return docs, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return docs, batching.RetryDelay(resp), fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		// We can't tell what was indexed, and retrying could duplicate it
+		return docs, -1, fmt.Errorf("invalid bulk response: %v", err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	if !result.Errors {
+		return nil, 0, nil
+	}
+	if len(result.Items) != len(docs) {
+		return docs, -1, fmt.Errorf("bulk response has %d items for %d documents", len(result.Items), len(docs))
+	}
+
+	var retry []*document
+	var rejected int
+	var firstError string
+	for i, item := range result.Items {
+		for _, outcome := range item {
+			if outcome.Status/100 == 2 {
+				continue
+			}
+			if firstError == "" {
+				firstError = fmt.Sprintf("%d %s: %s", outcome.Status, outcome.Error.Type, outcome.Error.Reason)
+			}
+			if outcome.Status == http.StatusTooManyRequests || outcome.Status/100 == 5 {
+				retry = append(retry, docs[i])
+			} else {
+				rejected++
+			}
+		}
+	}
+	if rejected > 0 {
+		// Not worth retrying, since they'll be rejected again
+		atomic.AddInt64(&p.failed, int64(rejected))
+		fmt.Fprintf(os.Stderr, "Elasticsearch rejected %d documents, e.g. %s\n", rejected, firstError)
+	}
+	if len(retry) > 0 {
+		return retry, 0, fmt.Errorf("%d documents failed, e.g. %s", len(retry), firstError)
+	}
+	return nil, 0, nil
+}
+
+func (p *Provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Error, args)
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *Provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Warn, args)
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *Provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Info, args)
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *Provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Debug, args)
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *Provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *Provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName, metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
+
+func (p *Provider) Wait() {
+	p.batcher.Flush()
+	p.LogProvider.Wait()
+}
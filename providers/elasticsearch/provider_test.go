@@ -0,0 +1,247 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	. "github.com/onsi/gomega"
+)
+
+var testProvider *Provider
+
+type bulkRequest struct {
+	header http.Header
+	// Pairs of action and document lines
+	lines []string
+}
+
+// A stand-in cluster, which records bulk requests and gives each document the next of the given
+// statuses (then 201); a status for the request as a whole is given as a negative number
+type cluster struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []bulkRequest
+	statuses []int
+}
+
+func newCluster(t *testing.T, statuses ...int) *cluster {
+	c := &cluster{statuses: statuses}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		Expect(req.URL.Path).To(Equal("/_bulk"))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+		var lines []string
+		scanner := bufio.NewScanner(req.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.requests = append(c.requests, bulkRequest{req.Header, lines})
+		if len(c.statuses) > 0 && c.statuses[0] < 0 {
+			w.WriteHeader(-c.statuses[0])
+			c.statuses = c.statuses[1:]
+			return
+		}
+
+		var items []string
+		errors := false
+		for range lines[:len(lines)/2] {
+			status := http.StatusCreated
+			if len(c.statuses) > 0 {
+				status, c.statuses = c.statuses[0], c.statuses[1:]
+			}
+			if status == http.StatusCreated {
+				items = append(items, `{"index": {"_index": "x", "status": 201}}`)
+			} else {
+				errors = true
+				items = append(items, fmt.Sprintf(`{"index": {"_index": "x", "status": %d, "error": {"type": "failure_%d", "reason": "failed"}}}`, status, status))
+			}
+		}
+		fmt.Fprintf(w, `{"took": 3, "errors": %v, "items": [%s]}`, errors, strings.Join(items, ","))
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *cluster) received() []bulkRequest {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]bulkRequest(nil), c.requests...)
+}
+
+// The message of each document in a request
+func (r bulkRequest) messages() []string {
+	var messages []string
+	for i := 1; i < len(r.lines); i += 2 {
+		var doc struct{ Message string }
+		Expect(json.Unmarshal([]byte(r.lines[i]), &doc)).To(Succeed())
+		messages = append(messages, doc.Message)
+	}
+	return messages
+}
+
+var testTime = time.Date(2020, 1, 2, 3, 4, 5, 6, time.FixedZone("EST", -5*60*60))
+
+func setup(t *testing.T, c *cluster, config Config) {
+	RegisterTestingT(t)
+
+	if config.Level == "" {
+		config.Level = "info"
+	}
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = time.Millisecond
+	}
+	config.URL = c.URL + "/"
+	provider, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	provider.now = func() time.Time { return testTime }
+	testProvider = provider
+}
+
+func TestDocuments(t *testing.T) {
+	c := newCluster(t)
+	setup(t, c, Config{Username: "elastic", Password: "secret"})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"requestId": "abc", "level": "ignored"})
+	ctx = log.ContextWithFields(ctx, log.Fields{"attempt": 2, "bytes": 1})
+	testProvider.Warn(ctx, false, "Upload ", fmt.Errorf("failed"))
+	testProvider.Debug(ctx, false, "Not indexed")
+	testProvider.RecordEvent(ctx, "upload", log.Metrics{"bytes": 1024, "message": "ignored"})
+	testProvider.Wait()
+
+	requests := c.received()
+	Expect(requests).To(HaveLen(1))
+	username, password, ok := (&http.Request{Header: requests[0].header}).BasicAuth()
+	Expect(ok).To(BeTrue())
+	Expect(username + ":" + password).To(Equal("elastic:secret"))
+	Expect(requests[0].lines).To(Equal([]string{
+		`{"index":{"_index":"logs-2020.01.02"}}`,
+		`{"@timestamp":"2020-01-02T08:04:05.000000006Z","level":"warn","message":"Upload failed","requestId":"abc","attempt":2,"bytes":1}`,
+		`{"index":{"_index":"logs-2020.01.02"}}`,
+		`{"@timestamp":"2020-01-02T08:04:05.000000006Z","level":"info","message":"Reporting metrics","requestId":"abc","attempt":2,"eventName":"upload","bytes":1024}`,
+	}))
+	Expect(testProvider.Dropped()).To(BeZero())
+}
+
+func TestRetryFailedItems(t *testing.T) {
+	// The second document is retried until it succeeds; the third can't be indexed
+	c := newCluster(t, 201, 429, 400, 201, 503, 201)
+	setup(t, c, Config{})
+
+	for _, message := range []string{"one", "two", "three", "four"} {
+		testProvider.Info(context.Background(), false, message)
+	}
+	testProvider.Wait()
+
+	requests := c.received()
+	Expect(requests).To(HaveLen(3))
+	Expect(requests[0].messages()).To(Equal([]string{"one", "two", "three", "four"}))
+	Expect(requests[1].messages()).To(Equal([]string{"two"}))
+	Expect(requests[2].messages()).To(Equal([]string{"two"}))
+	Expect(testProvider.Dropped()).To(BeNumerically("==", 1))
+}
+
+func TestRetryRequests(t *testing.T) {
+	c := newCluster(t, -http.StatusServiceUnavailable)
+	setup(t, c, Config{})
+	testProvider.Info(context.Background(), false, "one")
+	testProvider.Info(context.Background(), false, "two")
+	testProvider.Wait()
+	requests := c.received()
+	Expect(requests).To(HaveLen(2))
+	Expect(requests[1].lines).To(Equal(requests[0].lines))
+	Expect(testProvider.Dropped()).To(BeZero())
+
+	// Gives up after MaxRetries, dropping whatever is left
+	c = newCluster(t, 429, 201, 429, 429)
+	setup(t, c, Config{MaxRetries: 2})
+	testProvider.Info(context.Background(), false, "one")
+	testProvider.Info(context.Background(), false, "two")
+	testProvider.Wait()
+	Expect(c.received()).To(HaveLen(3))
+	Expect(testProvider.Dropped()).To(BeNumerically("==", 1))
+
+	// Not retried, as it would fail again
+	c = newCluster(t, -http.StatusUnauthorized)
+	setup(t, c, Config{})
+	testProvider.Info(context.Background(), false, "one")
+	testProvider.Wait()
+	Expect(c.received()).To(HaveLen(1))
+	Expect(testProvider.Dropped()).To(BeNumerically("==", 1))
+}
+
+func TestBatching(t *testing.T) {
+	c := newCluster(t)
+	setup(t, c, Config{MaxBatchDocs: 2, FlushInterval: time.Hour})
+
+	for _, message := range []string{"one", "two", "three"} {
+		testProvider.Info(context.Background(), false, message)
+	}
+	Eventually(func() []bulkRequest { return c.received() }).Should(HaveLen(1))
+	Expect(c.received()[0].messages()).To(Equal([]string{"one", "two"}))
+	testProvider.Wait()
+	Expect(c.received()[1].messages()).To(Equal([]string{"three"}))
+}
+
+func TestIndexTemplate(t *testing.T) {
+	RegisterTestingT(t)
+
+	at := time.Date(2021, 3, 4, 15, 0, 0, 0, time.UTC)
+	for template, name := range map[string]string{
+		"logs-{yyyy.MM.dd}":      "logs-2021.03.04",
+		"audit-{yy_MM}-v2":       "audit-21_03-v2",
+		"{yyyy-MM-dd-HH}-hourly": "2021-03-04-15-hourly",
+		"static":                 "static",
+	} {
+		index, err := parseIndexTemplate(template)
+		Expect(err).To(BeNil())
+		Expect(index.name(at)).To(Equal(name))
+	}
+
+	for template, message := range map[string]string{
+		"logs-{yyyy.MM.dd":  `elasticsearch: unclosed { in index "logs-{yyyy.MM.dd"`,
+		"logs-{yyyy/MM}":    `elasticsearch: index "logs-{yyyy/MM}": unsupported date pattern at "/MM" (expected yyyy, yy, MM, dd or HH)`,
+		"Logs-{yyyy}":       `elasticsearch: index "Logs-{yyyy}" must be lowercase, without any of \/*?"<>| ,#:`,
+		"logs/{yyyy.MM.dd}": `elasticsearch: index "logs/{yyyy.MM.dd}" must be lowercase, without any of \/*?"<>| ,#:`,
+	} {
+		_, err := parseIndexTemplate(template)
+		Expect(err).To(MatchError(message))
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+	c := newCluster(t)
+
+	provider, err := config.Build([]byte(`
+providers:
+  - name: elasticsearch
+    options:
+      url: ` + c.URL + `
+      index: audit-{yyyy.MM}
+      headers: {Authorization: ApiKey abc}
+      level: warn
+`))
+	Expect(err).To(BeNil())
+	provider.Info(context.Background(), false, "Filtered")
+	provider.Error(context.Background(), false, "Indexed")
+	provider.Wait()
+	requests := c.received()
+	Expect(requests).To(HaveLen(1))
+	Expect(requests[0].header.Get("Authorization")).To(Equal("ApiKey abc"))
+	Expect(requests[0].messages()).To(Equal([]string{"Indexed"}))
+	Expect(requests[0].lines[0]).To(Equal(`{"index":{"_index":"audit-` + time.Now().UTC().Format("2006.01") + `"}}`))
+
+	_, err = config.Build([]byte(`{"providers": [{"name": "elasticsearch", "options": {"index": "logs-{ww}"}}]}`))
+	Expect(err).To(MatchError(`config: providers[0] (elasticsearch): elasticsearch: index "logs-{ww}": unsupported date pattern at "ww" (expected yyyy, yy, MM, dd or HH)`))
+}
@@ -0,0 +1,32 @@
+package elasticsearch
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+)
+
+func init() {
+	config.Register("elasticsearch", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := Config{
+			URL:           options.String("url", ""),
+			Index:         options.String("index", ""),
+			Username:      options.String("username", ""),
+			Password:      options.String("password", ""),
+			Headers:       options.StringMap("headers"),
+			Level:         options.String("level", "info"),
+			MaxBatchDocs:  options.Int("maxBatchDocs", 0),
+			MaxBatchBytes: options.Int("maxBatchBytes", 0),
+			FlushInterval: options.Duration("flushInterval", 0),
+			MaxRetries:    options.Int("maxRetries", 0),
+			RetryBackoff:  options.Duration("retryBackoff", 0),
+		}
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+		provider, err := LogProvider(nextProvider, providerConfig)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	})
+}
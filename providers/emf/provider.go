@@ -0,0 +1,347 @@
+/*
+This package writes metrics from Record and RecordEvent in CloudWatch's Embedded Metric Format: a
+JSON document per call, which CloudWatch Logs turns into metrics when it's printed to stdout on
+Lambda or ECS (or sent through the CloudWatch agent). Context fields listed in DimensionFields
+become dimensions, and other context fields (and any non-numeric metrics) become properties, which
+are searchable in the logs but not part of the metrics. Metrics from RecordEvent are named after
+the event, so
+
+	log.WithField("route", "/orders").RecordEvent("upload", log.Metrics{"bytes": 1024})
+
+with DimensionFields ["route"] writes
+
+	{"_aws":{"Timestamp":1577934245000,"CloudWatchMetrics":[{"Namespace":"MyService",
+	"Dimensions":[["route"]],"Metrics":[{"Name":"upload.bytes","Unit":"Bytes"}]}]},
+	"route":"/orders","upload.bytes":1024}
+
+(on one line). Units come from the Units map, or failing that from the name (see UnitFromName);
+time.Duration values are in milliseconds unless Units gives them Seconds or Microseconds. CloudWatch accepts at most 100 metrics per
+document, so calls with more are split across several.
+*/
+package emf
+
+import (
+	"github.com/myhelix/contextlogger/internal/jsonenc"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// One of CloudWatch's metric units
+type Unit string
+
+const (
+	None         Unit = "None"
+	Count        Unit = "Count"
+	Percent      Unit = "Percent"
+	Seconds      Unit = "Seconds"
+	Milliseconds Unit = "Milliseconds"
+	Microseconds Unit = "Microseconds"
+	Bytes        Unit = "Bytes"
+	Kilobytes    Unit = "Kilobytes"
+	Megabytes    Unit = "Megabytes"
+	BytesSecond  Unit = "Bytes/Second"
+	CountSecond  Unit = "Count/Second"
+)
+
+var units = []Unit{None, Count, Percent, Seconds, Milliseconds, Microseconds, Bytes, Kilobytes, Megabytes, BytesSecond, CountSecond}
+
+func ParseUnit(name string) (Unit, error) {
+	for _, unit := range units {
+		if string(unit) == name {
+			return unit, nil
+		}
+	}
+	return None, fmt.Errorf("not a valid unit: %q", name)
+}
+
+// Name suffixes for UnitFromName, checked in order
+var unitSuffixes = []struct {
+	suffix string
+	unit   Unit
+}{
+	{"ms", Milliseconds},
+	{"millis", Milliseconds},
+	{"us", Microseconds},
+	{"micros", Microseconds},
+	{"seconds", Seconds},
+	{"secs", Seconds},
+	{"kb", Kilobytes},
+	{"mb", Megabytes},
+	{"bytes", Bytes},
+	{"percent", Percent},
+	{"pct", Percent},
+	{"count", Count},
+	{"total", Count},
+}
+
+// UnitFromName infers a unit from the last word of a metric name, whether it's separated by
+// punctuation or camel case: "latencyMs", "latency_ms" and "latency.ms" are all Milliseconds,
+// "bodyBytes" is Bytes, and "retryCount" is Count. Names without a recognized suffix are None.
+func UnitFromName(name string) Unit {
+	word := name
+	if i := strings.LastIndexAny(name, "._- "); i >= 0 {
+		word = name[i+1:]
+	} else {
+		for i := len(name) - 1; i > 0; i-- {
+			if name[i] >= 'A' && name[i] <= 'Z' {
+				word = name[i:]
+				break
+			}
+		}
+	}
+	word = strings.ToLower(word)
+	for _, s := range unitSuffixes {
+		if word == s.suffix {
+			return s.unit
+		}
+	}
+	return None
+}
+
+// The most metrics CloudWatch accepts in one document
+const MaxMetrics = 100
+
+type Config struct {
+	// Defaults to os.Stdout
+	Output io.Writer
+	// The CloudWatch namespace, e.g. "MyService"; required
+	Namespace string
+	// Context fields to use as dimensions, when present; at most 30
+	DimensionFields []string
+	// Dimensions for every metric, e.g. {"Environment": "prod"}; these count towards the 30
+	Dimensions map[string]string
+	// Units by metric name (including the event name for RecordEvent), overriding UnitFromName
+	Units map[string]Unit
+}
+
+type provider struct {
+	providers.LogProvider
+	output     io.Writer
+	writeMutex sync.Mutex
+	config     Config
+	// Names of Dimensions, sorted
+	staticDimensions []string
+
+	// For tests
+	now func() time.Time
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+	if config.Namespace == "" {
+		return nil, fmt.Errorf("emf: Namespace is required")
+	}
+	if len(config.DimensionFields)+len(config.Dimensions) > 30 {
+		return nil, fmt.Errorf("emf: at most 30 dimensions are allowed, not %d", len(config.DimensionFields)+len(config.Dimensions))
+	}
+	p := &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		output:      config.Output,
+		config:      config,
+		now:         time.Now,
+	}
+	for name := range config.Dimensions {
+		p.staticDimensions = append(p.staticDimensions, name)
+	}
+	sort.Strings(p.staticDimensions)
+	return p, nil
+}
+
+type metricDefinition struct {
+	Name string `json:"Name"`
+	Unit Unit   `json:"Unit,omitempty"`
+}
+
+type metricValue struct {
+	definition metricDefinition
+	value      interface{}
+}
+
+func (p *provider) record(ctx context.Context, namePrefix string, metrics map[string]interface{}) {
+	fields := log.FieldsFromContext(ctx)
+
+	// Dimensions, in the order they're written
+	var dimensionNames []string
+	dimensionValues := make(map[string]string)
+	for _, name := range p.staticDimensions {
+		dimensionNames = append(dimensionNames, name)
+		dimensionValues[name] = p.config.Dimensions[name]
+	}
+	for _, field := range p.config.DimensionFields {
+		if val, ok := fields[field]; ok {
+			if _, duplicate := dimensionValues[field]; !duplicate {
+				dimensionNames = append(dimensionNames, field)
+			}
+			dimensionValues[field] = fmt.Sprint(val)
+		}
+	}
+
+	var values []metricValue
+	var properties []property
+	for _, key := range sortedKeys(metrics) {
+		name := namePrefix + key
+		value, unit, ok := numeric(metrics[key])
+		if !ok {
+			properties = append(properties, property{name, metrics[key]})
+			continue
+		}
+		if configured, ok := p.config.Units[name]; ok {
+			if d, isDuration := metrics[key].(time.Duration); isDuration {
+				value, unit = durationIn(d, configured)
+			} else {
+				unit = configured
+			}
+		} else if unit == "" {
+			unit = UnitFromName(key)
+		}
+		if unit == None {
+			// The default, so not worth the bytes
+			unit = ""
+		}
+		values = append(values, metricValue{metricDefinition{name, unit}, value})
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	// Context fields go before non-numeric metrics, in the order they were added
+	keys := log.OrderedKeys(ctx)
+	fieldProperties := make([]property, len(keys))
+	for i, key := range keys {
+		fieldProperties[i] = property{key, fields[key]}
+	}
+	properties = append(fieldProperties, properties...)
+
+	timestamp := p.now().UnixNano() / int64(time.Millisecond)
+	for start := 0; start < len(values); start += MaxMetrics {
+		end := start + MaxMetrics
+		if end > len(values) {
+			end = len(values)
+		}
+		p.write(p.document(timestamp, dimensionNames, dimensionValues, values[start:end], properties))
+	}
+}
+
+type property struct {
+	key   string
+	value interface{}
+}
+
+// A document's keys are dimensions, then metrics, then properties; where names clash, the first
+// wins, since CloudWatch needs the dimension and metric values
+func (p *provider) document(
+	timestamp int64,
+	dimensionNames []string,
+	dimensionValues map[string]string,
+	values []metricValue,
+	properties []property,
+) []byte {
+	definitions := make([]metricDefinition, len(values))
+	for i, v := range values {
+		definitions[i] = v.definition
+	}
+	if dimensionNames == nil {
+		dimensionNames = []string{}
+	}
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"Timestamp": timestamp,
+		"CloudWatchMetrics": []interface{}{map[string]interface{}{
+			"Namespace":  p.config.Namespace,
+			"Dimensions": [][]string{dimensionNames},
+			"Metrics":    definitions,
+		}},
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"_aws":`)
+	buf.Write(metadata)
+	written := map[string]bool{"_aws": true}
+	for _, name := range dimensionNames {
+		jsonenc.WritePair(&buf, name, dimensionValues[name])
+		written[name] = true
+	}
+	for _, v := range values {
+		if !written[v.definition.Name] {
+			jsonenc.WritePair(&buf, v.definition.Name, v.value)
+			written[v.definition.Name] = true
+		}
+	}
+	for _, property := range properties {
+		if !written[property.key] {
+			jsonenc.WritePair(&buf, property.key, property.value)
+			written[property.key] = true
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func (p *provider) write(document []byte) {
+	p.writeMutex.Lock()
+	_, err := p.output.Write(document)
+	p.writeMutex.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write metrics, %v\n", err)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// A duration in the given unit of time; other units can't describe a duration, so get milliseconds
+func durationIn(d time.Duration, unit Unit) (float64, Unit) {
+	switch unit {
+	case Seconds:
+		return d.Seconds(), Seconds
+	case Microseconds:
+		return float64(d) / float64(time.Microsecond), Microseconds
+	}
+	return float64(d) / float64(time.Millisecond), Milliseconds
+}
+
+// The numeric value of a metric, and its unit if the type implies one
+func numeric(val interface{}) (interface{}, Unit, bool) {
+	switch v := val.(type) {
+	case time.Duration:
+		return float64(v) / float64(time.Millisecond), Milliseconds, true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return v, "", true
+	case float32:
+		return v, "", !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0)
+	case float64:
+		// JSON can't represent NaN or infinity, so they're left as properties
+		return v, "", !math.IsNaN(v) && !math.IsInf(v, 0)
+	}
+	return nil, "", false
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName+".", metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
@@ -0,0 +1,201 @@
+package emf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	. "github.com/onsi/gomega"
+)
+
+var output *bytes.Buffer
+var testProvider providers.LogProvider
+
+var testTime = time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+
+func setup(t *testing.T, config Config) {
+	RegisterTestingT(t)
+
+	output = new(bytes.Buffer)
+	config.Output = output
+	if config.Namespace == "" {
+		config.Namespace = "Test"
+	}
+	p, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	p.(*provider).now = func() time.Time { return testTime }
+	testProvider = p
+}
+
+func documents() []string {
+	lines := strings.SplitAfter(output.String(), "\n")
+	Expect(lines[len(lines)-1]).To(BeEmpty())
+	return lines[:len(lines)-1]
+}
+
+func TestDocument(t *testing.T) {
+	setup(t, Config{
+		Namespace:       "MyService",
+		DimensionFields: []string{"route", "region"},
+		Dimensions:      map[string]string{"Environment": "prod"},
+		Units:           map[string]Unit{"upload.items": Count},
+	})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"route": "/orders"})
+	ctx = log.ContextWithFields(ctx, log.Fields{"requestId": "abc"})
+	ctx = log.ContextWithFields(ctx, log.Fields{"attempt": 2})
+	testProvider.RecordEvent(ctx, "upload", log.Metrics{
+		"bytes":   1024,
+		"elapsed": 1500 * time.Microsecond,
+		"items":   3,
+		"ratio":   0.5,
+		"status":  "ok",
+	})
+	testProvider.Record(ctx, log.Metrics{"status": "only properties"})
+
+	Expect(documents()).To(Equal([]string{`{"_aws":{"CloudWatchMetrics":[{"Dimensions":[["Environment","route"]],` +
+		`"Metrics":[{"Name":"upload.bytes","Unit":"Bytes"},{"Name":"upload.elapsed","Unit":"Milliseconds"},` +
+		`{"Name":"upload.items","Unit":"Count"},{"Name":"upload.ratio"}],"Namespace":"MyService"}],"Timestamp":1577934245006},` +
+		`"Environment":"prod","route":"/orders","upload.bytes":1024,"upload.elapsed":1.5,"upload.items":3,"upload.ratio":0.5,` +
+		`"requestId":"abc","attempt":2,"upload.status":"ok"}` + "\n",
+	}))
+}
+
+func TestSplitting(t *testing.T) {
+	setup(t, Config{})
+
+	metrics := make(log.Metrics)
+	for i := 0; i < 250; i++ {
+		metrics[fmt.Sprintf("m%03d", i)] = i
+	}
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"requestId": "abc"})
+	testProvider.Record(ctx, metrics)
+
+	docs := documents()
+	Expect(docs).To(HaveLen(3))
+	var counts []int
+	for _, doc := range docs {
+		var decoded struct {
+			AWS struct {
+				CloudWatchMetrics []struct {
+					Metrics []struct{ Name string }
+				}
+			} `json:"_aws"`
+			RequestID string `json:"requestId"`
+		}
+		Expect(json.Unmarshal([]byte(doc), &decoded)).To(Succeed())
+		Expect(decoded.RequestID).To(Equal("abc"))
+		definitions := decoded.AWS.CloudWatchMetrics[0].Metrics
+		counts = append(counts, len(definitions))
+		Expect(doc).To(ContainSubstring(fmt.Sprintf(`"%s":%s`, definitions[0].Name, strings.TrimLeft(definitions[0].Name[1:], "0"))))
+	}
+	Expect(counts).To(Equal([]int{100, 100, 50}))
+	Expect(docs[2]).To(ContainSubstring(`"m249":249`))
+	Expect(docs[2]).NotTo(ContainSubstring(`"m199"`))
+}
+
+func TestValues(t *testing.T) {
+	setup(t, Config{DimensionFields: []string{"user"}})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"user": 42, "count": "field"})
+	testProvider.Record(ctx, log.Metrics{"count": 1, "bad": fmt.Errorf("not a number"), "huge": math.Inf(1)})
+
+	var decoded map[string]interface{}
+	docs := documents()
+	Expect(docs).To(HaveLen(1))
+	Expect(json.Unmarshal([]byte(docs[0]), &decoded)).To(Succeed())
+	// Dimension values are strings, metrics win over fields of the same name, and values that
+	// aren't numbers become properties
+	Expect(decoded["user"]).To(Equal("42"))
+	Expect(decoded["count"]).To(Equal(float64(1)))
+	Expect(decoded["bad"]).To(Equal("not a number"))
+	Expect(decoded["huge"]).To(Equal("+Inf"))
+
+	// Nothing to write without metrics
+	output.Reset()
+	testProvider.Record(ctx, log.Metrics{})
+	Expect(output.String()).To(BeEmpty())
+}
+
+func TestDurationUnits(t *testing.T) {
+	setup(t, Config{Units: map[string]Unit{"wait": Seconds, "rtt": Microseconds, "delay": Count}})
+
+	testProvider.Record(context.Background(), log.Metrics{
+		"wait":  1500 * time.Millisecond,
+		"rtt":   2 * time.Millisecond,
+		"delay": 3 * time.Millisecond,
+		"other": 4 * time.Millisecond,
+	})
+
+	docs := documents()
+	Expect(docs).To(HaveLen(1))
+	Expect(docs[0]).To(ContainSubstring(`{"Name":"delay","Unit":"Milliseconds"}`))
+	Expect(docs[0]).To(ContainSubstring(`{"Name":"other","Unit":"Milliseconds"}`))
+	Expect(docs[0]).To(ContainSubstring(`{"Name":"rtt","Unit":"Microseconds"}`))
+	Expect(docs[0]).To(ContainSubstring(`{"Name":"wait","Unit":"Seconds"}`))
+	Expect(docs[0]).To(ContainSubstring(`"delay":3,"other":4,"rtt":2000,"wait":1.5`))
+}
+
+func TestUnitFromName(t *testing.T) {
+	RegisterTestingT(t)
+
+	for name, unit := range map[string]Unit{
+		"latencyMs":       Milliseconds,
+		"latency_ms":      Milliseconds,
+		"db.query.micros": Microseconds,
+		"bodyBytes":       Bytes,
+		"bytes":           Bytes,
+		"retryCount":      Count,
+		"cpu-pct":         Percent,
+		"uptimeSeconds":   Seconds,
+		"items":           None,
+		"status":          None,
+		"bonus":           None,
+	} {
+		Expect(UnitFromName(name)).To(Equal(unit), name)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := LogProvider(nil, Config{})
+	Expect(err).To(MatchError("emf: Namespace is required"))
+	fields := make([]string, 31)
+	_, err = LogProvider(nil, Config{Namespace: "Test", DimensionFields: fields})
+	Expect(err).To(MatchError("emf: at most 30 dimensions are allowed, not 31"))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+
+	path := filepath.Join(t.TempDir(), "emf.log")
+	provider, err := config.Build([]byte(`
+providers:
+  - name: emf
+    options:
+      output: file://` + path + `
+      namespace: Configured
+      dimensionFields: [route]
+      units: {size: Kilobytes}
+`))
+	Expect(err).To(BeNil())
+	provider.Record(log.ContextWithFields(context.Background(), log.Fields{"route": "/"}), log.Metrics{"size": 2})
+	provider.Wait()
+	written, err := ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	Expect(string(written)).To(ContainSubstring(`"Dimensions":[["route"]],"Metrics":[{"Name":"size","Unit":"Kilobytes"}],"Namespace":"Configured"`))
+
+	_, err = config.Build([]byte(`{"providers": [{"name": "emf", "options": {"namespace": "x", "units": {"size": "Furlongs"}}}]}`))
+	Expect(err).To(MatchError(`config: providers[0] (emf): option "units": "size": not a valid unit: "Furlongs"`))
+}
@@ -0,0 +1,33 @@
+package emf
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"os"
+)
+
+func init() {
+	config.Register("emf", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := Config{
+			Output:          options.Writer("output", os.Stdout),
+			Namespace:       options.RequiredString("namespace"),
+			DimensionFields: options.Strings("dimensionFields", nil),
+			Dimensions:      options.StringMap("dimensions"),
+		}
+		if units := options.StringMap("units"); len(units) > 0 {
+			providerConfig.Units = make(map[string]Unit)
+			for name, unitName := range units {
+				unit, err := ParseUnit(unitName)
+				if err != nil {
+					options.Errorf("option \"units\": %q: %v", name, err)
+				}
+				providerConfig.Units[name] = unit
+			}
+		}
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+		return LogProvider(nextProvider, providerConfig)
+	})
+}
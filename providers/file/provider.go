@@ -0,0 +1,35 @@
+/*
+This package provides a rotating file Writer, for use as the output of another provider (e.g.
+logrus.Config.Output), and a pass-through LogProvider whose Wait flushes that file to disk:
+
+	writer, err := file.NewWriter(file.Config{Path: "/var/log/service.log", MaxSize: 100 << 20, Compress: true, MaxFiles: 10})
+	provider, err := logrus.LogProvider(file.LogProvider(nil, writer), logrus.Config{Output: writer, Level: "info"})
+*/
+package file
+
+import (
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"fmt"
+	"os"
+)
+
+type provider struct {
+	providers.LogProvider
+	writer *Writer
+}
+
+func LogProvider(nextProvider providers.LogProvider, writer *Writer) providers.LogProvider {
+	return provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		writer:      writer,
+	}
+}
+
+func (p provider) Wait() {
+	p.LogProvider.Wait()
+	if err := p.writer.Sync(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to sync log file %s, %v\n", p.writer.config.Path, err)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestReopenOnSIGHUP(t *testing.T) {
+	writer := setup(t, Config{ReopenOnSIGHUP: true})
+
+	write(writer, "before\n")
+	Expect(os.Rename(writer.config.Path, writer.config.Path+".1")).To(BeNil())
+	Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(BeNil())
+	Eventually(func() bool { return exists(writer.config.Path) }, time.Second).Should(BeTrue())
+	write(writer, "after\n")
+	Expect(writer.Sync()).To(BeNil())
+
+	Expect(readFile(writer.config.Path + ".1")).To(Equal("before\n"))
+	Expect(readFile(writer.config.Path)).To(Equal("after\n"))
+}
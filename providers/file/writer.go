@@ -0,0 +1,361 @@
+package file
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type Config struct {
+	Path string
+	// Permissions for newly created files; defaults to 0644
+	Mode os.FileMode
+	// Rotate before a write would take the file over this many bytes; 0 for no limit
+	MaxSize int64
+	// Rotate at multiples of this interval (e.g. 24h for daily, at midnight UTC); 0 for never
+	RotateEvery time.Duration
+	// Gzip rotated files (in the background)
+	Compress bool
+	// Delete the oldest rotated files beyond this many; 0 keeps them all
+	MaxFiles int
+	// Delete rotated files older than this; 0 keeps them all
+	MaxAge time.Duration
+	// Reopen the file on SIGHUP, so external tools like logrotate can move it out of the way
+	ReopenOnSIGHUP bool
+}
+
+// Suffix added to rotated files, before any .gz
+const timestampLayout = "20060102T150405.000"
+
+/*
+Writer is an io.Writer which appends to a file, rotating it by size and/or time. Rotated files are
+renamed to Path plus a timestamp suffix (e.g. service.log.20190401T000000.000), optionally gzipped,
+and pruned by count and/or age.
+*/
+type Writer struct {
+	config Config
+
+	mutex sync.Mutex
+	// Nil if reopening failed, in which case the next write tries again
+	file         *os.File
+	closed       bool
+	size         int64
+	nextRotation time.Time
+
+	// Background compression and pruning jobs running, guarded by mutex; idle is signalled (with
+	// mutex) when one finishes
+	background int
+	idle       *sync.Cond
+	signals    chan os.Signal
+
+	// For tests
+	now func() time.Time
+}
+
+func NewWriter(config Config) (*Writer, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("file: no path configured")
+	}
+	if config.Mode == 0 {
+		config.Mode = 0644
+	}
+	w := &Writer{config: config, now: time.Now}
+	w.idle = sync.NewCond(&w.mutex)
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	if config.ReopenOnSIGHUP {
+		w.signals = make(chan os.Signal, 1)
+		signal.Notify(w.signals, syscall.SIGHUP)
+		go w.handleSignals(w.signals)
+	}
+	return w, nil
+}
+
+// Must be called with mutex held
+func (w *Writer) open() error {
+	if dir := filepath.Dir(w.config.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(w.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, w.config.Mode)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	if w.config.RotateEvery > 0 {
+		w.nextRotation = w.now().Truncate(w.config.RotateEvery).Add(w.config.RotateEvery)
+	}
+	return nil
+}
+
+func (w *Writer) handleSignals(signals chan os.Signal) {
+	for range signals {
+		if err := w.Reopen(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to reopen log file %s, %v\n", w.config.Path, err)
+		}
+	}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err := w.ready(); err != nil {
+		return 0, err
+	}
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Must be called with mutex held
+func (w *Writer) ready() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.file == nil {
+		return w.open()
+	}
+	return nil
+}
+
+func (w *Writer) shouldRotate(writeSize int64) bool {
+	if w.config.MaxSize > 0 && w.size > 0 && w.size+writeSize > w.config.MaxSize {
+		return true
+	}
+	return w.config.RotateEvery > 0 && !w.now().Before(w.nextRotation)
+}
+
+// Rotate the file now, regardless of size or time
+func (w *Writer) Rotate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.ready(); err != nil {
+		return err
+	}
+	return w.rotate()
+}
+
+// Must be called with mutex held. If the file can't be moved aside, writing carries on in it.
+func (w *Writer) rotate() error {
+	closeErr := w.file.Close()
+	w.file = nil
+	if closeErr != nil {
+		// Writing to it again would fail too, so start afresh
+		w.open()
+		return closeErr
+	}
+
+	rotated := w.config.Path + "." + w.now().UTC().Format(timestampLayout)
+	// Don't clobber a file rotated in the same millisecond
+	for i := 1; exists(rotated) || exists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s.%s.%d", w.config.Path, w.now().UTC().Format(timestampLayout), i)
+	}
+	if err := os.Rename(w.config.Path, rotated); err != nil {
+		w.open()
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.background++
+	go func() {
+		defer w.backgroundDone()
+		if w.config.Compress {
+			if err := compress(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to compress log file %s, %v\n", rotated, err)
+			}
+		}
+		w.prune()
+	}()
+	return nil
+}
+
+func (w *Writer) backgroundDone() {
+	w.mutex.Lock()
+	w.background--
+	w.idle.Broadcast()
+	w.mutex.Unlock()
+}
+
+// Must be called with mutex held, which is released while waiting, so writes carry on meanwhile
+func (w *Writer) waitBackground() {
+	for w.background > 0 {
+		w.idle.Wait()
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func compress(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	// Write to a temporary name, so a partly compressed file is never mistaken for a whole one
+	out, err := os.OpenFile(path+".gz.tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(path + ".gz.tmp")
+		}
+	}()
+
+	gz := gzip.NewWriter(out)
+	gz.Name = filepath.Base(path)
+	gz.ModTime = info.ModTime()
+	if _, err = io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(path+".gz.tmp", path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+type rotatedFile struct {
+	path    string
+	rotated time.Time
+}
+
+// Rotated files, newest first
+func (w *Writer) rotatedFiles() ([]rotatedFile, error) {
+	matches, err := filepath.Glob(w.config.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	prefix := w.config.Path + "."
+	var files []rotatedFile
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, prefix)
+		if strings.HasSuffix(suffix, ".tmp") {
+			continue
+		}
+		if len(suffix) < len(timestampLayout) {
+			continue
+		}
+		rotated, err := time.Parse(timestampLayout, suffix[:len(timestampLayout)])
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedFile{match, rotated})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].rotated.Equal(files[j].rotated) {
+			return files[i].path > files[j].path
+		}
+		return files[i].rotated.After(files[j].rotated)
+	})
+	return files, nil
+}
+
+func (w *Writer) prune() {
+	if w.config.MaxFiles <= 0 && w.config.MaxAge <= 0 {
+		return
+	}
+	files, err := w.rotatedFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list rotated log files for %s, %v\n", w.config.Path, err)
+		return
+	}
+	cutoff := w.now().Add(-w.config.MaxAge)
+	for i, file := range files {
+		if (w.config.MaxFiles > 0 && i >= w.config.MaxFiles) ||
+			(w.config.MaxAge > 0 && file.rotated.Before(cutoff)) {
+			if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Failed to remove rotated log file %s, %v\n", file.path, err)
+			}
+		}
+	}
+}
+
+// Reopen the file at Path, e.g. after something else has moved it away
+func (w *Writer) Reopen() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.file != nil {
+		err := w.file.Close()
+		w.file = nil
+		if err != nil {
+			w.open()
+			return err
+		}
+	}
+	return w.open()
+}
+
+// Sync flushes the current file to disk, after waiting for any background compression (during
+// which writes aren't held up)
+func (w *Writer) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.waitBackground()
+	if err := w.ready(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+func (w *Writer) Close() error {
+	if w.signals != nil {
+		signal.Stop(w.signals)
+		close(w.signals)
+		w.signals = nil
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.waitBackground()
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,311 @@
+package file
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/providers/logrus"
+	. "github.com/onsi/gomega"
+)
+
+// Fake clock; guarded because background pruning reads it too
+var clock time.Time
+var clockMutex sync.Mutex
+
+func now() time.Time {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	return clock
+}
+
+func advance(d time.Duration) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	clock = clock.Add(d)
+}
+
+func setup(t *testing.T, config Config) *Writer {
+	RegisterTestingT(t)
+
+	clock = time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)
+	config.Path = filepath.Join(t.TempDir(), "logs", "test.log")
+	writer, err := NewWriter(config)
+	Expect(err).To(BeNil())
+	writer.now = now
+	// Pick up the fake clock for time based rotation
+	Expect(writer.Reopen()).To(BeNil())
+	t.Cleanup(func() { writer.Close() })
+	return writer
+}
+
+func write(writer *Writer, lines ...string) {
+	for _, line := range lines {
+		n, err := writer.Write([]byte(line))
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(len(line)))
+	}
+}
+
+func readFile(path string) string {
+	data, err := ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	return string(data)
+}
+
+func readGzip(path string) string {
+	f, err := os.Open(path)
+	Expect(err).To(BeNil())
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	Expect(err).To(BeNil())
+	data, err := ioutil.ReadAll(gz)
+	Expect(err).To(BeNil())
+	return string(data)
+}
+
+// Names of rotated files, oldest first
+func rotated(writer *Writer) []string {
+	matches, err := filepath.Glob(writer.config.Path + ".*")
+	Expect(err).To(BeNil())
+	var names []string
+	for _, match := range matches {
+		names = append(names, filepath.Base(match))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRotateBySize(t *testing.T) {
+	writer := setup(t, Config{MaxSize: 10})
+
+	write(writer, "1234\n", "6789\n")
+	Expect(rotated(writer)).To(BeEmpty())
+	advance(time.Second)
+	write(writer, "abc\n")
+	advance(time.Second)
+	// Bigger than MaxSize on its own, but written to the fresh file rather than rotating again
+	write(writer, "this line is too long\n")
+	Expect(writer.Sync()).To(BeNil())
+
+	Expect(rotated(writer)).To(Equal([]string{"test.log.20190401T120001.000", "test.log.20190401T120002.000"}))
+	dir := filepath.Dir(writer.config.Path)
+	Expect(readFile(filepath.Join(dir, "test.log.20190401T120001.000"))).To(Equal("1234\n6789\n"))
+	Expect(readFile(filepath.Join(dir, "test.log.20190401T120002.000"))).To(Equal("abc\n"))
+	Expect(readFile(writer.config.Path)).To(Equal("this line is too long\n"))
+}
+
+func TestRotateByTime(t *testing.T) {
+	writer := setup(t, Config{RotateEvery: time.Hour})
+
+	write(writer, "noon\n")
+	advance(59 * time.Minute)
+	write(writer, "still noon\n")
+	Expect(rotated(writer)).To(BeEmpty())
+	advance(time.Minute)
+	write(writer, "one o'clock\n")
+	advance(30 * time.Minute)
+	write(writer, "half past\n")
+	Expect(writer.Sync()).To(BeNil())
+
+	Expect(rotated(writer)).To(Equal([]string{"test.log.20190401T130000.000"}))
+	Expect(readFile(writer.config.Path + ".20190401T130000.000")).To(Equal("noon\nstill noon\n"))
+	Expect(readFile(writer.config.Path)).To(Equal("one o'clock\nhalf past\n"))
+}
+
+func TestSameTimestamp(t *testing.T) {
+	writer := setup(t, Config{})
+
+	write(writer, "one\n")
+	Expect(writer.Rotate()).To(BeNil())
+	write(writer, "two\n")
+	Expect(writer.Rotate()).To(BeNil())
+	Expect(writer.Sync()).To(BeNil())
+
+	Expect(rotated(writer)).To(Equal([]string{"test.log.20190401T120000.000", "test.log.20190401T120000.000.1"}))
+	Expect(readFile(writer.config.Path + ".20190401T120000.000.1")).To(Equal("two\n"))
+}
+
+func TestCompress(t *testing.T) {
+	writer := setup(t, Config{MaxSize: 6, Compress: true})
+
+	write(writer, "first\n")
+	advance(time.Second)
+	write(writer, "second\n")
+	Expect(writer.Sync()).To(BeNil())
+
+	Expect(rotated(writer)).To(Equal([]string{"test.log.20190401T120001.000.gz"}))
+	Expect(readGzip(writer.config.Path + ".20190401T120001.000.gz")).To(Equal("first\n"))
+	Expect(readFile(writer.config.Path)).To(Equal("second\n"))
+}
+
+func TestMaxFiles(t *testing.T) {
+	writer := setup(t, Config{Compress: true, MaxFiles: 2})
+
+	for i := 0; i < 4; i++ {
+		write(writer, "line\n")
+		advance(time.Minute)
+		Expect(writer.Rotate()).To(BeNil())
+		Expect(writer.Sync()).To(BeNil())
+	}
+	Expect(rotated(writer)).To(Equal([]string{"test.log.20190401T120300.000.gz", "test.log.20190401T120400.000.gz"}))
+}
+
+func TestMaxAge(t *testing.T) {
+	writer := setup(t, Config{MaxAge: 48 * time.Hour})
+
+	// Not ours, so left alone
+	Expect(ioutil.WriteFile(writer.config.Path+".bak", nil, 0644)).To(BeNil())
+	for i := 0; i < 4; i++ {
+		write(writer, "line\n")
+		Expect(writer.Rotate()).To(BeNil())
+		Expect(writer.Sync()).To(BeNil())
+		advance(24 * time.Hour)
+	}
+	Expect(rotated(writer)).To(Equal([]string{
+		"test.log.20190402T120000.000",
+		"test.log.20190403T120000.000",
+		"test.log.20190404T120000.000",
+		"test.log.bak",
+	}))
+
+	// Pruned on the next rotation, not just by the clock passing
+	advance(24 * time.Hour)
+	Expect(writer.Rotate()).To(BeNil())
+	Expect(writer.Sync()).To(BeNil())
+	Expect(rotated(writer)).To(Equal([]string{
+		"test.log.20190404T120000.000",
+		"test.log.20190406T120000.000",
+		"test.log.bak",
+	}))
+}
+
+func TestReopen(t *testing.T) {
+	writer := setup(t, Config{})
+
+	write(writer, "before\n")
+	Expect(os.Rename(writer.config.Path, writer.config.Path+".1")).To(BeNil())
+	write(writer, "moved\n")
+	Expect(writer.Reopen()).To(BeNil())
+	write(writer, "after\n")
+	Expect(writer.Sync()).To(BeNil())
+
+	Expect(readFile(writer.config.Path + ".1")).To(Equal("before\nmoved\n"))
+	Expect(readFile(writer.config.Path)).To(Equal("after\n"))
+}
+
+func TestRotateFails(t *testing.T) {
+	writer := setup(t, Config{})
+
+	write(writer, "before\n")
+	// So there's nothing to rename
+	Expect(os.Remove(writer.config.Path)).To(BeNil())
+	Expect(writer.Rotate()).NotTo(BeNil())
+	// Still writing, to a new file at Path
+	write(writer, "after\n")
+	Expect(writer.Sync()).To(BeNil())
+
+	Expect(rotated(writer)).To(BeEmpty())
+	Expect(readFile(writer.config.Path)).To(Equal("after\n"))
+}
+
+func TestSyncWhileRotating(t *testing.T) {
+	writer := setup(t, Config{MaxSize: 10, Compress: true, MaxFiles: 3})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				write(writer, "12345678\n")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				Expect(writer.Sync()).To(BeNil())
+			}
+		}()
+	}
+	wg.Wait()
+	Expect(writer.Sync()).To(BeNil())
+	Expect(len(rotated(writer))).To(BeNumerically("<=", 3))
+}
+
+func TestWriteWhileSyncWaits(t *testing.T) {
+	writer := setup(t, Config{})
+
+	// As if a rotated file were being compressed
+	writer.mutex.Lock()
+	writer.background++
+	writer.mutex.Unlock()
+
+	synced := make(chan error, 1)
+	go func() { synced <- writer.Sync() }()
+	Consistently(synced, 50*time.Millisecond).ShouldNot(Receive())
+	written := make(chan struct{})
+	go func() {
+		write(writer, "1234\n")
+		close(written)
+	}()
+	Eventually(written).Should(BeClosed())
+
+	writer.backgroundDone()
+	Eventually(synced).Should(Receive(BeNil()))
+	Expect(readFile(writer.config.Path)).To(Equal("1234\n"))
+}
+
+func TestExistingFile(t *testing.T) {
+	RegisterTestingT(t)
+
+	path := filepath.Join(t.TempDir(), "test.log")
+	Expect(ioutil.WriteFile(path, []byte("1234\n"), 0644)).To(BeNil())
+	writer, err := NewWriter(Config{Path: path, MaxSize: 10})
+	Expect(err).To(BeNil())
+	defer writer.Close()
+
+	// Appended, and counted towards MaxSize
+	write(writer, "6789\n")
+	write(writer, "abc\n")
+	Expect(writer.Sync()).To(BeNil())
+	Expect(readFile(path)).To(Equal("abc\n"))
+	Expect(rotated(writer)).To(HaveLen(1))
+
+	_, err = NewWriter(Config{})
+	Expect(err).To(MatchError("file: no path configured"))
+}
+
+func TestClosed(t *testing.T) {
+	writer := setup(t, Config{})
+
+	Expect(writer.Close()).To(BeNil())
+	_, err := writer.Write([]byte("late\n"))
+	Expect(err).To(Equal(os.ErrClosed))
+	Expect(writer.Sync()).To(Equal(os.ErrClosed))
+	Expect(writer.Close()).To(Equal(os.ErrClosed))
+}
+
+func TestLogProvider(t *testing.T) {
+	writer := setup(t, Config{MaxSize: 200, Compress: true})
+
+	provider, err := logrus.LogProvider(LogProvider(nil, writer), logrus.Config{Output: writer, Level: "info", Formatter: logrus.RecommendedFormatter})
+	Expect(err).To(BeNil())
+	for i := 0; i < 5; i++ {
+		advance(time.Second)
+		provider.Info(context.Background(), false, "Hello from the log file")
+	}
+	provider.Wait()
+
+	Expect(rotated(writer)).ToNot(BeEmpty())
+	for _, name := range rotated(writer) {
+		Expect(readGzip(filepath.Join(filepath.Dir(writer.config.Path), name))).To(ContainSubstring("Hello from the log file"))
+	}
+	Expect(readFile(writer.config.Path)).To(ContainSubstring("Hello from the log file"))
+}
@@ -0,0 +1,286 @@
+/*
+This package writes JSON log lines in the structured format Google Cloud Logging reads from stdout
+on GKE, Cloud Run and App Engine, so that these keys get their special meaning:
+
+	severity                                 the level, as a Cloud Logging severity
+	message                                  the log message
+	time                                     when it was logged
+	logging.googleapis.com/sourceLocation    from the reportedAt field (see the reported_at provider)
+	logging.googleapis.com/trace, spanId     from log.ContextWithSpanContext
+	httpRequest                              from the request attached with log.ContextWithRequest
+
+Other context fields are written as they are, or under "fields." if their key is one of these.
+{Error,Warn,Info,Debug}Report calls are marked as ReportedErrorEvents, with a stack trace (merry's,
+if the error has one), so that Error Reporting picks them up. Record and RecordEvent are written
+as "Reporting metrics" lines, with the metrics as fields.
+*/
+package gcp
+
+import (
+	"github.com/myhelix/contextlogger/internal/jsonenc"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Defaults to os.Stdout
+	Output io.Writer
+	Level  string
+	// Needed for trace IDs to link to Cloud Trace, which expects projects/PROJECT_ID/traces/TRACE_ID
+	ProjectID string
+	// Reported to Error Reporting as the serviceContext; the service defaults to the program name
+	ServiceName    string
+	ServiceVersion string
+}
+
+const reportedErrorEvent = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// Cloud Logging's names for our levels
+var severities = map[providers.LogLevel]string{
+	providers.Debug: "DEBUG",
+	providers.Info:  "INFO",
+	providers.Warn:  "WARNING",
+	providers.Error: "ERROR",
+}
+
+// Keys with special meaning, which context fields can't use
+var reserved = map[string]bool{
+	"severity":                              true,
+	"message":                               true,
+	"time":                                  true,
+	"httpRequest":                           true,
+	"stack_trace":                           true,
+	"serviceContext":                        true,
+	"@type":                                 true,
+	"logging.googleapis.com/sourceLocation": true,
+	"logging.googleapis.com/trace":          true,
+	"logging.googleapis.com/spanId":         true,
+	"logging.googleapis.com/trace_sampled":  true,
+}
+
+const (
+	reportedAtField = "reportedAt"
+	stackTraceField = "~stackTrace"
+)
+
+type provider struct {
+	providers.LogProvider
+	output         io.Writer
+	writeMutex     sync.Mutex
+	level          providers.LogLevel
+	projectID      string
+	serviceContext []byte
+
+	// For tests
+	now   func() time.Time
+	stack func() []byte
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	level, err := providers.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = filepath.Base(os.Args[0])
+	}
+	serviceContext := map[string]string{"service": config.ServiceName}
+	if config.ServiceVersion != "" {
+		serviceContext["version"] = config.ServiceVersion
+	}
+	encoded, _ := json.Marshal(serviceContext)
+	return &provider{
+		LogProvider:    chaining.LogProvider(nextProvider),
+		output:         config.Output,
+		level:          level,
+		projectID:      config.ProjectID,
+		serviceContext: encoded,
+		now:            time.Now,
+		stack:          debug.Stack,
+	}, nil
+}
+
+func (p *provider) format(ctx context.Context, level providers.LogLevel, report bool, message string, metrics map[string]interface{}) []byte {
+	fields := log.FieldsFromContext(ctx)
+	var buf bytes.Buffer
+	buf.WriteString(`{"severity":"` + severities[level] + `","message":`)
+	jsonenc.WriteValue(&buf, message)
+	if report {
+		// Error Reporting wants the stack trace in the message, or in stack_trace
+		buf.WriteString(`,"@type":"` + reportedErrorEvent + `","serviceContext":`)
+		buf.Write(p.serviceContext)
+		jsonenc.WritePair(&buf, "stack_trace", message+"\n\n"+p.stackTrace(fields))
+	}
+	jsonenc.WritePair(&buf, "time", p.now().UTC().Format(time.RFC3339Nano))
+
+	if reportedAt, ok := fields[reportedAtField].(string); ok {
+		if location := sourceLocation(reportedAt); location != nil {
+			jsonenc.WritePair(&buf, "logging.googleapis.com/sourceLocation", location)
+		}
+	}
+	if span, ok := log.SpanContextFromContext(ctx); ok {
+		trace := span.TraceIDString()
+		if p.projectID != "" {
+			trace = "projects/" + p.projectID + "/traces/" + trace
+		}
+		jsonenc.WritePair(&buf, "logging.googleapis.com/trace", trace)
+		jsonenc.WritePair(&buf, "logging.googleapis.com/spanId", span.SpanIDString())
+		jsonenc.WritePair(&buf, "logging.googleapis.com/trace_sampled", span.TraceFlags&1 == 1)
+	}
+	if req := log.RequestFromContext(ctx); req != nil {
+		jsonenc.WritePair(&buf, "httpRequest", httpRequest(req))
+	}
+
+	for _, key := range log.OrderedKeys(ctx) {
+		if key == reportedAtField || (report && key == stackTraceField) {
+			continue
+		}
+		if _, isMetric := metrics[key]; !isMetric {
+			writeField(&buf, key, fields[key])
+		}
+	}
+	metricKeys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		metricKeys = append(metricKeys, key)
+	}
+	sort.Strings(metricKeys)
+	for _, key := range metricKeys {
+		writeField(&buf, key, metrics[key])
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// A stack trace in the format Error Reporting parses for Go, which is the same as a panic's
+func (p *provider) stackTrace(fields map[string]interface{}) string {
+	if trace, ok := fields[stackTraceField].(string); ok && trace != "" {
+		// From merry, which has the frames but not the goroutine header
+		return "goroutine 1 [running]:\n" + trace
+	}
+	return string(p.stack())
+}
+
+// Parse file:line, as written by the reported_at provider
+func sourceLocation(reportedAt string) map[string]string {
+	i := strings.LastIndexByte(reportedAt, ':')
+	if i <= 0 {
+		return nil
+	}
+	// The line is a string, as it's an int64 in the LogEntrySourceLocation proto
+	return map[string]string{"file": reportedAt[:i], "line": reportedAt[i+1:]}
+}
+
+// The fields of Cloud Logging's HttpRequest that we can know before the response
+func httpRequest(req *http.Request) map[string]string {
+	url := *req.URL
+	if url.Host == "" {
+		// A server request, whose URL only has the path and query
+		url.Host = req.Host
+		url.Scheme = "http"
+		if req.TLS != nil {
+			url.Scheme = "https"
+		}
+	}
+	fields := map[string]string{
+		"requestMethod": req.Method,
+		"requestUrl":    url.String(),
+		"protocol":      req.Proto,
+	}
+	if userAgent := req.UserAgent(); userAgent != "" {
+		fields["userAgent"] = userAgent
+	}
+	if referer := req.Referer(); referer != "" {
+		fields["referer"] = referer
+	}
+	if req.RemoteAddr != "" {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		fields["remoteIp"] = host
+	}
+	return fields
+}
+
+func writeField(buf *bytes.Buffer, key string, val interface{}) {
+	if reserved[key] {
+		// Same as logrus, so they aren't lost
+		key = "fields." + key
+	}
+	jsonenc.WritePair(buf, key, val)
+}
+
+func (p *provider) write(line []byte) {
+	p.writeMutex.Lock()
+	_, err := p.output.Write(line)
+	p.writeMutex.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write to log, %v\n", err)
+	}
+}
+
+func (p *provider) log(ctx context.Context, level providers.LogLevel, report bool, args []interface{}) {
+	if level > p.level {
+		return
+	}
+	p.write(p.format(ctx, level, report, fmt.Sprint(args...), nil))
+}
+
+func (p *provider) record(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	if providers.Info > p.level {
+		return
+	}
+	if eventName != "" {
+		ctx = log.ContextWithFields(ctx, log.Fields{"eventName": eventName})
+	}
+	p.write(p.format(ctx, providers.Info, false, "Reporting metrics", metrics))
+}
+
+func (p *provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Error, report, args)
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Warn, report, args)
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Info, report, args)
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Debug, report, args)
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName, metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
@@ -0,0 +1,152 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	. "github.com/onsi/gomega"
+)
+
+var output *bytes.Buffer
+var testProvider providers.LogProvider
+
+var testTime = time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+func setup(t *testing.T, config Config) {
+	RegisterTestingT(t)
+
+	output = new(bytes.Buffer)
+	config.Output = output
+	if config.Level == "" {
+		config.Level = "debug"
+	}
+	p, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	p.(*provider).now = func() time.Time { return testTime }
+	p.(*provider).stack = func() []byte { return []byte("goroutine 7 [running]:\nmain.main()\n\t/app/main.go:10 +0x1d\n") }
+	testProvider = p
+}
+
+func lines() []string {
+	lines := strings.SplitAfter(output.String(), "\n")
+	Expect(lines[len(lines)-1]).To(BeEmpty())
+	return lines[:len(lines)-1]
+}
+
+func TestLines(t *testing.T) {
+	setup(t, Config{Level: "info"})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"requestId": "abc"})
+	ctx = log.ContextWithFields(ctx, log.Fields{"severity": "ignored", "attempt": 2})
+	testProvider.Warn(ctx, false, "Disk ", errors.New("full"))
+	testProvider.Debug(ctx, false, "Filtered")
+	testProvider.RecordEvent(ctx, "upload", log.Metrics{"bytes": 1024, "attempt": 3})
+
+	Expect(lines()).To(Equal([]string{
+		`{"severity":"WARNING","message":"Disk full","time":"2020-01-02T03:04:05.000000006Z","requestId":"abc","attempt":2,"fields.severity":"ignored"}` + "\n",
+		`{"severity":"INFO","message":"Reporting metrics","time":"2020-01-02T03:04:05.000000006Z","requestId":"abc","fields.severity":"ignored","eventName":"upload","attempt":3,"bytes":1024}` + "\n",
+	}))
+}
+
+func TestSpecialKeys(t *testing.T) {
+	setup(t, Config{ProjectID: "my-project"})
+
+	req := httptest.NewRequest("POST", "/orders?page=2", nil)
+	req.Header.Set("User-Agent", "curl/7.64.1")
+	req.Header.Set("Referer", "https://example.com/")
+	ctx := log.ContextWithRequest(context.Background(), req)
+	ctx = log.ContextWithSpanContext(ctx, log.SpanContext{
+		TraceID:    [16]byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: 1,
+	})
+	ctx = log.ContextWithFields(ctx, log.Fields{"reportedAt": "/app/orders.go:42"})
+	testProvider.Info(ctx, false, "Handled")
+
+	var decoded map[string]interface{}
+	Expect(json.Unmarshal(output.Bytes(), &decoded)).To(Succeed())
+	Expect(decoded).To(Equal(map[string]interface{}{
+		"severity":                              "INFO",
+		"message":                               "Handled",
+		"time":                                  "2020-01-02T03:04:05.000000006Z",
+		"logging.googleapis.com/sourceLocation": map[string]interface{}{"file": "/app/orders.go", "line": "42"},
+		"logging.googleapis.com/trace":          "projects/my-project/traces/4bf92f3577b34da6a3ce929d0e0e4736",
+		"logging.googleapis.com/spanId":         "00f067aa0ba902b7",
+		"logging.googleapis.com/trace_sampled":  true,
+		"httpRequest": map[string]interface{}{
+			"requestMethod": "POST",
+			"requestUrl":    "http://example.com/orders?page=2",
+			"protocol":      "HTTP/1.1",
+			"userAgent":     "curl/7.64.1",
+			"referer":       "https://example.com/",
+			"remoteIp":      "192.0.2.1",
+		},
+	}))
+}
+
+func TestReport(t *testing.T) {
+	setup(t, Config{ServiceName: "orders", ServiceVersion: "1.2.3"})
+
+	testProvider.Error(context.Background(), true, "Upload failed")
+	// With a stack trace from merry
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"~stackTrace": "main.upload\n\t/app/upload.go:20\n"})
+	testProvider.Error(ctx, true, "Upload failed again")
+	testProvider.Error(ctx, false, "Not reported")
+
+	Expect(lines()).To(Equal([]string{
+		`{"severity":"ERROR","message":"Upload failed","@type":"type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",` +
+			`"serviceContext":{"service":"orders","version":"1.2.3"},` +
+			`"stack_trace":"Upload failed\n\ngoroutine 7 [running]:\nmain.main()\n\t/app/main.go:10 +0x1d\n",` +
+			`"time":"2020-01-02T03:04:05.000000006Z"}` + "\n",
+		`{"severity":"ERROR","message":"Upload failed again","@type":"type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",` +
+			`"serviceContext":{"service":"orders","version":"1.2.3"},` +
+			`"stack_trace":"Upload failed again\n\ngoroutine 1 [running]:\nmain.upload\n\t/app/upload.go:20\n",` +
+			`"time":"2020-01-02T03:04:05.000000006Z"}` + "\n",
+		`{"severity":"ERROR","message":"Not reported","time":"2020-01-02T03:04:05.000000006Z","~stackTrace":"main.upload\n\t/app/upload.go:20\n"}` + "\n",
+	}))
+}
+
+func TestReportedAt(t *testing.T) {
+	setup(t, Config{})
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"reportedAt": "/src/app/main.go:42"})
+	testProvider.Info(ctx, false, "Here")
+
+	var decoded struct {
+		SourceLocation struct{ File, Line string } `json:"logging.googleapis.com/sourceLocation"`
+	}
+	Expect(json.Unmarshal(output.Bytes(), &decoded)).To(Succeed())
+	Expect(decoded.SourceLocation.File).To(Equal("/src/app/main.go"))
+	Expect(decoded.SourceLocation.Line).To(Equal("42"))
+	Expect(output.String()).NotTo(ContainSubstring("reportedAt"))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+
+	path := filepath.Join(t.TempDir(), "gcp.log")
+	provider, err := config.Build([]byte(`
+providers:
+  - name: gcp
+    options:
+      output: file://` + path + `
+      level: warn
+`))
+	Expect(err).To(BeNil())
+	provider.Info(context.Background(), false, "Filtered")
+	provider.Warn(context.Background(), false, "Written")
+	written, err := ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	Expect(string(written)).To(HavePrefix(`{"severity":"WARNING","message":"Written",`))
+	Expect(string(written)).NotTo(ContainSubstring("Filtered"))
+}
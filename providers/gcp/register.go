@@ -0,0 +1,20 @@
+package gcp
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"os"
+)
+
+func init() {
+	config.Register("gcp", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		return LogProvider(nextProvider, Config{
+			Output:         options.Writer("output", os.Stdout),
+			Level:          options.String("level", "info"),
+			ProjectID:      options.String("projectId", ""),
+			ServiceName:    options.String("serviceName", ""),
+			ServiceVersion: options.String("serviceVersion", ""),
+		})
+	})
+}
@@ -0,0 +1,300 @@
+/*
+This package provides JSON log output without going through logrus: each call is encoded straight
+into a pooled buffer, without building intermediate maps, and written to the output with a single
+Write. Common value types are encoded directly; anything else falls back to encoding/json.
+*/
+package json
+
+import (
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the keys for the standard parts of each line; use Omit to leave one out
+type Keys struct {
+	Time    string
+	Level   string
+	Message string
+	Caller  string
+}
+
+const Omit = "-"
+
+var DefaultKeys = Keys{
+	Time:    "time",
+	Level:   "level",
+	Message: "msg",
+	Caller:  "caller",
+}
+
+type Config struct {
+	Output io.Writer
+	Level  string
+	// Any left empty get their DefaultKeys name
+	Keys Keys
+	// Layout for time.Time.Format, used for the time key and any time.Time values; defaults to
+	// time.RFC3339Nano
+	TimeFormat string
+	// Write context fields sorted by key, rather than in the order they were added to the context
+	SortKeys bool
+	// Include the file and line which called the logger (which costs a stack walk per call)
+	Caller bool
+}
+
+type provider struct {
+	providers.LogProvider
+	output     io.Writer
+	writeMutex sync.Mutex
+	level      providers.LogLevel
+	keys       Keys
+	timeFormat string
+	sortKeys   bool
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	level, err := providers.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config.Output == nil {
+		config.Output = os.Stderr
+	}
+	keys := config.Keys
+	for _, key := range []struct {
+		name        *string
+		defaultName string
+	}{
+		{&keys.Time, DefaultKeys.Time},
+		{&keys.Level, DefaultKeys.Level},
+		{&keys.Message, DefaultKeys.Message},
+		{&keys.Caller, DefaultKeys.Caller},
+	} {
+		if *key.name == "" {
+			*key.name = key.defaultName
+		}
+	}
+	if !config.Caller {
+		keys.Caller = Omit
+	}
+	if config.TimeFormat == "" {
+		config.TimeFormat = time.RFC3339Nano
+	}
+	return &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		output:      config.Output,
+		level:       level,
+		keys:        keys,
+		timeFormat:  config.TimeFormat,
+		sortKeys:    config.SortKeys,
+	}, nil
+}
+
+type buffer struct {
+	bytes []byte
+	// Scratch space for sorting keys
+	keys []string
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &buffer{bytes: make([]byte, 0, 1024)}
+	},
+}
+
+// Don't keep hold of unusually large buffers
+const maxPooledBuffer = 64 * 1024
+
+func (p *provider) write(buf *buffer) {
+	p.writeMutex.Lock()
+	_, err := p.output.Write(buf.bytes)
+	p.writeMutex.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write to log, %v\n", err)
+	}
+	if cap(buf.bytes) <= maxPooledBuffer {
+		buf.bytes = buf.bytes[:0]
+		buf.keys = buf.keys[:0]
+		bufferPool.Put(buf)
+	}
+}
+
+func appendKey(buf []byte, key string) []byte {
+	if len(buf) > 1 {
+		buf = append(buf, ',')
+	}
+	buf = appendString(buf, key)
+	return append(buf, ':')
+}
+
+func (p *provider) reserved(key string) bool {
+	return key == p.keys.Time || key == p.keys.Level || key == p.keys.Message || key == p.keys.Caller
+}
+
+// Start a line with the standard keys
+func (p *provider) begin(level providers.LogLevel, args []interface{}) *buffer {
+	buf := bufferPool.Get().(*buffer)
+	b := append(buf.bytes, '{')
+	if p.keys.Time != Omit {
+		b = appendKey(b, p.keys.Time)
+		b = append(b, '"')
+		b = time.Now().AppendFormat(b, p.timeFormat)
+		b = append(b, '"')
+	}
+	if p.keys.Level != Omit {
+		b = appendKey(b, p.keys.Level)
+		b = appendString(b, level.String())
+	}
+	if p.keys.Message != Omit {
+		b = appendKey(b, p.keys.Message)
+		if len(args) == 1 {
+			if msg, ok := args[0].(string); ok {
+				b = appendString(b, msg)
+			} else {
+				b = appendString(b, fmt.Sprint(args...))
+			}
+		} else {
+			b = appendString(b, fmt.Sprint(args...))
+		}
+	}
+	if p.keys.Caller != Omit {
+		if file, line := caller(); file != "" {
+			b = appendKey(b, p.keys.Caller)
+			b = append(b, '"')
+			b = append(b, file...)
+			b = append(b, ':')
+			b = strconv.AppendInt(b, int64(line), 10)
+			b = append(b, '"')
+		}
+	}
+	buf.bytes = b
+	return buf
+}
+
+// Frames to skip when looking for the caller: ours, and anything else in contextlogger apart from
+// tests. Matching on function names keeps this independent of where the source lives on disk.
+const packagePrefix = "github.com/myhelix/contextlogger/"
+
+func caller() (string, int) {
+	var pcs [32]uintptr
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePrefix) || strings.HasSuffix(frame.File, "_test.go") {
+			return frame.File, frame.Line
+		}
+		if !more {
+			return "", 0
+		}
+	}
+}
+
+// Append fields in the order of keys, or sorted if keys is nil (for SortKeys); skipping any in skip
+// (which will be written separately)
+func (p *provider) appendFields(buf *buffer, fields map[string]interface{}, keys []string, skip map[string]interface{}) {
+	if len(fields) == 0 {
+		return
+	}
+	if keys == nil {
+		buf.keys = buf.keys[:0]
+		for key := range fields {
+			buf.keys = append(buf.keys, key)
+		}
+		sort.Strings(buf.keys)
+		keys = buf.keys
+	}
+	b := buf.bytes
+	for _, key := range keys {
+		if _, skipped := skip[key]; skipped {
+			continue
+		}
+		if p.reserved(key) {
+			// Same as logrus, so they aren't lost
+			b = appendKey(b, "fields."+key)
+		} else {
+			b = appendKey(b, key)
+		}
+		b = p.appendValue(b, fields[key])
+	}
+	buf.bytes = b
+}
+
+func (p *provider) end(buf *buffer) {
+	buf.bytes = append(buf.bytes, '}', '\n')
+	p.write(buf)
+}
+
+func (p *provider) fieldKeys(ctx context.Context) []string {
+	if p.sortKeys {
+		return nil
+	}
+	return log.OrderedKeys(ctx)
+}
+
+func (p *provider) log(ctx context.Context, level providers.LogLevel, args []interface{}) {
+	if level > p.level {
+		return
+	}
+	buf := p.begin(level, args)
+	p.appendFields(buf, log.FieldsFromContext(ctx), p.fieldKeys(ctx), nil)
+	p.end(buf)
+}
+
+var recordArgs = []interface{}{"Reporting metrics"}
+
+func (p *provider) record(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	if providers.Info > p.level {
+		return
+	}
+	buf := p.begin(providers.Info, recordArgs)
+	if eventName != "" {
+		buf.bytes = appendKey(buf.bytes, "eventName")
+		buf.bytes = appendString(buf.bytes, eventName)
+	}
+	// Metrics take precedence over context fields of the same name
+	p.appendFields(buf, log.FieldsFromContext(ctx), p.fieldKeys(ctx), metrics)
+	p.appendFields(buf, metrics, nil, nil)
+	p.end(buf)
+}
+
+func (p *provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Error, args)
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Warn, args)
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Info, args)
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Debug, args)
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName, metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
@@ -0,0 +1,233 @@
+package json
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+	cl_logrus "github.com/myhelix/contextlogger/providers/logrus"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var output *bytes.Buffer
+var testProvider providers.LogProvider
+
+func setup(t *testing.T, config Config) {
+	RegisterTestingT(t)
+
+	output = new(bytes.Buffer)
+	config.Output = output
+	if config.Level == "" {
+		config.Level = "debug"
+	}
+	if config.Keys.Time == "" {
+		config.Keys.Time = Omit // Omit timestamp to make output predictable
+	}
+	provider, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	testProvider = provider
+}
+
+func TestJSONLogs(t *testing.T) {
+	setup(t, Config{})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"last_name": "Gamgee"})
+	ctx = log.ContextWithFields(ctx, log.Fields{"first_name": "Sam", "age": 38})
+	testProvider.Info(ctx, false, "Hi there.")
+	testProvider.Debug(context.Background(), false, "Hi", " ", 2)
+	Expect(output.String()).To(Equal(
+		`{"level":"info","msg":"Hi there.","last_name":"Gamgee","age":38,"first_name":"Sam"}` + "\n" +
+			`{"level":"debug","msg":"Hi 2"}` + "\n"))
+}
+
+func TestSortKeys(t *testing.T) {
+	setup(t, Config{SortKeys: true})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"c": 1})
+	ctx = log.ContextWithFields(ctx, log.Fields{"b": 2, "a": 3})
+	testProvider.Warn(ctx, false, "sorted")
+	Expect(output.String()).To(Equal(`{"level":"warn","msg":"sorted","a":3,"b":2,"c":1}` + "\n"))
+}
+
+func TestLevel(t *testing.T) {
+	setup(t, Config{Level: "warn"})
+
+	testProvider.Info(context.Background(), false, "hidden")
+	testProvider.Error(context.Background(), false, "shown")
+	Expect(output.String()).To(Equal(`{"level":"error","msg":"shown"}` + "\n"))
+
+	_, err := LogProvider(nil, Config{Level: "loud"})
+	Expect(err).To(MatchError(`not a valid log level: "loud"`))
+}
+
+func TestKeys(t *testing.T) {
+	setup(t, Config{
+		Keys:       Keys{Time: "@timestamp", Level: "severity", Message: Omit, Caller: "src"},
+		TimeFormat: time.RFC1123,
+		Caller:     true,
+	})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"severity": "high", "msg": "kept"})
+	testProvider.Info(ctx, false, "omitted")
+
+	var line map[string]interface{}
+	Expect(json.Unmarshal(output.Bytes(), &line)).To(Succeed())
+	Expect(line).To(HaveLen(5))
+	Expect(line["severity"]).To(Equal("info"))
+	Expect(line["fields.severity"]).To(Equal("high"))
+	Expect(line["msg"]).To(Equal("kept"))
+	Expect(line["src"]).To(MatchRegexp(`/json/provider_test.go:\d+$`))
+	timestamp, err := time.Parse(time.RFC1123, line["@timestamp"].(string))
+	Expect(err).To(BeNil())
+	Expect(timestamp).To(BeTemporally("~", time.Now(), 2*time.Second))
+}
+
+type stringer struct{}
+
+func (stringer) String() string { return "stringer" }
+
+type panicky struct{}
+
+func (panicky) String() string { panic("oops") }
+
+type marshaler struct{}
+
+func (marshaler) MarshalJSON() ([]byte, error) { return []byte(`{ "custom" : true }`), nil }
+
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalJSON() ([]byte, error) { return nil, errors.New("can't") }
+
+func TestValues(t *testing.T) {
+	setup(t, Config{SortKeys: true})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		"bool":      true,
+		"bytes":     []byte("hi"),
+		"chan":      make(chan int),
+		"custom":    marshaler{},
+		"duration":  1500 * time.Millisecond,
+		"error":     errors.New("it broke"),
+		"float":     1.5,
+		"inf":       math.Inf(-1),
+		"map":       map[string]int{"one": 1},
+		"nan":       math.NaN(),
+		"nil":       nil,
+		"panicky":   panicky{},
+		"stringer":  stringer{},
+		"time":      time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC),
+		"uint8":     uint8(8),
+		"unmarshal": badMarshaler{},
+	})
+	testProvider.Info(ctx, false, "values")
+	Expect(output.String()).To(MatchRegexp(regexp.QuoteMeta(`{"level":"info","msg":"values",`+
+		`"bool":true,`+
+		`"bytes":"aGk=",`+
+		`"chan":"!ERROR(chan int): json: unsupported type: chan int",`+
+		`"custom":{"custom":true},`+
+		`"duration":"1.5s",`+
+		`"error":"it broke",`+
+		`"float":1.5,`+
+		`"inf":"-Inf",`+
+		`"map":{"one":1},`+
+		`"nan":"NaN",`+
+		`"nil":null,`+
+		`"panicky":"!PANIC(json.panicky): oops",`+
+		`"stringer":"stringer",`+
+		`"time":"2019-04-01T12:00:00Z",`+
+		`"uint8":8,`+
+		`"unmarshal":"!ERROR(json.badMarshaler): json: error calling MarshalJSON for type `) +
+		`\*?` + regexp.QuoteMeta(`json.badMarshaler: can't"}`+"\n")))
+}
+
+func TestEscaping(t *testing.T) {
+	setup(t, Config{})
+
+	tricky := "quote\" backslash\\ newline\n tab\t control\x01 unicode✓ invalid\xff separator\u2028\u2029 <html>&"
+	ctx := log.ContextWithFields(context.Background(), log.Fields{tricky: tricky})
+	testProvider.Error(ctx, false, tricky)
+
+	var line map[string]string
+	Expect(json.Unmarshal(output.Bytes(), &line)).To(Succeed())
+	expected := strings.Replace(tricky, "\xff", "�", 1)
+	Expect(line).To(Equal(map[string]string{"level": "error", "msg": expected, expected: expected}))
+	Expect(output.String()).To(ContainSubstring(`separator\u2028\u2029 <html>&`))
+}
+
+func TestRecord(t *testing.T) {
+	setup(t, Config{})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"requestId": "abc", "count": 0})
+	testProvider.Record(ctx, log.Metrics{"count": 2, "bytes": 1024})
+	testProvider.RecordEvent(ctx, "Upload", log.Metrics{"bytes": 1024})
+	Expect(output.String()).To(Equal(
+		`{"level":"info","msg":"Reporting metrics","requestId":"abc","bytes":1024,"count":2}` + "\n" +
+			`{"level":"info","msg":"Reporting metrics","eventName":"Upload","count":0,"requestId":"abc","bytes":1024}` + "\n"))
+}
+
+// The only allocations should be the ones any LogProvider call makes, for its variadic arguments
+func TestNoAllocations(t *testing.T) {
+	RegisterTestingT(t)
+	provider, _ := LogProvider(nil, Config{Output: ioutil.Discard, Level: "info"})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		"user":    "sam",
+		"attempt": 3,
+		"ok":      false,
+		"elapsed": 0.25,
+	})
+	allocs := func(provider providers.LogProvider) float64 {
+		return testing.AllocsPerRun(100, func() {
+			provider.Info(ctx, false, "message")
+			provider.Debug(ctx, false, "disabled")
+		})
+	}
+	Expect(allocs(provider)).To(Equal(allocs(chaining.LogProvider(nil))))
+}
+
+var benchmarkFields = log.Fields{
+	"user":    "sam",
+	"attempt": 3,
+	"ok":      false,
+	"elapsed": 0.25,
+	"error":   errors.New("it broke"),
+}
+
+func benchmarkProvider(b *testing.B, provider providers.LogProvider) {
+	ctx := log.ContextWithFields(context.Background(), benchmarkFields)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.Info(ctx, false, "Something happened")
+	}
+}
+
+func BenchmarkJSON(b *testing.B) {
+	provider, _ := LogProvider(nil, Config{Output: ioutil.Discard, Level: "info"})
+	benchmarkProvider(b, provider)
+}
+
+func BenchmarkJSONSorted(b *testing.B) {
+	provider, _ := LogProvider(nil, Config{Output: ioutil.Discard, Level: "info", SortKeys: true})
+	benchmarkProvider(b, provider)
+}
+
+func BenchmarkLogrusJSONFormatter(b *testing.B) {
+	provider, _ := cl_logrus.LogProvider(nil, cl_logrus.Config{
+		Output:    ioutil.Discard,
+		Level:     "info",
+		Formatter: &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano},
+	})
+	benchmarkProvider(b, provider)
+}
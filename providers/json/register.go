@@ -0,0 +1,35 @@
+package json
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"os"
+)
+
+func init() {
+	config.Register("json", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		keys := options.StringMap("keys")
+		providerConfig := Config{
+			Output: options.Writer("output", os.Stderr),
+			Level:  options.String("level", "info"),
+			Keys: Keys{
+				Time:    keys["time"],
+				Level:   keys["level"],
+				Message: keys["message"],
+				Caller:  keys["caller"],
+			},
+			TimeFormat: options.String("timeFormat", ""),
+			SortKeys:   options.Bool("sortKeys", false),
+			Caller:     options.Bool("caller", false),
+		}
+		for key := range keys {
+			switch key {
+			case "time", "level", "message", "caller":
+			default:
+				options.Errorf("option \"keys\": unknown key %q (expected time, level, message or caller)", key)
+			}
+		}
+		return LogProvider(nextProvider, providerConfig)
+	})
+}
@@ -0,0 +1,129 @@
+package level
+
+import (
+	"github.com/myhelix/contextlogger/providers"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type overrideView struct {
+	Level   string    `json:"level"`
+	Expires time.Time `json:"expires"`
+}
+
+type settingView struct {
+	// Empty for loggers which follow the global level apart from their override
+	Level     string        `json:"level,omitempty"`
+	Effective string        `json:"effective"`
+	Override  *overrideView `json:"override,omitempty"`
+}
+
+type statusView struct {
+	settingView
+	Loggers map[string]settingView `json:"loggers"`
+}
+
+func (p *Provider) status() statusView {
+	s := p.load()
+	now := time.Now()
+	view := func(name string, setting Setting) settingView {
+		v := settingView{Effective: s.level(name, now).String()}
+		if !setting.FollowsGlobal {
+			v.Level = setting.Level.String()
+		}
+		if setting.overridden(now) {
+			v.Override = &overrideView{setting.Override.Level.String(), setting.Override.Expires}
+		}
+		return v
+	}
+	status := statusView{
+		settingView: view("", s.global),
+		Loggers:     make(map[string]settingView, len(s.loggers)),
+	}
+	for name, setting := range s.loggers {
+		status.Loggers[name] = view(name, setting)
+	}
+	return status
+}
+
+/*
+Handler serves the current levels as JSON, and changes them, at these paths relative to wherever
+it's mounted (use http.StripPrefix to mount it under a prefix):
+
+	GET    /                  show global and per-logger levels
+	PUT    /?level=debug      set the global level
+	PUT    /?level=debug&ttl=10m
+	                          override the global level for 10 minutes
+	PUT    /loggers/db?level=debug[&ttl=10m]
+	                          set or override the level for calls whose logger field is "db"
+	DELETE /loggers/db        make those calls follow the global level again
+
+POST may be used instead of PUT, and level and ttl may also be sent as form values. Changes
+respond with the new state, like GET.
+*/
+func (p *Provider) Handler() http.Handler {
+	return http.HandlerFunc(p.serveHTTP)
+}
+
+func (p *Provider) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	var name string
+	switch {
+	case path == "":
+	case strings.HasPrefix(path, "loggers/") && len(path) > len("loggers/"):
+		name = strings.TrimPrefix(path, "loggers/")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut, http.MethodPost:
+		if err := p.change(r, name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		if name == "" {
+			http.Error(w, "the global level can't be deleted", http.StatusMethodNotAllowed)
+			return
+		}
+		p.ClearLoggerLevel(name)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(p.status())
+}
+
+func (p *Provider) change(r *http.Request, name string) error {
+	level, err := providers.ParseLevel(r.FormValue("level"))
+	if err != nil {
+		return err
+	}
+	ttl := r.FormValue("ttl")
+	if ttl == "" {
+		if name == "" {
+			p.SetLevel(level)
+		} else {
+			p.SetLoggerLevel(name, level)
+		}
+		return nil
+	}
+	duration, err := time.ParseDuration(ttl)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("ttl must be a positive duration such as \"10m\", not %q", ttl)
+	}
+	p.override(name, level, duration)
+	return nil
+}
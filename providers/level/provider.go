@@ -0,0 +1,260 @@
+/*
+This package filters log calls by level, with a level that can be changed while the program is
+running: globally, or for log calls carrying a particular logger name field, and either
+permanently or as a temporary override that reverts after a TTL. Handler exposes these controls
+over HTTP, for mounting on an internal admin port.
+
+Put this first in the chain, and configure providers after it (e.g. logrus) to log everything,
+since they can't be adjusted at runtime themselves. Report calls and metrics are never filtered.
+*/
+package level
+
+import (
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	// Initial global level; defaults to info
+	Level string
+	// Context field holding the logger name, for per-logger levels; defaults to "logger"
+	NameField string
+}
+
+const DefaultNameField = "logger"
+
+type Override struct {
+	Level   providers.LogLevel
+	Expires time.Time
+}
+
+type Setting struct {
+	Level providers.LogLevel
+	// Only for loggers with a temporary override but no level of their own; they follow the global
+	// level once the override expires, and Level is unused.
+	FollowsGlobal bool
+	// Temporary level, which applies instead of Level until it expires
+	Override *Override
+}
+
+func (s Setting) overridden(now time.Time) bool {
+	return s.Override != nil && now.Before(s.Override.Expires)
+}
+
+// Immutable once published, so log calls can read it without locking
+type state struct {
+	global  Setting
+	loggers map[string]Setting
+}
+
+func (s *state) level(name string, now time.Time) providers.LogLevel {
+	if setting, ok := s.loggers[name]; ok {
+		if setting.overridden(now) {
+			return setting.Override.Level
+		}
+		if !setting.FollowsGlobal {
+			return setting.Level
+		}
+	}
+	if s.global.overridden(now) {
+		return s.global.Override.Level
+	}
+	return s.global.Level
+}
+
+type Provider struct {
+	providers.LogProvider
+	nameField string
+	state     atomic.Value // *state
+	// Serializes changes; readers just load state
+	mutex sync.Mutex
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (*Provider, error) {
+	level := providers.Info
+	if config.Level != "" {
+		var err error
+		if level, err = providers.ParseLevel(config.Level); err != nil {
+			return nil, err
+		}
+	}
+	if config.NameField == "" {
+		config.NameField = DefaultNameField
+	}
+	p := &Provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		nameField:   config.NameField,
+	}
+	p.state.Store(&state{global: Setting{Level: level}})
+	return p, nil
+}
+
+func (p *Provider) load() *state {
+	return p.state.Load().(*state)
+}
+
+// Global returns the global level setting
+func (p *Provider) Global() Setting {
+	return p.load().global
+}
+
+// Loggers returns the per-logger level settings
+func (p *Provider) Loggers() map[string]Setting {
+	loggers := p.load().loggers
+	result := make(map[string]Setting, len(loggers))
+	for name, setting := range loggers {
+		result[name] = setting
+	}
+	return result
+}
+
+// Level returns the level currently in effect for calls with the given logger name ("" for none)
+func (p *Provider) Level(name string) providers.LogLevel {
+	return p.load().level(name, time.Now())
+}
+
+func (p *Provider) enabled(ctx context.Context, level providers.LogLevel) bool {
+	s := p.load()
+	var name string
+	// Skip looking at the fields unless there's some reason to
+	if len(s.loggers) > 0 {
+		name, _ = log.FieldsFromContext(ctx)[p.nameField].(string)
+	}
+	return level <= s.level(name, time.Now())
+}
+
+// Apply f to a copy of the current state, and publish the result
+func (p *Provider) update(f func(s *state)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	current := p.load()
+	next := &state{
+		global:  current.global,
+		loggers: make(map[string]Setting, len(current.loggers)),
+	}
+	for name, setting := range current.loggers {
+		next.loggers[name] = setting
+	}
+	f(next)
+	p.state.Store(next)
+}
+
+// Log changes downstream of ourselves, so they aren't filtered out by the change being logged
+func (p *Provider) logChange(name string, fields log.Fields, msg string) {
+	if name != "" {
+		fields[p.nameField] = name
+	}
+	log.FromContextAndProvider(context.Background(), p.LogProvider).WithFields(fields).Info(msg)
+}
+
+// SetLevel permanently sets the global level, cancelling any temporary override
+func (p *Provider) SetLevel(level providers.LogLevel) {
+	p.update(func(s *state) {
+		s.global = Setting{Level: level}
+	})
+	p.logChange("", log.Fields{"level": level.String()}, "Set log level")
+}
+
+// SetLoggerLevel permanently sets the level for calls with the given logger name
+func (p *Provider) SetLoggerLevel(name string, level providers.LogLevel) {
+	p.update(func(s *state) {
+		s.loggers[name] = Setting{Level: level}
+	})
+	p.logChange(name, log.Fields{"level": level.String()}, "Set log level")
+}
+
+// ClearLoggerLevel makes calls with the given logger name follow the global level again
+func (p *Provider) ClearLoggerLevel(name string) {
+	p.update(func(s *state) {
+		delete(s.loggers, name)
+	})
+	p.logChange(name, log.Fields{}, "Cleared log level")
+}
+
+// Override temporarily sets the global level, reverting after ttl
+func (p *Provider) Override(level providers.LogLevel, ttl time.Duration) {
+	p.override("", level, ttl)
+}
+
+// OverrideLogger temporarily sets the level for calls with the given logger name, reverting
+// after ttl to its previous setting (or to following the global level).
+func (p *Provider) OverrideLogger(name string, level providers.LogLevel, ttl time.Duration) {
+	p.override(name, level, ttl)
+}
+
+func (p *Provider) override(name string, level providers.LogLevel, ttl time.Duration) {
+	override := &Override{Level: level, Expires: time.Now().Add(ttl)}
+	p.update(func(s *state) {
+		if name == "" {
+			s.global.Override = override
+			return
+		}
+		setting, ok := s.loggers[name]
+		if !ok {
+			setting.FollowsGlobal = true
+		}
+		setting.Override = override
+		s.loggers[name] = setting
+	})
+	p.logChange(name, log.Fields{
+		"level":   level.String(),
+		"expires": override.Expires.Format(time.RFC3339),
+	}, "Overrode log level")
+
+	// The override stops applying on its own when it expires; this just tidies up and logs it
+	time.AfterFunc(ttl, func() { p.expire(name, override) })
+}
+
+func (p *Provider) expire(name string, override *Override) {
+	expired := false
+	p.update(func(s *state) {
+		if name == "" {
+			if s.global.Override == override {
+				s.global.Override = nil
+				expired = true
+			}
+		} else if setting, ok := s.loggers[name]; ok && setting.Override == override {
+			if setting.FollowsGlobal {
+				delete(s.loggers, name)
+			} else {
+				setting.Override = nil
+				s.loggers[name] = setting
+			}
+			expired = true
+		}
+	})
+	if expired {
+		p.logChange(name, log.Fields{"level": p.Level(name).String()}, "Log level override expired")
+	}
+}
+
+func (p *Provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	if report || p.enabled(ctx, providers.Error) {
+		p.LogProvider.Error(ctx, report, args...)
+	}
+}
+
+func (p *Provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	if report || p.enabled(ctx, providers.Warn) {
+		p.LogProvider.Warn(ctx, report, args...)
+	}
+}
+
+func (p *Provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	if report || p.enabled(ctx, providers.Info) {
+		p.LogProvider.Info(ctx, report, args...)
+	}
+}
+
+func (p *Provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	if report || p.enabled(ctx, providers.Debug) {
+		p.LogProvider.Debug(ctx, report, args...)
+	}
+}
@@ -0,0 +1,216 @@
+package level
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/structured"
+	. "github.com/onsi/gomega"
+)
+
+var output *structured.StructuredOutputLogProvider
+var testProvider *Provider
+
+func setup(t *testing.T) {
+	RegisterTestingT(t)
+
+	output = structured.LogProvider(nil)
+	var err error
+	testProvider, err = LogProvider(output, Config{Level: "warn"})
+	Expect(err).To(BeNil())
+}
+
+// Messages logged by the caller, as opposed to about level changes
+func messages() (result []string) {
+	for _, call := range output.LogCalls() {
+		if msg, ok := call.Args[0].(string); ok && !strings.Contains(msg, "log level") {
+			result = append(result, msg)
+		}
+	}
+	return
+}
+
+func logAll(ctx context.Context) {
+	testProvider.Error(ctx, false, "error")
+	testProvider.Warn(ctx, false, "warn")
+	testProvider.Info(ctx, false, "info")
+	testProvider.Debug(ctx, false, "debug")
+}
+
+func TestGlobalLevel(t *testing.T) {
+	setup(t)
+
+	logAll(context.Background())
+	Expect(messages()).To(Equal([]string{"error", "warn"}))
+
+	testProvider.SetLevel(providers.Debug)
+	logAll(context.Background())
+	Expect(messages()).To(Equal([]string{"error", "warn", "error", "warn", "info", "debug"}))
+
+	changes := output.LogCalls(providers.Info)
+	Expect(changes[0].Args).To(Equal([]interface{}{"Set log level"}))
+	Expect(changes[0].ContextFields).To(Equal(log.Fields{"level": "debug"}))
+}
+
+func TestReportsAreNotFiltered(t *testing.T) {
+	setup(t)
+
+	testProvider.Debug(context.Background(), true, "debug")
+	Expect(messages()).To(Equal([]string{"debug"}))
+}
+
+func TestBadLevel(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := LogProvider(nil, Config{Level: "loud"})
+	Expect(err).To(MatchError(`not a valid log level: "loud"`))
+}
+
+func TestLoggerLevel(t *testing.T) {
+	setup(t)
+	db := log.ContextWithFields(context.Background(), log.Fields{"logger": "db"})
+
+	testProvider.SetLoggerLevel("db", providers.Debug)
+	logAll(db)
+	logAll(context.Background())
+	Expect(messages()).To(Equal([]string{"error", "warn", "info", "debug", "error", "warn"}))
+
+	testProvider.ClearLoggerLevel("db")
+	Expect(testProvider.Level("db")).To(Equal(providers.Warn))
+	Expect(testProvider.Loggers()).To(BeEmpty())
+}
+
+func TestOverrideExpires(t *testing.T) {
+	setup(t)
+	db := log.ContextWithFields(context.Background(), log.Fields{"logger": "db"})
+
+	testProvider.Override(providers.Info, 50*time.Millisecond)
+	testProvider.OverrideLogger("db", providers.Debug, 50*time.Millisecond)
+	Expect(testProvider.Level("")).To(Equal(providers.Info))
+	Expect(testProvider.Level("db")).To(Equal(providers.Debug))
+	logAll(db)
+	Expect(messages()).To(Equal([]string{"error", "warn", "info", "debug"}))
+
+	Eventually(func() providers.LogLevel { return testProvider.Level("") }).Should(Equal(providers.Warn))
+	Eventually(testProvider.Loggers).Should(BeEmpty())
+	Eventually(testProvider.Global).Should(Equal(Setting{Level: providers.Warn}))
+
+	var expired []string
+	for _, call := range output.LogCalls(providers.Info) {
+		if call.Args[0] == "Log level override expired" {
+			expired = append(expired, call.ContextFields["level"].(string))
+		}
+	}
+	Expect(expired).To(ConsistOf("warn", "warn"))
+}
+
+func TestOverrideRevertsToLoggerLevel(t *testing.T) {
+	setup(t)
+
+	testProvider.SetLoggerLevel("db", providers.Error)
+	testProvider.OverrideLogger("db", providers.Debug, 20*time.Millisecond)
+	Expect(testProvider.Level("db")).To(Equal(providers.Debug))
+	Eventually(func() providers.LogLevel { return testProvider.Level("db") }).Should(Equal(providers.Error))
+}
+
+func serve(method, target string) (*httptest.ResponseRecorder, statusView) {
+	recorder := httptest.NewRecorder()
+	testProvider.Handler().ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+	var status statusView
+	if recorder.Code == http.StatusOK {
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &status)).To(Succeed())
+	}
+	return recorder, status
+}
+
+func TestHandler(t *testing.T) {
+	setup(t)
+
+	recorder, status := serve("GET", "/")
+	Expect(recorder.Code).To(Equal(http.StatusOK))
+	Expect(status.Level).To(Equal("warn"))
+	Expect(status.Loggers).To(BeEmpty())
+
+	_, status = serve("PUT", "/?level=info")
+	Expect(status.Level).To(Equal("info"))
+	Expect(testProvider.Level("")).To(Equal(providers.Info))
+
+	_, status = serve("POST", "/loggers/db?level=debug&ttl=1h")
+	Expect(status.Loggers["db"].Level).To(Equal(""))
+	Expect(status.Loggers["db"].Effective).To(Equal("debug"))
+	Expect(status.Loggers["db"].Override.Level).To(Equal("debug"))
+	Expect(status.Loggers["db"].Override.Expires).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+	recorder = httptest.NewRecorder()
+	form := url.Values{"level": {"error"}}
+	request := httptest.NewRequest("PUT", "/loggers/http", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	testProvider.Handler().ServeHTTP(recorder, request)
+	Expect(recorder.Code).To(Equal(http.StatusOK))
+	Expect(testProvider.Level("http")).To(Equal(providers.Error))
+
+	_, status = serve("DELETE", "/loggers/db")
+	Expect(status.Loggers).To(HaveLen(1))
+	Expect(status.Loggers).To(HaveKey("http"))
+
+	recorder, _ = serve("PUT", "/?level=loud")
+	Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	recorder, _ = serve("PUT", "/?level=info&ttl=-1m")
+	Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	recorder, _ = serve("DELETE", "/")
+	Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	recorder, _ = serve("PATCH", "/")
+	Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	recorder, _ = serve("GET", "/loggers/")
+	Expect(recorder.Code).To(Equal(http.StatusNotFound))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+	// Find a free port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	address := listener.Addr().String()
+	listener.Close()
+
+	chain, err := config.Parse([]byte(`
+providers:
+  - name: level
+    options:
+      level: warn
+      nameField: component
+      listen: ` + address + `
+`))
+	Expect(err).To(BeNil())
+	provider, closer, err := config.OpenChain(chain)
+	Expect(err).To(BeNil())
+	Expect(provider.(*Provider).Level("")).To(Equal(providers.Warn))
+
+	resp, err := http.Get("http://" + address + "/log-level/")
+	Expect(err).To(BeNil())
+	defer resp.Body.Close()
+	var status statusView
+	Expect(json.NewDecoder(resp.Body).Decode(&status)).To(Succeed())
+	Expect(status.Level).To(Equal("warn"))
+
+	// Closing the chain stops serving, and frees the port for the next one
+	Expect(closer.Close()).To(Succeed())
+	_, err = http.Get("http://" + address + "/log-level/")
+	Expect(err).NotTo(BeNil())
+	_, closer, err = config.OpenChain(chain)
+	Expect(err).To(BeNil())
+	Expect(closer.Close()).To(Succeed())
+
+	_, err = config.Build([]byte(`{"providers": [{"name": "level", "options": {"level": "loud"}}]}`))
+	Expect(err).To(MatchError(ContainSubstring(`config: providers[0] (level): `)))
+}
@@ -0,0 +1,53 @@
+package level
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func init() {
+	// Registered as an enricher, since it can only filter the sinks after it
+	config.Register("level", config.Enricher, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := Config{
+			Level:     options.String("level", ""),
+			NameField: options.String("nameField", ""),
+		}
+		// Where to serve Handler, e.g. "localhost:8081"; not served if empty
+		listen := options.String("listen", "")
+		path := strings.TrimSuffix(options.String("path", "/log-level"), "/")
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+
+		provider, err := LogProvider(nextProvider, providerConfig)
+		if err != nil {
+			return nil, err
+		}
+		if listen != "" {
+			options.OnBuilt(func() error {
+				listener, err := net.Listen("tcp", listen)
+				if err != nil {
+					return err
+				}
+				mux := http.NewServeMux()
+				mux.Handle(path+"/", http.StripPrefix(path, provider.Handler()))
+				server := &http.Server{Handler: mux}
+				go func() {
+					if err := server.Serve(listener); err != http.ErrServerClosed {
+						fmt.Fprintf(os.Stderr, "Stopped serving log levels, %v\n", err)
+					}
+				}()
+				// Stop listening if the chain is closed, or another provider fails to start
+				options.OnClose(server.Close)
+				return nil
+			})
+		}
+		return provider, nil
+	})
+}
@@ -0,0 +1,134 @@
+package logfmt
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const hex = "0123456789abcdef"
+
+// Keys can't be quoted, so anything which would need quoting is replaced
+func appendKey(buf []byte, key string) []byte {
+	if key == "" {
+		return append(buf, '_')
+	}
+	for i := 0; i < len(key); {
+		r, size := utf8.DecodeRuneInString(key[i:])
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+			buf = append(buf, '_')
+		} else {
+			buf = append(buf, key[i:i+size]...)
+		}
+		i += size
+	}
+	return buf
+}
+
+func needsQuoting(s string) bool {
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+			return true
+		}
+	}
+	// Otherwise it would read back as a nil value
+	return s == "null"
+}
+
+func appendValue(buf []byte, s string) []byte {
+	if !needsQuoting(s) {
+		return append(buf, s...)
+	}
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			buf = append(buf, '\\', byte(r))
+		case r == '\n':
+			buf = append(buf, '\\', 'n')
+		case r == '\r':
+			buf = append(buf, '\\', 'r')
+		case r == '\t':
+			buf = append(buf, '\\', 't')
+		case r < ' ':
+			buf = append(buf, '\\', 'u', '0', '0', hex[r>>4], hex[r&0xF])
+		case r == utf8.RuneError && size == 1:
+			buf = append(buf, `\ufffd`...)
+		default:
+			buf = append(buf, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(buf, '"')
+}
+
+// Append key=val, flattening maps into dotted keys
+func (p *provider) appendPair(buf []byte, key string, val interface{}) []byte {
+	if val != nil {
+		if rv := reflect.ValueOf(val); rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+			keys := rv.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+			for _, k := range keys {
+				buf = p.appendPair(buf, key+"."+k.String(), rv.MapIndex(k).Interface())
+			}
+			return buf
+		}
+	}
+	if len(buf) > 0 {
+		buf = append(buf, ' ')
+	}
+	buf = appendKey(buf, key)
+	buf = append(buf, '=')
+	if val == nil {
+		return append(buf, "null"...)
+	}
+	return appendValue(buf, p.formatValue(val))
+}
+
+// Format a value as a string, which will then be quoted if necessary
+func (p *provider) formatValue(val interface{}) (result string) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = fmt.Sprintf("!PANIC(%T): %v", val, r)
+		}
+	}()
+
+	switch v := val.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float32:
+		return formatFloat(float64(v), 32)
+	case float64:
+		return formatFloat(v, 64)
+	case time.Time:
+		return v.Format(p.timeFormat)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprintf("%+v", val)
+}
+
+func formatFloat(f float64, bits int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
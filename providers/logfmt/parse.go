@@ -0,0 +1,113 @@
+package logfmt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// Parse one line of logfmt into its key/value pairs, in order. A key without a value (no "=")
+// parses with an empty Value, the same as "key=".
+func Parse(line string) ([]Pair, error) {
+	var pairs []Pair
+	i := 0
+	for {
+		for i < len(line) && line[i] <= ' ' {
+			i++
+		}
+		if i == len(line) {
+			return pairs, nil
+		}
+
+		start := i
+		for i < len(line) && line[i] > ' ' && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		if i == start {
+			return nil, fmt.Errorf("logfmt: unexpected %q at column %d, expecting a key", line[i], i+1)
+		}
+		pair := Pair{Key: line[start:i]}
+		if i < len(line) && line[i] == '=' {
+			i++
+			var err error
+			if pair.Value, i, err = parseValue(line, i); err != nil {
+				return nil, err
+			}
+		} else if i < len(line) && line[i] == '"' {
+			return nil, fmt.Errorf("logfmt: unexpected '\"' at column %d, in key", i+1)
+		}
+		pairs = append(pairs, pair)
+	}
+}
+
+// Parse a bare or quoted value starting at line[i], returning it and the index after it
+func parseValue(line string, i int) (string, int, error) {
+	if i == len(line) || line[i] <= ' ' {
+		return "", i, nil
+	}
+	if line[i] != '"' {
+		start := i
+		for i < len(line) && line[i] > ' ' {
+			if line[i] == '=' || line[i] == '"' {
+				return "", i, fmt.Errorf("logfmt: unexpected %q at column %d, in unquoted value", line[i], i+1)
+			}
+			i++
+		}
+		return line[start:i], i, nil
+	}
+
+	var value strings.Builder
+	for i++; i < len(line); {
+		switch c := line[i]; {
+		case c == '"':
+			return value.String(), i + 1, nil
+		case c == '\\':
+			if i+1 == len(line) {
+				return "", i, fmt.Errorf("logfmt: unterminated escape at column %d", i+1)
+			}
+			switch e := line[i+1]; e {
+			case '"', '\\', '/':
+				value.WriteByte(e)
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case 'b':
+				value.WriteByte('\b')
+			case 'f':
+				value.WriteByte('\f')
+			case 'u':
+				if i+6 > len(line) {
+					return "", i, fmt.Errorf("logfmt: short \\u escape at column %d", i+1)
+				}
+				r, err := strconv.ParseUint(line[i+2:i+6], 16, 16)
+				if err != nil {
+					return "", i, fmt.Errorf("logfmt: bad \\u escape at column %d", i+1)
+				}
+				value.WriteRune(rune(r))
+				i += 4
+			default:
+				return "", i, fmt.Errorf("logfmt: unknown escape %q at column %d", line[i:i+2], i+1)
+			}
+			i += 2
+		case c < ' ':
+			return "", i, fmt.Errorf("logfmt: control character %q in quoted value at column %d", c, i+1)
+		default:
+			r, size := utf8.DecodeRuneInString(line[i:])
+			if r == utf8.RuneError && size == 1 {
+				return "", i, fmt.Errorf("logfmt: invalid UTF-8 at column %d", i+1)
+			}
+			value.WriteString(line[i : i+size])
+			i += size
+		}
+	}
+	return "", i, fmt.Errorf("logfmt: unterminated quoted value")
+}
@@ -0,0 +1,207 @@
+/*
+This package provides logfmt log output, as understood by e.g. github.com/go-logfmt/logfmt: one
+line of key=value pairs per call, with values quoted only where necessary, and nested maps
+flattened into dotted keys (a field "http" holding {"method": "GET"} becomes http.method=GET).
+The standard keys come first, followed by context fields in the order they were added (or sorted).
+*/
+package logfmt
+
+import (
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Names of the keys for the standard parts of each line; use Omit to leave one out
+type Keys struct {
+	Time    string
+	Level   string
+	Message string
+}
+
+const Omit = "-"
+
+var DefaultKeys = Keys{
+	Time:    "time",
+	Level:   "level",
+	Message: "msg",
+}
+
+type Config struct {
+	Output io.Writer
+	Level  string
+	// Any left empty get their DefaultKeys name
+	Keys Keys
+	// Layout for time.Time.Format, used for the time key and any time.Time values; defaults to
+	// time.RFC3339Nano
+	TimeFormat string
+	// Write context fields sorted by key, rather than in the order they were added to the context
+	SortKeys bool
+}
+
+type provider struct {
+	providers.LogProvider
+	output     io.Writer
+	writeMutex sync.Mutex
+	level      providers.LogLevel
+	keys       Keys
+	timeFormat string
+	sortKeys   bool
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	level, err := providers.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config.Output == nil {
+		config.Output = os.Stderr
+	}
+	if config.Keys.Time == "" {
+		config.Keys.Time = DefaultKeys.Time
+	}
+	if config.Keys.Level == "" {
+		config.Keys.Level = DefaultKeys.Level
+	}
+	if config.Keys.Message == "" {
+		config.Keys.Message = DefaultKeys.Message
+	}
+	if config.TimeFormat == "" {
+		config.TimeFormat = time.RFC3339Nano
+	}
+	return &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		output:      config.Output,
+		level:       level,
+		keys:        config.Keys,
+		timeFormat:  config.TimeFormat,
+		sortKeys:    config.SortKeys,
+	}, nil
+}
+
+func (p *provider) reserved(key string) bool {
+	return key == p.keys.Time || key == p.keys.Level || key == p.keys.Message
+}
+
+// Format a line with the standard keys, then fields (other than any in skip) in the order of keys
+// (or sorted, if keys is nil), then extra sorted
+func (p *provider) formatLine(
+	level providers.LogLevel,
+	msg string,
+	fields map[string]interface{},
+	keys []string,
+	skip map[string]interface{},
+	extra map[string]interface{},
+) []byte {
+	var buf []byte
+	if p.keys.Time != Omit {
+		buf = p.appendPair(buf, p.keys.Time, time.Now().Format(p.timeFormat))
+	}
+	if p.keys.Level != Omit {
+		buf = p.appendPair(buf, p.keys.Level, level.String())
+	}
+	if p.keys.Message != Omit {
+		buf = p.appendPair(buf, p.keys.Message, msg)
+	}
+	for _, set := range []struct {
+		fields map[string]interface{}
+		keys   []string
+		skip   map[string]interface{}
+	}{
+		{fields, keys, skip},
+		{extra, nil, nil},
+	} {
+		keys := set.keys
+		if keys == nil {
+			keys = make([]string, 0, len(set.fields))
+			for key := range set.fields {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+		}
+		for _, key := range keys {
+			if _, skipped := set.skip[key]; skipped {
+				continue
+			}
+			if p.reserved(key) {
+				// Same as logrus, so they aren't lost
+				buf = p.appendPair(buf, "fields."+key, set.fields[key])
+			} else {
+				buf = p.appendPair(buf, key, set.fields[key])
+			}
+		}
+	}
+	return append(buf, '\n')
+}
+
+func (p *provider) write(line []byte) {
+	p.writeMutex.Lock()
+	_, err := p.output.Write(line)
+	p.writeMutex.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write to log, %v\n", err)
+	}
+}
+
+func (p *provider) fieldKeys(ctx context.Context) []string {
+	if p.sortKeys {
+		return nil
+	}
+	return log.OrderedKeys(ctx)
+}
+
+func (p *provider) log(ctx context.Context, level providers.LogLevel, args []interface{}) {
+	if level > p.level {
+		return
+	}
+	p.write(p.formatLine(level, fmt.Sprint(args...), log.FieldsFromContext(ctx), p.fieldKeys(ctx), nil, nil))
+}
+
+func (p *provider) record(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	if providers.Info > p.level {
+		return
+	}
+	if eventName != "" {
+		ctx = log.ContextWithFields(ctx, log.Fields{"eventName": eventName})
+	}
+	// Metrics take precedence over context fields of the same name
+	p.write(p.formatLine(providers.Info, "Reporting metrics", log.FieldsFromContext(ctx), p.fieldKeys(ctx), metrics, metrics))
+}
+
+func (p *provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Error, args)
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Warn, args)
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Info, args)
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Debug, args)
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName, metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
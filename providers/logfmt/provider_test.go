@@ -0,0 +1,186 @@
+package logfmt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	. "github.com/onsi/gomega"
+)
+
+var output *bytes.Buffer
+var testProvider providers.LogProvider
+
+func setup(t *testing.T, config Config) {
+	RegisterTestingT(t)
+
+	output = new(bytes.Buffer)
+	config.Output = output
+	if config.Level == "" {
+		config.Level = "debug"
+	}
+	if config.Keys.Time == "" {
+		config.Keys.Time = Omit // Omit timestamp to make output predictable
+	}
+	provider, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	testProvider = provider
+}
+
+func parseOutput() [][]Pair {
+	var result [][]Pair
+	lines := strings.SplitAfter(output.String(), "\n")
+	Expect(lines[len(lines)-1]).To(BeEmpty())
+	for _, line := range lines[:len(lines)-1] {
+		pairs, err := Parse(line)
+		Expect(err).To(BeNil(), line)
+		result = append(result, pairs)
+	}
+	return result
+}
+
+func TestLogfmtLogs(t *testing.T) {
+	setup(t, Config{})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"last_name": "Gamgee"})
+	ctx = log.ContextWithFields(ctx, log.Fields{"first_name": "Sam", "age": 38})
+	testProvider.Info(ctx, false, "Hi there.")
+	testProvider.Debug(context.Background(), false, "Hi", 2)
+	Expect(output.String()).To(Equal(
+		`level=info msg="Hi there." last_name=Gamgee age=38 first_name=Sam` + "\n" +
+			`level=debug msg=Hi2` + "\n"))
+}
+
+func TestLevelAndKeys(t *testing.T) {
+	setup(t, Config{Level: "warn", Keys: Keys{Time: "ts", Level: "lvl", Message: "message"}, TimeFormat: time.RFC1123})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"lvl": "mine"})
+	testProvider.Info(ctx, false, "hidden")
+	testProvider.Warn(ctx, false, "shown")
+	lines := parseOutput()
+	Expect(lines).To(HaveLen(1))
+	Expect(lines[0][0].Key).To(Equal("ts"))
+	timestamp, err := time.Parse(time.RFC1123, lines[0][0].Value)
+	Expect(err).To(BeNil())
+	Expect(timestamp).To(BeTemporally("~", time.Now(), 2*time.Second))
+	Expect(lines[0][1:]).To(Equal([]Pair{{"lvl", "warn"}, {"message", "shown"}, {"fields.lvl", "mine"}}))
+
+	_, err = LogProvider(nil, Config{Level: "loud"})
+	Expect(err).To(MatchError(`not a valid log level: "loud"`))
+}
+
+func TestQuoting(t *testing.T) {
+	setup(t, Config{SortKeys: true})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		"a_plain":     "value",
+		"b_empty":     "",
+		"c_space":     "two words",
+		"d_equals":    "a=b",
+		"e_quote":     `say "hi"`,
+		"f_escapes":   "back\\slash\nnew\ttab\x01",
+		"g_null":      "null",
+		"h_nil":       nil,
+		"i_unicode":   "ünïcödé",
+		"j_invalid":   "bad\xffbyte",
+		"k bad=key\"": 1,
+		"l_error":     errors.New("it broke"),
+	})
+	testProvider.Info(ctx, false, "quoting")
+	Expect(output.String()).To(Equal(`level=info msg=quoting` +
+		` a_plain=value` +
+		` b_empty=` +
+		` c_space="two words"` +
+		` d_equals="a=b"` +
+		` e_quote="say \"hi\""` +
+		` f_escapes="back\\slash\nnew\ttab\u0001"` +
+		` g_null="null"` +
+		` h_nil=null` +
+		` i_unicode=ünïcödé` +
+		` j_invalid="bad\ufffdbyte"` +
+		` k_bad_key_=1` +
+		` l_error="it broke"` + "\n"))
+}
+
+func TestRoundTrip(t *testing.T) {
+	setup(t, Config{SortKeys: true})
+
+	values := []string{"", "plain", "two words", "a=b", `"quoted"`, "back\\slash", "new\nline", "\ttab", "\x00\x1f", "ünïcödé", "null", "-", "a\u2028b"}
+	fields := log.Fields{}
+	for i, val := range values {
+		fields[string(rune('a'+i))] = val
+	}
+	testProvider.Error(log.ContextWithFields(context.Background(), fields), false, values[3])
+
+	lines := parseOutput()
+	Expect(lines).To(HaveLen(1))
+	expected := []Pair{{"level", "error"}, {"msg", values[3]}}
+	for i, val := range values {
+		expected = append(expected, Pair{string(rune('a' + i)), val})
+	}
+	Expect(lines[0]).To(Equal(expected))
+}
+
+func TestFlattening(t *testing.T) {
+	setup(t, Config{})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		"http": map[string]interface{}{
+			"method": "GET",
+			"status": 200,
+			"headers": map[string]string{
+				"User-Agent": "curl/7.0",
+			},
+		},
+		"user": log.Fields{"id": 7},
+		"list": []int{1, 2},
+	})
+	testProvider.Info(ctx, false, "nested")
+	Expect(parseOutput()[0]).To(Equal([]Pair{
+		{"level", "info"},
+		{"msg", "nested"},
+		{"http.headers.User-Agent", "curl/7.0"},
+		{"http.method", "GET"},
+		{"http.status", "200"},
+		{"list", "[1 2]"},
+		{"user.id", "7"},
+	}))
+}
+
+func TestRecord(t *testing.T) {
+	setup(t, Config{})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"requestId": "abc", "count": 0})
+	testProvider.Record(ctx, log.Metrics{"count": 2, "bytes": 1024})
+	testProvider.RecordEvent(ctx, "Upload", log.Metrics{"bytes": 1024, "elapsed": 1500 * time.Millisecond})
+	Expect(output.String()).To(Equal(
+		`level=info msg="Reporting metrics" requestId=abc bytes=1024 count=2` + "\n" +
+			`level=info msg="Reporting metrics" count=0 requestId=abc eventName=Upload bytes=1024 elapsed=1.5s` + "\n"))
+}
+
+func TestParse(t *testing.T) {
+	RegisterTestingT(t)
+
+	pairs, err := Parse(`a=1 b="two words" c= d e="esc\"apedé\/" f=x`)
+	Expect(err).To(BeNil())
+	Expect(pairs).To(Equal([]Pair{{"a", "1"}, {"b", "two words"}, {"c", ""}, {"d", ""}, {"e", `esc"apedé/`}, {"f", "x"}}))
+
+	for line, problem := range map[string]string{
+		`=1`:         `unexpected '=' at column 1, expecting a key`,
+		`a"b=1`:      `unexpected '"' at column 2, in key`,
+		`a=b=c`:      `unexpected '=' at column 4, in unquoted value`,
+		`a="open`:    `unterminated quoted value`,
+		`a="\q"`:     `unknown escape "\\q" at column 4`,
+		`a="\u12"`:   `short \u escape at column 4`,
+		`a="\uzzzz"`: `bad \u escape at column 4`,
+		"a=\"\x01\"": `control character '\x01' in quoted value at column 4`,
+	} {
+		_, err := Parse(line)
+		Expect(err).To(MatchError("logfmt: "+problem), line)
+	}
+}
@@ -0,0 +1,32 @@
+package logfmt
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"os"
+)
+
+func init() {
+	config.Register("logfmt", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		keys := options.StringMap("keys")
+		for key := range keys {
+			switch key {
+			case "time", "level", "message":
+			default:
+				options.Errorf("option \"keys\": unknown key %q (expected time, level or message)", key)
+			}
+		}
+		return LogProvider(nextProvider, Config{
+			Output: options.Writer("output", os.Stderr),
+			Level:  options.String("level", "info"),
+			Keys: Keys{
+				Time:    keys["time"],
+				Level:   keys["level"],
+				Message: keys["message"],
+			},
+			TimeFormat: options.String("timeFormat", ""),
+			SortKeys:   options.Bool("sortKeys", false),
+		})
+	})
+}
@@ -0,0 +1,108 @@
+package logrus
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+
+	"context"
+	"sync/atomic"
+)
+
+// A logrus field which, if true, makes the Hook send a report (e.g. to Rollbar), as
+// log.ErrorReport and friends do
+const ReportField = "report"
+
+// Marks the entry.Context of entries written by the logrus provider, which the Hook skips since
+// they've already been through the chain
+type fromProviderKey struct{}
+
+// Marks the context of calls made by the Hook with the Logger it's on, which the logrus provider
+// doesn't write to again
+type fromHookKey struct{}
+
+// How many Hooks have been made; until there are any, the logrus provider needn't mark its entries
+var hookCount int32
+
+// A logrus.Hook which sends each entry to a LogProvider, so that code logging straight to logrus
+// still reaches the rest of the chain, e.g.
+//
+//	logrus.AddHook(cl_logrus.NewHook(provider))
+//
+// Fields become context fields, and an error added with WithError is passed to the provider along
+// with the message. Trace entries go to Debug, and Panic and Fatal ones go to Error, waiting for the
+// provider to finish before logrus exits. Entries written by the logrus provider are skipped, and
+// a logrus provider doesn't write entries from a Hook on its own Logger, so a chain ending in the
+// logrus provider neither loops nor writes lines twice if its Logger is the one with the Hook.
+type Hook struct {
+	provider providers.LogProvider
+}
+
+func NewHook(provider providers.LogProvider) *Hook {
+	atomic.AddInt32(&hookCount, 1)
+	return &Hook{provider}
+}
+
+func (h *Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	} else if ctx.Value(fromProviderKey{}) != nil {
+		return nil
+	}
+	ctx = context.WithValue(ctx, fromHookKey{}, entry.Logger)
+
+	report := false
+	var err error
+	fields := make(log.Fields, len(entry.Data))
+	for key, val := range entry.Data {
+		switch key {
+		case ReportField:
+			if flag, ok := val.(bool); ok {
+				report = flag
+				continue
+			}
+		case logrus.ErrorKey:
+			if e, ok := val.(error); ok {
+				err = e
+				continue
+			}
+		}
+		fields[key] = val
+	}
+	if len(fields) > 0 {
+		ctx = log.ContextWithFields(ctx, fields)
+	}
+
+	// A lone error is passed as it is, so providers such as merry can find it
+	var args []interface{}
+	switch {
+	case err == nil:
+		args = []interface{}{entry.Message}
+	case entry.Message == "":
+		args = []interface{}{err}
+	default:
+		args = []interface{}{entry.Message + ": ", err}
+	}
+
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		h.provider.Error(ctx, report, args...)
+		// logrus exits or panics once the hooks are done
+		h.provider.Wait()
+	case logrus.ErrorLevel:
+		h.provider.Error(ctx, report, args...)
+	case logrus.WarnLevel:
+		h.provider.Warn(ctx, report, args...)
+	case logrus.InfoLevel:
+		h.provider.Info(ctx, report, args...)
+	default:
+		h.provider.Debug(ctx, report, args...)
+	}
+	return nil
+}
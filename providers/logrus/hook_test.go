@@ -0,0 +1,105 @@
+package logrus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/structured"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+// Counts calls to Wait, which the structured provider doesn't record
+type waitCounter struct {
+	providers.LogProvider
+	waits int
+}
+
+func (w *waitCounter) Wait() {
+	w.waits++
+	w.LogProvider.Wait()
+}
+
+func hookedLogger(next providers.LogProvider) (*logrus.Logger, *structured.StructuredOutputLogProvider, *waitCounter) {
+	output := structured.LogProvider(next)
+	counter := &waitCounter{LogProvider: output}
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Level = logrus.TraceLevel
+	logger.AddHook(NewHook(counter))
+	return logger, output, counter
+}
+
+func TestHook(t *testing.T) {
+	RegisterTestingT(t)
+	logger, output, counter := hookedLogger(nil)
+
+	logger.WithFields(logrus.Fields{"user": "sam", ReportField: true}).Error("Broken")
+	logger.WithError(errors.New("it broke")).Warn("Upload failed")
+	logger.WithError(errors.New("alone")).Info()
+	logger.WithField(ReportField, "not a bool").Trace("Traced")
+
+	Expect(output.LogCalls()).To(Equal([]*structured.LogCallArgs{
+		{ContextFields: log.Fields{"user": "sam"}, Report: true, Args: []interface{}{"Broken"}, Level: providers.Error},
+		{ContextFields: log.Fields{}, Args: []interface{}{"Upload failed: ", errors.New("it broke")}, Level: providers.Warn},
+		{ContextFields: log.Fields{}, Args: []interface{}{errors.New("alone")}, Level: providers.Info},
+		{ContextFields: log.Fields{ReportField: "not a bool"}, Args: []interface{}{"Traced"}, Level: providers.Debug},
+	}))
+	Expect(counter.waits).To(BeZero())
+
+	Expect(func() { logger.Panic("Panicked") }).To(Panic())
+	calls := output.LogCalls()
+	Expect(calls[4].Level).To(Equal(providers.Error))
+	Expect(calls[4].Args).To(Equal([]interface{}{"Panicked"}))
+	Expect(counter.waits).To(Equal(1))
+}
+
+func TestHookContext(t *testing.T) {
+	RegisterTestingT(t)
+	logger, output, _ := hookedLogger(nil)
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"requestId": "abc"})
+	logger.WithContext(ctx).WithField("user", "sam").Info("Hello")
+	Expect(output.LogCalls()).To(HaveLen(1))
+	Expect(output.LogCalls()[0].ContextFields).To(Equal(log.Fields{"requestId": "abc", "user": "sam"}))
+}
+
+func TestHookLoop(t *testing.T) {
+	RegisterTestingT(t)
+
+	// The chain ends in a logrus provider writing to the Logger the Hook is on
+	output := new(bytes.Buffer)
+	logger := logrus.New()
+	logger.Out = output
+	logger.Formatter = &logrus.TextFormatter{DisableColors: true, DisableTimestamp: true}
+	provider, err := LogProvider(nil, Config{Logger: logger})
+	Expect(err).To(BeNil())
+	calls := structured.LogProvider(provider)
+	logger.AddHook(NewHook(calls))
+
+	logger.WithField("user", "sam").Warn("Once")
+	Expect(calls.LogCalls()).To(HaveLen(1))
+	Expect(output.String()).To(Equal("level=warning msg=Once user=sam\n"))
+
+	// Calls that didn't come from the Hook are logged as usual
+	output.Reset()
+	calls.Info(context.Background(), false, "Direct")
+	Expect(output.String()).To(Equal("level=info msg=Direct\n"))
+	Expect(calls.LogCalls()).To(HaveLen(2))
+
+	// A logrus provider with another Logger writes entries from the Hook
+	output.Reset()
+	other := new(bytes.Buffer)
+	otherProvider, err := LogProvider(nil, Config{Output: other, Level: "info", Formatter: logger.Formatter})
+	Expect(err).To(BeNil())
+	logger.ReplaceHooks(make(logrus.LevelHooks))
+	logger.AddHook(NewHook(otherProvider))
+	logger.Info("Elsewhere")
+	Expect(output.String()).To(Equal("level=info msg=Elsewhere\n"))
+	Expect(other.String()).To(Equal("level=info msg=Elsewhere\n"))
+}
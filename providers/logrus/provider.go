@@ -15,6 +15,7 @@ import (
 
 	"context"
 	"io"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,6 +28,8 @@ type Config struct {
 	Output    io.Writer
 	Level     string
 	Formatter logrus.Formatter
+	// Log to this Logger (e.g. logrus.StandardLogger()) instead, ignoring the options above
+	Logger *logrus.Logger
 }
 
 var RecommendedFormatter = &logrus.TextFormatter{
@@ -41,6 +44,11 @@ var JSONFormatter = &logrus.JSONFormatter{
 }
 
 func LogProvider(nextProvider providers.LogProvider, config Config) (l providers.LogProvider, err error) {
+	if config.Logger != nil {
+		l = provider{logrus.NewEntry(config.Logger), chaining.LogProvider(nextProvider)}
+		return
+	}
+
 	level, err := logrus.ParseLevel(config.Level)
 	if err != nil {
 		return
@@ -56,36 +64,59 @@ func LogProvider(nextProvider providers.LogProvider, config Config) (l providers
 }
 
 func (p provider) entryFor(ctx context.Context) *logrus.Entry {
-	return p.Entry.WithFields(logrus.Fields(log.FieldsFromContext(ctx)))
+	return p.marked(ctx).WithFields(logrus.Fields(log.FieldsFromContext(ctx)))
+}
+
+// An entry marked so that a Hook on the same Logger doesn't send it back through the chain; only
+// needed once there's a Hook, and saves an allocation until then
+func (p provider) marked(ctx context.Context) *logrus.Entry {
+	if atomic.LoadInt32(&hookCount) == 0 {
+		return p.Entry
+	}
+	return p.Entry.WithContext(context.WithValue(ctx, fromProviderKey{}, true))
+}
+
+// Whether the call came from a Hook on our Logger, which has already written the entry
+func (p provider) fromHook(ctx context.Context) bool {
+	logger, _ := ctx.Value(fromHookKey{}).(*logrus.Logger)
+	return logger == p.Entry.Logger
 }
 
 func (p provider) Error(ctx context.Context, report bool, args ...interface{}) {
-	p.entryFor(ctx).Error(args...)
+	if !p.fromHook(ctx) {
+		p.entryFor(ctx).Error(args...)
+	}
 	p.LogProvider.Error(ctx, report, args...)
 }
 
 func (p provider) Warn(ctx context.Context, report bool, args ...interface{}) {
-	p.entryFor(ctx).Warn(args...)
+	if !p.fromHook(ctx) {
+		p.entryFor(ctx).Warn(args...)
+	}
 	p.LogProvider.Warn(ctx, report, args...)
 }
 
 func (p provider) Info(ctx context.Context, report bool, args ...interface{}) {
-	p.entryFor(ctx).Info(args...)
+	if !p.fromHook(ctx) {
+		p.entryFor(ctx).Info(args...)
+	}
 	p.LogProvider.Info(ctx, report, args...)
 }
 
 func (p provider) Debug(ctx context.Context, report bool, args ...interface{}) {
-	p.entryFor(ctx).Debug(args...)
+	if !p.fromHook(ctx) {
+		p.entryFor(ctx).Debug(args...)
+	}
 	p.LogProvider.Debug(ctx, report, args...)
 }
 
 func (p provider) Record(ctx context.Context, metrics map[string]interface{}) {
-	p.Entry.WithFields(metrics).Info("Reporting metrics")
+	p.marked(ctx).WithFields(metrics).Info("Reporting metrics")
 	p.LogProvider.Record(ctx, metrics)
 }
 
 func (p provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
-	p.Entry.WithField("eventName", eventName).WithFields(metrics).Info("Reporting metrics")
+	p.marked(ctx).WithField("eventName", eventName).WithFields(metrics).Info("Reporting metrics")
 	p.LogProvider.RecordEvent(ctx, eventName, metrics)
 }
 
@@ -21,9 +21,9 @@ func setupJSON(t *testing.T) {
 
 	output = new(bytes.Buffer)
 	provider, err := LogProvider(nil, Config{
-		output,
-		"debug",
-		&logrus.JSONFormatter{
+		Output: output,
+		Level:  "debug",
+		Formatter: &logrus.JSONFormatter{
 			DisableTimestamp: true,
 			TimestampFormat:  "sometime", // Omit timestamp to make output predictable
 		},
@@ -0,0 +1,33 @@
+package logrus
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/logfmt"
+
+	"os"
+)
+
+var formatters = map[string]logrus.Formatter{
+	"text": RecommendedFormatter,
+	"json": JSONFormatter,
+}
+
+func init() {
+	config.Register("logrus", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		output := options.Writer("output", os.Stderr)
+		level := options.String("level", "info")
+		format := options.OneOf("format", "text", "json", "logfmt")
+		if format == "logfmt" {
+			// logrus's TextFormatter doesn't quote quite as logfmt parsers expect
+			return logfmt.LogProvider(nextProvider, logfmt.Config{Output: output, Level: level})
+		}
+		return LogProvider(nextProvider, Config{
+			Output:    output,
+			Level:     level,
+			Formatter: formatters[format],
+		})
+	})
+}
@@ -0,0 +1,135 @@
+package loki
+
+import (
+	"github.com/golang/snappy"
+
+	"github.com/myhelix/contextlogger/internal/jsonenc"
+
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// The line for an entry: a JSON object with the message, then the fields not used as labels, then
+// the metrics (which take precedence over fields of the same name). A field or metric named msg
+// is written as fields.msg, as logrus does.
+func encodeLine(message string, fields map[string]interface{}, keys []string, labelFields map[string]string, metrics map[string]interface{}) string {
+	var buf bytes.Buffer
+	buf.WriteString(`{"msg":`)
+	jsonenc.WriteValue(&buf, message)
+	for _, key := range keys {
+		if _, isLabel := labelFields[key]; isLabel {
+			continue
+		}
+		if _, isMetric := metrics[key]; isMetric {
+			continue
+		}
+		jsonenc.WritePair(&buf, lineKey(key), fields[key])
+	}
+	metricKeys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		metricKeys = append(metricKeys, key)
+	}
+	sort.Strings(metricKeys)
+	for _, key := range metricKeys {
+		jsonenc.WritePair(&buf, lineKey(key), metrics[key])
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func lineKey(key string) string {
+	if key == "msg" {
+		return "fields.msg"
+	}
+	return key
+}
+
+// The body of a push request for a batch of entries, and the headers to send with it
+func (p *provider) encode(items []interface{}) ([]byte, map[string]string, error) {
+	if p.config.Compression == CompressionSnappy {
+		return snappy.Encode(nil, encodeProto(streams(items))), map[string]string{
+			"Content-Type": "application/x-protobuf",
+		}, nil
+	}
+
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	var request struct {
+		Streams []jsonStream `json:"streams"`
+	}
+	for _, s := range streams(items) {
+		js := jsonStream{Stream: s.labels}
+		for _, e := range s.entries {
+			js.Values = append(js.Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), e.line})
+		}
+		request.Streams = append(request.Streams, js)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	if p.config.Compression == CompressionGzip {
+		var compressed bytes.Buffer
+		w := gzip.NewWriter(&compressed)
+		w.Write(body)
+		if err := w.Close(); err != nil {
+			return nil, nil, err
+		}
+		body = compressed.Bytes()
+		headers["Content-Encoding"] = "gzip"
+	}
+	return body, headers, nil
+}
+
+/*
+Loki's logproto.PushRequest:
+
+	message PushRequest { repeated StreamAdapter streams = 1; }
+	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+	message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+	message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+*/
+func encodeProto(streams []*stream) []byte {
+	var request []byte
+	for _, s := range streams {
+		streamBytes := appendField(nil, 1, []byte(s.key))
+		for _, e := range s.entries {
+			var timestamp []byte
+			if seconds := e.time.Unix(); seconds != 0 {
+				timestamp = appendVarintField(timestamp, 1, uint64(seconds))
+			}
+			if nanos := e.time.Nanosecond(); nanos != 0 {
+				timestamp = appendVarintField(timestamp, 2, uint64(nanos))
+			}
+			entryBytes := appendField(nil, 1, timestamp)
+			entryBytes = appendField(entryBytes, 2, []byte(e.line))
+			streamBytes = appendField(streamBytes, 2, entryBytes)
+		}
+		request = appendField(request, 1, streamBytes)
+	}
+	return request
+}
+
+// A length-delimited field (a string, bytes, or embedded message)
+func appendField(b []byte, field int, data []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|2)
+	b = appendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3)
+	return appendUvarint(b, v)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
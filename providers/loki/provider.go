@@ -0,0 +1,317 @@
+/*
+This package pushes log lines to Grafana Loki. Each line belongs to a stream identified by its
+labels: the configured static Labels, the level, and the values of any LabelFields found in the
+context. Other context fields go in the line itself, which is a JSON object with the message under
+"msg", e.g.
+
+	{"msg":"Upload failed","requestId":"abc","attempt":2}
+
+Keep LabelFields to a few fields with a small number of distinct values (region, not request ID),
+since Loki indexes every combination as a separate stream. Record and RecordEvent are pushed as
+"Reporting metrics" lines, with the metrics as fields.
+
+Lines are batched, and pushed every FlushInterval or when Wait is called, as snappy-compressed
+protobuf or (optionally gzipped) JSON. Pushes which fail with a 429, a 5xx or a network error are
+retried with exponential backoff.
+*/
+package loki
+
+import (
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/batching"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Protobuf, compressed with snappy; what Loki's own clients use
+	CompressionSnappy = "snappy"
+	// JSON, compressed with gzip
+	CompressionGzip = "gzip"
+	// Plain JSON
+	CompressionNone = "none"
+)
+
+type Config struct {
+	// The push API; defaults to http://localhost:3100/loki/api/v1/push
+	URL string
+	// Labels for every line, e.g. {"job": "orders"}
+	Labels map[string]string
+	// Context fields to use as labels, rather than putting them in the line; names are sanitized
+	// to be valid label names, so "http.status" becomes http_status
+	LabelFields []string
+	Level       string
+	// CompressionSnappy, CompressionGzip or CompressionNone; defaults to snappy
+	Compression string
+	// Sent as X-Scope-OrgID, for multi-tenant Loki
+	TenantID string
+	// Sent with every request, e.g. for authentication
+	Headers map[string]string
+
+	// Push once a batch reaches this many bytes of lines; defaults to 1MiB
+	MaxBatchBytes int
+	// Defaults to 1s
+	FlushInterval time.Duration
+	// How many times to retry a failed push; defaults to 5, negative for none
+	MaxRetries int
+	// Wait before the first retry, doubling each time; defaults to 500ms
+	RetryBackoff time.Duration
+	// Defaults to a client with a 10s timeout
+	HTTPClient *http.Client
+}
+
+type provider struct {
+	providers.LogProvider
+	config Config
+	level  providers.LogLevel
+	// Sanitized label names, by field
+	labelFields map[string]string
+	batcher     *batching.Batcher
+
+	// For tests
+	now func() time.Time
+}
+
+type entry struct {
+	labels map[string]string
+	time   time.Time
+	line   string
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	level, err := providers.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config.URL == "" {
+		config.URL = "http://localhost:3100/loki/api/v1/push"
+	}
+	switch config.Compression {
+	case "":
+		config.Compression = CompressionSnappy
+	case CompressionSnappy, CompressionGzip, CompressionNone:
+	default:
+		return nil, fmt.Errorf("loki: unsupported compression %q (expected snappy, gzip or none)", config.Compression)
+	}
+	if config.MaxBatchBytes <= 0 {
+		config.MaxBatchBytes = 1 << 20
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 5
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 500 * time.Millisecond
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	p := &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		config:      config,
+		level:       level,
+		labelFields: make(map[string]string),
+		now:         time.Now,
+	}
+	names := map[string]string{"level": "level"}
+	for name := range config.Labels {
+		if sanitizeLabel(name) != name {
+			return nil, fmt.Errorf("loki: %q is not a valid label name", name)
+		}
+		names[name] = name
+	}
+	for _, field := range config.LabelFields {
+		name := sanitizeLabel(field)
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("loki: label field %q would replace label %q", field, other)
+		}
+		names[name] = field
+		p.labelFields[field] = name
+	}
+	p.batcher = batching.New(batching.Config{
+		MaxBytes:      config.MaxBatchBytes,
+		FlushInterval: config.FlushInterval,
+	}, p.send)
+	return p, nil
+}
+
+// Label names must match [a-zA-Z_][a-zA-Z0-9_]*
+func sanitizeLabel(name string) string {
+	if name == "" {
+		return "_"
+	}
+	sanitized := []byte(name)
+	for i, c := range sanitized {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' && i > 0) {
+			sanitized[i] = '_'
+		}
+	}
+	return string(sanitized)
+}
+
+func (p *provider) add(ctx context.Context, level providers.LogLevel, message string, metrics map[string]interface{}) {
+	fields := log.FieldsFromContext(ctx)
+	labels := map[string]string{"level": level.String()}
+	for name, value := range p.config.Labels {
+		labels[name] = value
+	}
+	for field, name := range p.labelFields {
+		if val, ok := fields[field]; ok {
+			labels[name] = fmt.Sprint(val)
+		}
+	}
+
+	line := encodeLine(message, fields, log.OrderedKeys(ctx), p.labelFields, metrics)
+	p.batcher.Add(&entry{labels, p.now(), line}, len(line))
+}
+
+func (p *provider) log(ctx context.Context, level providers.LogLevel, args []interface{}) {
+	if level > p.level {
+		return
+	}
+	p.add(ctx, level, fmt.Sprint(args...), nil)
+}
+
+func (p *provider) record(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	if providers.Info > p.level {
+		return
+	}
+	if eventName != "" {
+		ctx = log.ContextWithFields(ctx, log.Fields{"eventName": eventName})
+	}
+	p.add(ctx, providers.Info, "Reporting metrics", metrics)
+}
+
+func (p *provider) send(items []interface{}) {
+	body, headers, err := p.encode(items)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode Loki push request, %v\n", err)
+		return
+	}
+	err = batching.Retry(p.config.MaxRetries, p.config.RetryBackoff, func() (time.Duration, error) {
+		return p.post(body, headers)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to push %d lines to Loki, %v\n", len(items), err)
+	}
+}
+
+// Make one push request, for batching.Retry
+func (p *provider) post(body []byte, headers map[string]string) (time.Duration, error) {
+	req, err := http.NewRequest("POST", p.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if p.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", p.config.TenantID)
+	}
+	for key, value := range p.config.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		// Drain, so the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		return 0, nil
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return batching.RetryDelay(resp), fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+}
+
+// Group entries into streams by their labels, keeping their order within each stream
+func streams(items []interface{}) []*stream {
+	var result []*stream
+	byKey := make(map[string]*stream)
+	for _, item := range items {
+		e := item.(*entry)
+		key := labelString(e.labels)
+		s, ok := byKey[key]
+		if !ok {
+			s = &stream{labels: e.labels, key: key}
+			byKey[key] = s
+			result = append(result, s)
+		}
+		s.entries = append(s.entries, e)
+	}
+	return result
+}
+
+type stream struct {
+	labels map[string]string
+	// In Loki's selector syntax, e.g. {job="orders",level="info"}
+	key     string
+	entries []*entry
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(name + `="` + labelEscaper.Replace(labels[name]) + `"`)
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func (p *provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Error, args)
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Warn, args)
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Info, args)
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Debug, args)
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName, metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
+
+func (p *provider) Wait() {
+	p.batcher.Flush()
+	p.LogProvider.Wait()
+}
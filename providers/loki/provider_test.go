@@ -0,0 +1,282 @@
+package loki
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	. "github.com/onsi/gomega"
+)
+
+var testProvider providers.LogProvider
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// A stand-in Loki, which records pushes and responds with the given statuses in turn (then 204s)
+type receiver struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []request
+	statuses []int
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		Expect(req.URL.Path).To(Equal("/loki/api/v1/push"))
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.requests = append(r.requests, request{req.Header, body})
+		status := http.StatusNoContent
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []request {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]request(nil), r.requests...)
+}
+
+var testTime = time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+func setup(t *testing.T, r *receiver, config Config) {
+	RegisterTestingT(t)
+
+	if config.Level == "" {
+		config.Level = "info"
+	}
+	config.URL = r.URL + "/loki/api/v1/push"
+	p, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	p.(*provider).now = func() time.Time { return testTime }
+	testProvider = p
+}
+
+type pushRequest struct {
+	Streams []struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	} `json:"streams"`
+}
+
+func decodeJSON(body []byte) pushRequest {
+	var decoded pushRequest
+	Expect(json.Unmarshal(body, &decoded)).To(Succeed())
+	return decoded
+}
+
+func TestStreams(t *testing.T) {
+	r := newReceiver(t)
+	setup(t, r, Config{
+		Compression: CompressionNone,
+		Labels:      map[string]string{"job": "orders"},
+		LabelFields: []string{"http.route"},
+		TenantID:    "acme",
+		Headers:     map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+	})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"http.route": "/orders"})
+	ctx = log.ContextWithFields(ctx, log.Fields{"requestId": "abc"})
+	ctx = log.ContextWithFields(ctx, log.Fields{"attempt": 2})
+	testProvider.Error(ctx, false, "Upload ", errors.New("failed"))
+	testProvider.Debug(ctx, false, "Not sent")
+	testProvider.Info(context.Background(), false, "Starting")
+	testProvider.Error(context.Background(), false, "Stopping")
+	testProvider.RecordEvent(ctx, "upload", log.Metrics{"bytes": 1024, "attempt": 3})
+	testProvider.Wait()
+
+	requests := r.received()
+	Expect(requests).To(HaveLen(1))
+	Expect(requests[0].header.Get("Content-Type")).To(Equal("application/json"))
+	Expect(requests[0].header.Get("X-Scope-OrgID")).To(Equal("acme"))
+	Expect(requests[0].header.Get("Authorization")).To(Equal("Basic dXNlcjpwYXNz"))
+	Expect(string(requests[0].body)).To(MatchJSON(`{"streams": [
+		{
+			"stream": {"job": "orders", "level": "error", "http_route": "/orders"},
+			"values": [["1577934245000000006", "{\"msg\":\"Upload failed\",\"requestId\":\"abc\",\"attempt\":2}"]]
+		},
+		{
+			"stream": {"job": "orders", "level": "info"},
+			"values": [["1577934245000000006", "{\"msg\":\"Starting\"}"]]
+		},
+		{
+			"stream": {"job": "orders", "level": "error"},
+			"values": [["1577934245000000006", "{\"msg\":\"Stopping\"}"]]
+		},
+		{
+			"stream": {"job": "orders", "level": "info", "http_route": "/orders"},
+			"values": [["1577934245000000006", "{\"msg\":\"Reporting metrics\",\"requestId\":\"abc\",\"eventName\":\"upload\",\"attempt\":3,\"bytes\":1024}"]]
+		}
+	]}`))
+}
+
+func TestGzip(t *testing.T) {
+	r := newReceiver(t)
+	setup(t, r, Config{Compression: CompressionGzip})
+
+	testProvider.Info(context.Background(), false, "Compressed")
+	testProvider.Wait()
+
+	requests := r.received()
+	Expect(requests).To(HaveLen(1))
+	Expect(requests[0].header.Get("Content-Encoding")).To(Equal("gzip"))
+	gz, err := gzip.NewReader(bytes.NewReader(requests[0].body))
+	Expect(err).To(BeNil())
+	body, err := ioutil.ReadAll(gz)
+	Expect(err).To(BeNil())
+	Expect(decodeJSON(body).Streams[0].Values).To(Equal([][2]string{{"1577934245000000006", `{"msg":"Compressed"}`}}))
+}
+
+// Decode a protobuf message into its length-delimited and varint fields, by number
+func decodeProto(b []byte) map[int][]interface{} {
+	fields := make(map[int][]interface{})
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		b = b[n:]
+		value, n := binary.Uvarint(b)
+		b = b[n:]
+		if tag&7 == 2 {
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], b[:value])
+			b = b[value:]
+		} else {
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], value)
+		}
+	}
+	return fields
+}
+
+func TestSnappyProtobuf(t *testing.T) {
+	r := newReceiver(t)
+	setup(t, r, Config{Labels: map[string]string{"job": `say "hi"`}})
+
+	testProvider.Warn(context.Background(), false, "First")
+	testProvider.Warn(context.Background(), false, "Second")
+	testProvider.Wait()
+
+	requests := r.received()
+	Expect(requests).To(HaveLen(1))
+	Expect(requests[0].header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+	body, err := snappy.Decode(nil, requests[0].body)
+	Expect(err).To(BeNil())
+
+	streams := decodeProto(body)[1]
+	Expect(streams).To(HaveLen(1))
+	stream := decodeProto(streams[0].([]byte))
+	Expect(string(stream[1][0].([]byte))).To(Equal(`{job="say \"hi\"",level="warn"}`))
+	Expect(stream[2]).To(HaveLen(2))
+	entry := decodeProto(stream[2][1].([]byte))
+	Expect(string(entry[2][0].([]byte))).To(Equal(`{"msg":"Second"}`))
+	timestamp := decodeProto(entry[1][0].([]byte))
+	Expect(timestamp[1]).To(Equal([]interface{}{uint64(testTime.Unix())}))
+	Expect(timestamp[2]).To(Equal([]interface{}{uint64(6)}))
+}
+
+func TestRetry(t *testing.T) {
+	r := newReceiver(t, http.StatusTooManyRequests, http.StatusInternalServerError)
+	setup(t, r, Config{Compression: CompressionNone, RetryBackoff: time.Millisecond})
+	testProvider.Info(context.Background(), false, "Eventually")
+	testProvider.Wait()
+	requests := r.received()
+	Expect(requests).To(HaveLen(3))
+	Expect(requests[2].body).To(Equal(requests[0].body))
+
+	// Not retried, since it would be rejected again
+	r = newReceiver(t, http.StatusBadRequest)
+	setup(t, r, Config{Compression: CompressionNone, RetryBackoff: time.Millisecond})
+	testProvider.Info(context.Background(), false, "Too old")
+	testProvider.Wait()
+	Expect(r.received()).To(HaveLen(1))
+}
+
+func TestBatching(t *testing.T) {
+	r := newReceiver(t)
+	setup(t, r, Config{Compression: CompressionNone, MaxBatchBytes: 40, FlushInterval: time.Hour})
+
+	for _, message := range []string{"one", "two", "three"} {
+		// Each line is about 15 bytes
+		testProvider.Info(context.Background(), false, message)
+	}
+	Eventually(func() []request { return r.received() }).Should(HaveLen(1))
+	Expect(decodeJSON(r.received()[0].body).Streams[0].Values).To(HaveLen(2))
+	testProvider.Wait()
+	Expect(r.received()).To(HaveLen(2))
+}
+
+func TestLabels(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(sanitizeLabel("http.status")).To(Equal("http_status"))
+	Expect(sanitizeLabel("9lives")).To(Equal("_lives"))
+	Expect(sanitizeLabel("ok_Label9")).To(Equal("ok_Label9"))
+
+	_, err := LogProvider(nil, Config{Level: "info", LabelFields: []string{"a.b", "a_b"}})
+	Expect(err).To(MatchError(`loki: label field "a_b" would replace label "a.b"`))
+	_, err = LogProvider(nil, Config{Level: "info", LabelFields: []string{"level"}})
+	Expect(err).To(MatchError(`loki: label field "level" would replace label "level"`))
+	_, err = LogProvider(nil, Config{Level: "info", Labels: map[string]string{"app-name": "x"}})
+	Expect(err).To(MatchError(`loki: "app-name" is not a valid label name`))
+	_, err = LogProvider(nil, Config{Level: "info", Compression: "zstd"})
+	Expect(err).To(MatchError(`loki: unsupported compression "zstd" (expected snappy, gzip or none)`))
+}
+
+func TestMsgField(t *testing.T) {
+	RegisterTestingT(t)
+
+	line := encodeLine("Sent", map[string]interface{}{"msg": "hello", "to": "sam"}, []string{"msg", "to"}, nil, nil)
+	Expect(line).To(Equal(`{"msg":"Sent","fields.msg":"hello","to":"sam"}`))
+	line = encodeLine("Reporting metrics", nil, nil, nil, map[string]interface{}{"msg": 3})
+	Expect(line).To(Equal(`{"msg":"Reporting metrics","fields.msg":3}`))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+	r := newReceiver(t)
+
+	provider, err := config.Build([]byte(`
+providers:
+  - name: loki
+    options:
+      url: ` + r.URL + `/loki/api/v1/push
+      compression: none
+      labels: {job: configured}
+      labelFields: [region]
+      level: warn
+`))
+	Expect(err).To(BeNil())
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"region": "eu"})
+	provider.Info(ctx, false, "Filtered")
+	provider.Warn(ctx, false, "Sent")
+	provider.Wait()
+	requests := r.received()
+	Expect(requests).To(HaveLen(1))
+	Expect(string(requests[0].body)).To(MatchJSON(`{"streams": [{
+		"stream": {"job": "configured", "level": "warn", "region": "eu"},
+		"values": [["` + decodeJSON(requests[0].body).Streams[0].Values[0][0] + `", "{\"msg\":\"Sent\"}"]]
+	}]}`))
+
+	_, err = config.Build([]byte(`{"providers": [{"name": "loki", "options": {"compression": "zstd"}}]}`))
+	Expect(err).To(MatchError(`config: providers[0] (loki): option "compression" must be one of snappy|gzip|none, not "zstd"`))
+}
@@ -0,0 +1,28 @@
+package loki
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+)
+
+func init() {
+	config.Register("loki", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := Config{
+			URL:           options.String("url", ""),
+			Labels:        options.StringMap("labels"),
+			LabelFields:   options.Strings("labelFields", nil),
+			Level:         options.String("level", "info"),
+			Compression:   options.OneOf("compression", CompressionSnappy, CompressionGzip, CompressionNone),
+			TenantID:      options.String("tenantId", ""),
+			Headers:       options.StringMap("headers"),
+			MaxBatchBytes: options.Int("maxBatchBytes", 0),
+			FlushInterval: options.Duration("flushInterval", 0),
+			MaxRetries:    options.Int("maxRetries", 0),
+			RetryBackoff:  options.Duration("retryBackoff", 0),
+		}
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+		return LogProvider(nextProvider, providerConfig)
+	})
+}
@@ -24,9 +24,9 @@ func setup(t *testing.T) {
 
 	output = new(bytes.Buffer)
 	outputProvider, err := cl_logrus.LogProvider(nil, cl_logrus.Config{
-		output,
-		"debug",
-		&logrus.TextFormatter{
+		Output: output,
+		Level:  "debug",
+		Formatter: &logrus.TextFormatter{
 			DisableColors:   true,
 			TimestampFormat: "sometime", // Omit timestamp to make output predictable
 		},
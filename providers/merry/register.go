@@ -0,0 +1,12 @@
+package merry
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+)
+
+func init() {
+	config.Register("merry", config.Enricher, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		return LogProvider(nextProvider), nil
+	})
+}
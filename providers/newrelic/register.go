@@ -0,0 +1,23 @@
+package newrelic
+
+import (
+	"github.com/newrelic/go-agent"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+)
+
+func init() {
+	config.Register("newrelic", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		appName := options.RequiredString("appName")
+		licenseKey := options.RequiredString("licenseKey")
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+		app, err := newrelic.NewApplication(newrelic.NewConfig(appName, licenseKey))
+		if err != nil {
+			return nil, err
+		}
+		return LogProvider(nextProvider, app)
+	})
+}
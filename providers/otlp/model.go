@@ -0,0 +1,398 @@
+package otlp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/*
+The subset of the OTLP data model we send, from opentelemetry-proto's logs/v1, metrics/v1 and
+common/v1. Each type can encode itself as protobuf (appendProto, with the field numbers from the
+.proto files) and as OTLP's flavour of JSON (camelCase names, 64-bit integers as strings, and IDs
+in hex).
+*/
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+func (kv *keyValue) appendProto(b []byte) []byte {
+	b = appendString(b, 1, kv.Key)
+	return appendMessage(b, 2, kv.Value.appendProto)
+}
+
+type valueKind int
+
+const (
+	stringValue valueKind = iota
+	boolValue
+	intValue
+	doubleValue
+	arrayValue
+	kvlistValue
+	bytesValue
+)
+
+// A oneof, so exactly one of the fields (according to kind) is set
+type anyValue struct {
+	kind   valueKind
+	str    string
+	b      bool
+	i      int64
+	f      float64
+	array  []anyValue
+	kvlist []keyValue
+	bytes  []byte
+}
+
+func (v *anyValue) appendProto(b []byte) []byte {
+	// Oneof fields are written even when they hold the zero value, to say which one is set
+	switch v.kind {
+	case stringValue:
+		b = appendTag(b, 1, wireBytes)
+		b = appendVarint(b, uint64(len(v.str)))
+		return append(b, v.str...)
+	case boolValue:
+		b = appendTag(b, 2, wireVarint)
+		if v.b {
+			return appendVarint(b, 1)
+		}
+		return appendVarint(b, 0)
+	case intValue:
+		b = appendTag(b, 3, wireVarint)
+		return appendVarint(b, uint64(v.i))
+	case doubleValue:
+		b = appendTag(b, 4, wireFixed64)
+		return appendFixed64(b, math.Float64bits(v.f))
+	case arrayValue:
+		return appendMessage(b, 5, func(b []byte) []byte {
+			for i := range v.array {
+				b = appendMessage(b, 1, v.array[i].appendProto)
+			}
+			return b
+		})
+	case kvlistValue:
+		return appendMessage(b, 6, func(b []byte) []byte {
+			for i := range v.kvlist {
+				b = appendMessage(b, 1, v.kvlist[i].appendProto)
+			}
+			return b
+		})
+	default:
+		b = appendTag(b, 7, wireBytes)
+		b = appendVarint(b, uint64(len(v.bytes)))
+		return append(b, v.bytes...)
+	}
+}
+
+func (v anyValue) MarshalJSON() ([]byte, error) {
+	switch v.kind {
+	case stringValue:
+		return json.Marshal(map[string]string{"stringValue": v.str})
+	case boolValue:
+		return json.Marshal(map[string]bool{"boolValue": v.b})
+	case intValue:
+		return json.Marshal(map[string]string{"intValue": strconv.FormatInt(v.i, 10)})
+	case doubleValue:
+		if math.IsNaN(v.f) || math.IsInf(v.f, 0) {
+			// Not representable as a JSON number; proto3 JSON uses these strings
+			special := "NaN"
+			if math.IsInf(v.f, 1) {
+				special = "Infinity"
+			} else if math.IsInf(v.f, -1) {
+				special = "-Infinity"
+			}
+			return json.Marshal(map[string]string{"doubleValue": special})
+		}
+		return json.Marshal(map[string]float64{"doubleValue": v.f})
+	case arrayValue:
+		return json.Marshal(map[string]interface{}{"arrayValue": map[string]interface{}{"values": nonNil(v.array)}})
+	case kvlistValue:
+		return json.Marshal(map[string]interface{}{"kvlistValue": map[string]interface{}{"values": nonNilKVs(v.kvlist)}})
+	default:
+		return json.Marshal(map[string][]byte{"bytesValue": v.bytes})
+	}
+}
+
+// So empty lists are [] rather than null
+func nonNil(values []anyValue) []anyValue {
+	if values == nil {
+		return []anyValue{}
+	}
+	return values
+}
+
+func nonNilKVs(values []keyValue) []keyValue {
+	if values == nil {
+		return []keyValue{}
+	}
+	return values
+}
+
+// Convert a field or metric value, using nested values for slices and string-keyed maps
+func toAnyValue(val interface{}) anyValue {
+	switch v := val.(type) {
+	case nil:
+		return anyValue{kind: stringValue}
+	case string:
+		return anyValue{kind: stringValue, str: v}
+	case bool:
+		return anyValue{kind: boolValue, b: v}
+	case int:
+		return anyValue{kind: intValue, i: int64(v)}
+	case int8:
+		return anyValue{kind: intValue, i: int64(v)}
+	case int16:
+		return anyValue{kind: intValue, i: int64(v)}
+	case int32:
+		return anyValue{kind: intValue, i: int64(v)}
+	case int64:
+		return anyValue{kind: intValue, i: v}
+	case uint8:
+		return anyValue{kind: intValue, i: int64(v)}
+	case uint16:
+		return anyValue{kind: intValue, i: int64(v)}
+	case uint32:
+		return anyValue{kind: intValue, i: int64(v)}
+	case uint:
+		if uint64(v) <= math.MaxInt64 {
+			return anyValue{kind: intValue, i: int64(v)}
+		}
+		return anyValue{kind: doubleValue, f: float64(v)}
+	case uint64:
+		if v <= math.MaxInt64 {
+			return anyValue{kind: intValue, i: int64(v)}
+		}
+		return anyValue{kind: doubleValue, f: float64(v)}
+	case float32:
+		return anyValue{kind: doubleValue, f: float64(v)}
+	case float64:
+		return anyValue{kind: doubleValue, f: v}
+	case []byte:
+		return anyValue{kind: bytesValue, bytes: v}
+	case time.Time:
+		return anyValue{kind: stringValue, str: v.Format(time.RFC3339Nano)}
+	case error:
+		return anyValue{kind: stringValue, str: v.Error()}
+	case fmt.Stringer:
+		return anyValue{kind: stringValue, str: v.String()}
+	}
+
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		values := make([]anyValue, rv.Len())
+		for i := range values {
+			values[i] = toAnyValue(rv.Index(i).Interface())
+		}
+		return anyValue{kind: arrayValue, array: values}
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			keys := rv.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+			kvs := make([]keyValue, len(keys))
+			for i, k := range keys {
+				kvs[i] = keyValue{k.String(), toAnyValue(rv.MapIndex(k).Interface())}
+			}
+			return anyValue{kind: kvlistValue, kvlist: kvs}
+		}
+	}
+	return anyValue{kind: stringValue, str: fmt.Sprintf("%+v", val)}
+}
+
+func attributes(fields map[string]interface{}, keys []string) []keyValue {
+	kvs := make([]keyValue, 0, len(fields))
+	for _, key := range keys {
+		kvs = append(kvs, keyValue{key, toAnyValue(fields[key])})
+	}
+	return kvs
+}
+
+func appendAttributes(b []byte, field int, kvs []keyValue) []byte {
+	for i := range kvs {
+		b = appendMessage(b, field, kvs[i].appendProto)
+	}
+	return b
+}
+
+// IDs are hex in OTLP JSON, rather than the base64 encoding/json would use
+type hexBytes []byte
+
+func (h hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+func (r *resource) appendProto(b []byte) []byte {
+	return appendAttributes(b, 1, r.Attributes)
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+func (s *scope) appendProto(b []byte) []byte {
+	b = appendString(b, 1, s.Name)
+	return appendString(b, 2, s.Version)
+}
+
+// Logs
+
+type logsRequest struct {
+	ResourceLogs []resourceLogs `json:"resourceLogs"`
+}
+
+func (r *logsRequest) appendProto(b []byte) []byte {
+	for i := range r.ResourceLogs {
+		b = appendMessage(b, 1, r.ResourceLogs[i].appendProto)
+	}
+	return b
+}
+
+type resourceLogs struct {
+	Resource  resource    `json:"resource"`
+	ScopeLogs []scopeLogs `json:"scopeLogs"`
+}
+
+func (r *resourceLogs) appendProto(b []byte) []byte {
+	b = appendMessage(b, 1, r.Resource.appendProto)
+	for i := range r.ScopeLogs {
+		b = appendMessage(b, 2, r.ScopeLogs[i].appendProto)
+	}
+	return b
+}
+
+type scopeLogs struct {
+	Scope      scope        `json:"scope"`
+	LogRecords []*logRecord `json:"logRecords"`
+}
+
+func (s *scopeLogs) appendProto(b []byte) []byte {
+	b = appendMessage(b, 1, s.Scope.appendProto)
+	for _, record := range s.LogRecords {
+		b = appendMessage(b, 2, record.appendProto)
+	}
+	return b
+}
+
+type logRecord struct {
+	TimeUnixNano         uint64     `json:"timeUnixNano,string"`
+	ObservedTimeUnixNano uint64     `json:"observedTimeUnixNano,string"`
+	SeverityNumber       int        `json:"severityNumber"`
+	SeverityText         string     `json:"severityText"`
+	Body                 anyValue   `json:"body"`
+	Attributes           []keyValue `json:"attributes,omitempty"`
+	Flags                uint32     `json:"flags,omitempty"`
+	TraceID              hexBytes   `json:"traceId,omitempty"`
+	SpanID               hexBytes   `json:"spanId,omitempty"`
+}
+
+func (r *logRecord) appendProto(b []byte) []byte {
+	b = appendFixed64Field(b, 1, r.TimeUnixNano)
+	b = appendVarintField(b, 2, uint64(r.SeverityNumber))
+	b = appendString(b, 3, r.SeverityText)
+	b = appendMessage(b, 5, r.Body.appendProto)
+	b = appendAttributes(b, 6, r.Attributes)
+	if r.Flags != 0 {
+		b = appendTag(b, 8, wireFixed32)
+		b = appendFixed32(b, r.Flags)
+	}
+	b = appendBytes(b, 9, r.TraceID)
+	b = appendBytes(b, 10, r.SpanID)
+	return appendFixed64Field(b, 11, r.ObservedTimeUnixNano)
+}
+
+// Metrics
+
+type metricsRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+func (r *metricsRequest) appendProto(b []byte) []byte {
+	for i := range r.ResourceMetrics {
+		b = appendMessage(b, 1, r.ResourceMetrics[i].appendProto)
+	}
+	return b
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+func (r *resourceMetrics) appendProto(b []byte) []byte {
+	b = appendMessage(b, 1, r.Resource.appendProto)
+	for i := range r.ScopeMetrics {
+		b = appendMessage(b, 2, r.ScopeMetrics[i].appendProto)
+	}
+	return b
+}
+
+type scopeMetrics struct {
+	Scope   scope     `json:"scope"`
+	Metrics []*metric `json:"metrics"`
+}
+
+func (s *scopeMetrics) appendProto(b []byte) []byte {
+	b = appendMessage(b, 1, s.Scope.appendProto)
+	for _, m := range s.Metrics {
+		b = appendMessage(b, 2, m.appendProto)
+	}
+	return b
+}
+
+// Only gauges, since Record gives us a value at a point in time, with nothing to say whether it's
+// cumulative
+type metric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit,omitempty"`
+	Gauge gauge  `json:"gauge"`
+}
+
+func (m *metric) appendProto(b []byte) []byte {
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 3, m.Unit)
+	return appendMessage(b, 5, m.Gauge.appendProto)
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+func (g *gauge) appendProto(b []byte) []byte {
+	for i := range g.DataPoints {
+		b = appendMessage(b, 1, g.DataPoints[i].appendProto)
+	}
+	return b
+}
+
+type dataPoint struct {
+	Attributes   []keyValue `json:"attributes,omitempty"`
+	TimeUnixNano uint64     `json:"timeUnixNano,string"`
+	// One of these is set
+	AsDouble *float64 `json:"asDouble,omitempty"`
+	AsInt    *int64   `json:"asInt,string,omitempty"`
+}
+
+func (d *dataPoint) appendProto(b []byte) []byte {
+	b = appendFixed64Field(b, 3, d.TimeUnixNano)
+	if d.AsDouble != nil {
+		b = appendTag(b, 4, wireFixed64)
+		b = appendFixed64(b, math.Float64bits(*d.AsDouble))
+	} else if d.AsInt != nil {
+		b = appendTag(b, 6, wireFixed64)
+		b = appendFixed64(b, uint64(*d.AsInt))
+	}
+	return appendAttributes(b, 7, d.Attributes)
+}
@@ -0,0 +1,89 @@
+package otlp
+
+import (
+	"encoding/binary"
+)
+
+// Just enough of the protobuf wire format to encode the OTLP messages in model.go
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return appendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendFixed32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// The field helpers below leave out zero values, as proto3 does
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendVarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendFixed64Field(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendFixed64(appendTag(b, field, wireFixed64), v)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendVarint(appendTag(b, field, wireBytes), uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, field int, bytes []byte) []byte {
+	if len(bytes) == 0 {
+		return b
+	}
+	b = appendVarint(appendTag(b, field, wireBytes), uint64(len(bytes)))
+	return append(b, bytes...)
+}
+
+// Append an embedded message, which is always written (even if empty) since presence matters
+func appendMessage(b []byte, field int, encode func([]byte) []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	// Encode after a one byte length, which is enough for small messages; bigger ones are moved
+	// along to make room for their length
+	start := len(b)
+	b = encode(append(b, 0))
+	length := len(b) - start - 1
+	if length < 0x80 {
+		b[start] = byte(length)
+		return b
+	}
+	lengthBytes := appendVarint(nil, uint64(length))
+	b = append(b, lengthBytes[1:]...)
+	copy(b[start+len(lengthBytes):], b[start+1:start+1+length])
+	copy(b[start:], lengthBytes)
+	return b
+}
@@ -0,0 +1,324 @@
+/*
+This package exports to an OpenTelemetry collector (or anything else accepting OTLP/HTTP), with
+either protobuf or JSON encoding. Log calls become log records, with context fields as attributes,
+and the trace and span from log.ContextWithSpanContext if there is one. Numeric metrics from Record
+become gauges, with context fields as attributes, and RecordEvent becomes a log record with an
+event.name attribute and the metrics as attributes too.
+
+Records and metrics are batched, and sent every FlushInterval or when Wait is called; requests that
+fail with a 429, a 5xx or a network error are retried with exponential backoff, honouring
+Retry-After.
+*/
+package otlp
+
+import (
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/batching"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ProtocolProtobuf = "protobuf"
+	ProtocolJSON     = "json"
+)
+
+// Recorded as the instrumentation scope
+const scopeName = "github.com/myhelix/contextlogger"
+
+type Config struct {
+	// Base URL of the collector; /v1/logs and /v1/metrics are appended. Defaults to
+	// http://localhost:4318
+	Endpoint string
+	// ProtocolProtobuf or ProtocolJSON; defaults to protobuf
+	Protocol string
+	// Sent with every request, e.g. for authentication
+	Headers map[string]string
+	// The service.name resource attribute; defaults to the program name
+	ServiceName string
+	// Other resource attributes, e.g. "deployment.environment"
+	ResourceAttributes map[string]interface{}
+
+	Level string
+	// Send a batch once it has this many records and metrics; defaults to 512
+	MaxBatchSize int
+	// Defaults to 1s
+	FlushInterval time.Duration
+	// How many times to retry a failed request; defaults to 5, negative for none
+	MaxRetries int
+	// Wait before the first retry, doubling each time; defaults to 1s
+	RetryBackoff time.Duration
+	// Defaults to a client with a 10s timeout
+	HTTPClient *http.Client
+}
+
+type provider struct {
+	providers.LogProvider
+	config      Config
+	level       providers.LogLevel
+	contentType string
+	resource    resource
+	batcher     *batching.Batcher
+
+	// For tests
+	now func() time.Time
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	level, err := providers.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "http://localhost:4318"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	var contentType string
+	switch config.Protocol {
+	case "", ProtocolProtobuf:
+		config.Protocol = ProtocolProtobuf
+		contentType = "application/x-protobuf"
+	case ProtocolJSON:
+		contentType = "application/json"
+	default:
+		return nil, fmt.Errorf("otlp: unsupported protocol %q (expected protobuf or json)", config.Protocol)
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = filepath.Base(os.Args[0])
+	}
+	if config.MaxBatchSize <= 0 {
+		config.MaxBatchSize = 512
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 5
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = time.Second
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	p := &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		config:      config,
+		level:       level,
+		contentType: contentType,
+		now:         time.Now,
+	}
+	p.resource.Attributes = append(p.resource.Attributes, keyValue{"service.name", toAnyValue(config.ServiceName)})
+	keys := make([]string, 0, len(config.ResourceAttributes))
+	for key := range config.ResourceAttributes {
+		if key != "service.name" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	p.resource.Attributes = append(p.resource.Attributes, attributes(config.ResourceAttributes, keys)...)
+	p.batcher = batching.New(batching.Config{
+		MaxItems:      config.MaxBatchSize,
+		FlushInterval: config.FlushInterval,
+	}, p.send)
+	return p, nil
+}
+
+// OTLP severity numbers are in ranges of four per level; these are the first of each
+var severities = map[providers.LogLevel]int{
+	providers.Debug: 5,
+	providers.Info:  9,
+	providers.Warn:  13,
+	providers.Error: 17,
+}
+
+func (p *provider) logRecord(ctx context.Context, level providers.LogLevel, body string, extra map[string]interface{}) *logRecord {
+	now := uint64(p.now().UnixNano())
+	record := &logRecord{
+		TimeUnixNano:         now,
+		ObservedTimeUnixNano: now,
+		SeverityNumber:       severities[level],
+		SeverityText:         strings.ToUpper(level.String()),
+		Body:                 anyValue{kind: stringValue, str: body},
+		Attributes:           attributes(log.FieldsFromContext(ctx), log.OrderedKeys(ctx)),
+	}
+	if len(extra) > 0 {
+		keys := make([]string, 0, len(extra))
+		for key := range extra {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		record.Attributes = append(record.Attributes, attributes(extra, keys)...)
+	}
+	if span, ok := log.SpanContextFromContext(ctx); ok {
+		record.TraceID = span.TraceID[:]
+		record.SpanID = span.SpanID[:]
+		record.Flags = uint32(span.TraceFlags)
+	}
+	return record
+}
+
+func (p *provider) log(ctx context.Context, level providers.LogLevel, args []interface{}) {
+	if level > p.level {
+		return
+	}
+	p.batcher.Add(p.logRecord(ctx, level, fmt.Sprint(args...), nil), 1)
+}
+
+func (p *provider) record(ctx context.Context, metrics map[string]interface{}) {
+	now := uint64(p.now().UnixNano())
+	attrs := attributes(log.FieldsFromContext(ctx), log.OrderedKeys(ctx))
+	keys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		point := dataPoint{Attributes: attrs, TimeUnixNano: now}
+		m := &metric{Name: key}
+		switch value := toAnyValue(metrics[key]); {
+		case isDuration(metrics[key]):
+			seconds := metrics[key].(time.Duration).Seconds()
+			point.AsDouble = &seconds
+			m.Unit = "s"
+		case value.kind == intValue:
+			point.AsInt = &value.i
+		case value.kind == doubleValue:
+			point.AsDouble = &value.f
+		default:
+			// Not a number, so not a metric
+			continue
+		}
+		m.Gauge.DataPoints = []dataPoint{point}
+		p.batcher.Add(m, 1)
+	}
+}
+
+func isDuration(val interface{}) bool {
+	_, ok := val.(time.Duration)
+	return ok
+}
+
+func (p *provider) send(items []interface{}) {
+	var records []*logRecord
+	var metrics []*metric
+	for _, item := range items {
+		switch v := item.(type) {
+		case *logRecord:
+			records = append(records, v)
+		case *metric:
+			metrics = append(metrics, v)
+		}
+	}
+	scope := scope{Name: scopeName}
+	if len(records) > 0 {
+		p.export("/v1/logs", &logsRequest{ResourceLogs: []resourceLogs{{
+			Resource:  p.resource,
+			ScopeLogs: []scopeLogs{{Scope: scope, LogRecords: records}},
+		}}})
+	}
+	if len(metrics) > 0 {
+		p.export("/v1/metrics", &metricsRequest{ResourceMetrics: []resourceMetrics{{
+			Resource:     p.resource,
+			ScopeMetrics: []scopeMetrics{{Scope: scope, Metrics: metrics}},
+		}}})
+	}
+}
+
+type message interface {
+	appendProto(b []byte) []byte
+}
+
+func (p *provider) export(path string, request message) {
+	var body []byte
+	if p.config.Protocol == ProtocolJSON {
+		var err error
+		if body, err = json.Marshal(request); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode OTLP export request, %v\n", err)
+			return
+		}
+	} else {
+		body = request.appendProto(nil)
+	}
+
+	err := batching.Retry(p.config.MaxRetries, p.config.RetryBackoff, func() (time.Duration, error) {
+		return p.post(path, body)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export to OTLP %s, %v\n", path, err)
+	}
+}
+
+// Make one export request, for batching.Retry
+func (p *provider) post(path string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequest("POST", p.config.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", p.contentType)
+	for key, value := range p.config.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		// Drain, so the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		return 0, nil
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return batching.RetryDelay(resp), fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+}
+
+func (p *provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Error, args)
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Warn, args)
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Info, args)
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Debug, args)
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	if providers.Info <= p.level {
+		record := p.logRecord(ctx, providers.Info, eventName, metrics)
+		record.Attributes = append(record.Attributes, keyValue{"event.name", toAnyValue(eventName)})
+		p.batcher.Add(record, 1)
+	}
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
+
+func (p *provider) Wait() {
+	p.batcher.Flush()
+	p.LogProvider.Wait()
+}
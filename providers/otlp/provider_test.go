@@ -0,0 +1,335 @@
+package otlp
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	. "github.com/onsi/gomega"
+)
+
+var testProvider providers.LogProvider
+
+type request struct {
+	path        string
+	contentType string
+	header      http.Header
+	body        []byte
+}
+
+// A stand-in collector, which records requests and responds with the given statuses in turn (then
+// 200s)
+type receiver struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []request
+	statuses []int
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.requests = append(r.requests, request{req.URL.Path, req.Header.Get("Content-Type"), req.Header, body})
+		if len(r.statuses) > 0 {
+			status := r.statuses[0]
+			r.statuses = r.statuses[1:]
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(status)
+		}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []request {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]request(nil), r.requests...)
+}
+
+var testTime = time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+func setup(t *testing.T, config Config) {
+	RegisterTestingT(t)
+
+	if config.Level == "" {
+		config.Level = "info"
+	}
+	config.ServiceName = "test-service"
+	p, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	p.(*provider).now = func() time.Time { return testTime }
+	testProvider = p
+}
+
+func decodeJSON(body []byte) map[string]interface{} {
+	var decoded map[string]interface{}
+	Expect(json.Unmarshal(body, &decoded)).To(Succeed())
+	return decoded
+}
+
+func testContext() context.Context {
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"requestId": "abc", "attempt": 2})
+	return log.ContextWithSpanContext(ctx, log.SpanContext{
+		TraceID:    [16]byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: 1,
+	})
+}
+
+func TestLogsJSON(t *testing.T) {
+	r := newReceiver(t)
+	setup(t, Config{
+		Endpoint:           r.URL + "/",
+		Protocol:           ProtocolJSON,
+		Headers:            map[string]string{"Authorization": "Bearer token"},
+		ResourceAttributes: map[string]interface{}{"deployment.environment": "test"},
+	})
+
+	testProvider.Warn(testContext(), false, "Disk ", "full")
+	testProvider.Debug(testContext(), false, "Not sent")
+	testProvider.RecordEvent(context.Background(), "upload", log.Metrics{"bytes": 1024, "elapsed": 1.5})
+	testProvider.Wait()
+
+	requests := r.received()
+	Expect(requests).To(HaveLen(1))
+	Expect(requests[0].path).To(Equal("/v1/logs"))
+	Expect(requests[0].contentType).To(Equal("application/json"))
+	Expect(requests[0].header.Get("Authorization")).To(Equal("Bearer token"))
+	Expect(decodeJSON(requests[0].body)).To(Equal(decodeJSON([]byte(`{"resourceLogs": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "test-service"}},
+			{"key": "deployment.environment", "value": {"stringValue": "test"}}
+		]},
+		"scopeLogs": [{
+			"scope": {"name": "github.com/myhelix/contextlogger"},
+			"logRecords": [
+				{
+					"timeUnixNano": "1577934245000000006",
+					"observedTimeUnixNano": "1577934245000000006",
+					"severityNumber": 13,
+					"severityText": "WARN",
+					"body": {"stringValue": "Disk full"},
+					"attributes": [
+						{"key": "attempt", "value": {"intValue": "2"}},
+						{"key": "requestId", "value": {"stringValue": "abc"}}
+					],
+					"flags": 1,
+					"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+					"spanId": "00f067aa0ba902b7"
+				},
+				{
+					"timeUnixNano": "1577934245000000006",
+					"observedTimeUnixNano": "1577934245000000006",
+					"severityNumber": 9,
+					"severityText": "INFO",
+					"body": {"stringValue": "upload"},
+					"attributes": [
+						{"key": "bytes", "value": {"intValue": "1024"}},
+						{"key": "elapsed", "value": {"doubleValue": 1.5}},
+						{"key": "event.name", "value": {"stringValue": "upload"}}
+					]
+				}
+			]
+		}]
+	}]}`))))
+}
+
+func TestMetrics(t *testing.T) {
+	r := newReceiver(t)
+	setup(t, Config{Endpoint: r.URL, Protocol: ProtocolJSON})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"route": "/orders"})
+	testProvider.Record(ctx, log.Metrics{"queue": 7, "latency": 250 * time.Millisecond, "ratio": 0.5, "status": "ok"})
+	testProvider.Wait()
+
+	requests := r.received()
+	Expect(requests).To(HaveLen(1))
+	Expect(requests[0].path).To(Equal("/v1/metrics"))
+	attributes := `"attributes": [{"key": "route", "value": {"stringValue": "/orders"}}]`
+	Expect(decodeJSON(requests[0].body)["resourceMetrics"]).To(Equal(decodeJSON([]byte(`{"resourceMetrics": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "test-service"}}]},
+		"scopeMetrics": [{
+			"scope": {"name": "github.com/myhelix/contextlogger"},
+			"metrics": [
+				{"name": "latency", "unit": "s", "gauge": {"dataPoints": [
+					{` + attributes + `, "timeUnixNano": "1577934245000000006", "asDouble": 0.25}
+				]}},
+				{"name": "queue", "gauge": {"dataPoints": [
+					{` + attributes + `, "timeUnixNano": "1577934245000000006", "asInt": "7"}
+				]}},
+				{"name": "ratio", "gauge": {"dataPoints": [
+					{` + attributes + `, "timeUnixNano": "1577934245000000006", "asDouble": 0.5}
+				]}}
+			]
+		}]
+	}]}`))["resourceMetrics"]))
+}
+
+// A decoded protobuf field: a number for varints and fixed fields, or the bytes of strings and
+// embedded messages
+type field struct {
+	number int
+	value  interface{}
+}
+
+func decodeProto(b []byte) []field {
+	var fields []field
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		Expect(n).To(BeNumerically(">", 0))
+		b = b[n:]
+		f := field{number: int(tag >> 3)}
+		switch tag & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			Expect(n).To(BeNumerically(">", 0))
+			f.value, b = v, b[n:]
+		case wireFixed64:
+			f.value, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			f.value, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			Expect(n).To(BeNumerically(">", 0))
+			b = b[n:]
+			f.value, b = b[:length], b[length:]
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// The first field with the given number
+func get(fields []field, number int) interface{} {
+	for _, f := range fields {
+		if f.number == number {
+			return f.value
+		}
+	}
+	return nil
+}
+
+// Follow a path of embedded messages, each the first with its field number
+func path(b []byte, numbers ...int) []field {
+	fields := decodeProto(b)
+	for _, number := range numbers {
+		fields = decodeProto(get(fields, number).([]byte))
+	}
+	return fields
+}
+
+func TestLogsProtobuf(t *testing.T) {
+	r := newReceiver(t)
+	setup(t, Config{Endpoint: r.URL})
+
+	testProvider.Error(testContext(), true, strings.Repeat("x", 200))
+	testProvider.Wait()
+
+	requests := r.received()
+	Expect(requests).To(HaveLen(1))
+	Expect(requests[0].contentType).To(Equal("application/x-protobuf"))
+	// ExportLogsServiceRequest.resource_logs.scope_logs.log_records
+	record := path(requests[0].body, 1, 2, 2)
+	Expect(get(record, 1)).To(Equal(uint64(testTime.UnixNano())))
+	Expect(get(record, 2)).To(Equal(uint64(17)))
+	Expect(string(get(record, 3).([]byte))).To(Equal("ERROR"))
+	Expect(string(get(path(get(record, 5).([]byte)), 1).([]byte))).To(Equal(strings.Repeat("x", 200)))
+	Expect(get(record, 8)).To(Equal(uint64(1)))
+	Expect(get(record, 9)).To(HaveLen(16))
+	Expect(get(record, 10)).To(Equal([]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}))
+
+	var attrs []string
+	for _, f := range record {
+		if f.number == 6 {
+			kv := decodeProto(f.value.([]byte))
+			value := decodeProto(get(kv, 2).([]byte))
+			attrs = append(attrs, string(get(kv, 1).([]byte)))
+			if string(get(kv, 1).([]byte)) == "attempt" {
+				Expect(value).To(Equal([]field{{3, uint64(2)}}))
+			}
+		}
+	}
+	Expect(attrs).To(Equal([]string{"attempt", "requestId"}))
+
+	scope := path(requests[0].body, 1, 2, 1)
+	Expect(string(get(scope, 1).([]byte))).To(Equal(scopeName))
+	resourceAttribute := path(requests[0].body, 1, 1, 1)
+	Expect(string(get(resourceAttribute, 1).([]byte))).To(Equal("service.name"))
+}
+
+func TestRetry(t *testing.T) {
+	r := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	setup(t, Config{Endpoint: r.URL, MaxRetries: 3, RetryBackoff: time.Millisecond})
+	testProvider.Info(context.Background(), false, "Eventually")
+	testProvider.Wait()
+	requests := r.received()
+	Expect(requests).To(HaveLen(3))
+	Expect(requests[2].body).To(Equal(requests[0].body))
+
+	// Not retried, as a bad request will stay bad
+	r = newReceiver(t, http.StatusBadRequest)
+	setup(t, Config{Endpoint: r.URL, MaxRetries: 3, RetryBackoff: time.Millisecond})
+	testProvider.Info(context.Background(), false, "Rejected")
+	testProvider.Wait()
+	Expect(r.received()).To(HaveLen(1))
+
+	// Gives up after MaxRetries
+	r = newReceiver(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	setup(t, Config{Endpoint: r.URL, MaxRetries: 1, RetryBackoff: time.Millisecond})
+	testProvider.Info(context.Background(), false, "Lost")
+	testProvider.Wait()
+	Expect(r.received()).To(HaveLen(2))
+}
+
+func TestConfig(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := LogProvider(nil, Config{Level: "info", Protocol: "grpc"})
+	Expect(err).To(MatchError(`otlp: unsupported protocol "grpc" (expected protobuf or json)`))
+	_, err = LogProvider(nil, Config{Level: "loud"})
+	Expect(err).To(MatchError(`not a valid log level: "loud"`))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+	r := newReceiver(t)
+
+	provider, err := config.Build([]byte(`
+providers:
+  - name: otlp
+    options:
+      endpoint: ` + r.URL + `
+      protocol: json
+      headers: {X-Tenant: acme}
+      serviceName: configured
+      resourceAttributes: {region: eu}
+      level: warn
+`))
+	Expect(err).To(BeNil())
+	provider.Info(context.Background(), false, "Filtered")
+	provider.Error(context.Background(), false, "Sent")
+	provider.Wait()
+	requests := r.received()
+	Expect(requests).To(HaveLen(1))
+	Expect(requests[0].header.Get("X-Tenant")).To(Equal("acme"))
+	Expect(string(requests[0].body)).To(ContainSubstring(`{"key":"service.name","value":{"stringValue":"configured"}},{"key":"region","value":{"stringValue":"eu"}}`))
+	Expect(string(requests[0].body)).To(ContainSubstring(`"body":{"stringValue":"Sent"}`))
+	Expect(string(requests[0].body)).NotTo(ContainSubstring(`Filtered`))
+
+	_, err = config.Build([]byte(`{"providers": [{"name": "otlp", "options": {"protocol": "grpc"}}]}`))
+	Expect(err).To(MatchError(`config: providers[0] (otlp): option "protocol" must be one of protobuf|json, not "grpc"`))
+}
@@ -0,0 +1,32 @@
+package otlp
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+)
+
+func init() {
+	config.Register("otlp", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := Config{
+			Endpoint:      options.String("endpoint", ""),
+			Protocol:      options.OneOf("protocol", ProtocolProtobuf, ProtocolJSON),
+			Headers:       options.StringMap("headers"),
+			ServiceName:   options.String("serviceName", ""),
+			Level:         options.String("level", "info"),
+			MaxBatchSize:  options.Int("maxBatchSize", 0),
+			FlushInterval: options.Duration("flushInterval", 0),
+			MaxRetries:    options.Int("maxRetries", 0),
+			RetryBackoff:  options.Duration("retryBackoff", 0),
+		}
+		if attributes := options.StringMap("resourceAttributes"); len(attributes) > 0 {
+			providerConfig.ResourceAttributes = make(map[string]interface{})
+			for key, value := range attributes {
+				providerConfig.ResourceAttributes[key] = value
+			}
+		}
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+		return LogProvider(nextProvider, providerConfig)
+	})
+}
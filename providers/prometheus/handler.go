@@ -0,0 +1,100 @@
+package prometheus
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the metrics in the Prometheus text exposition format
+func (p *Provider) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(p.exposition())
+	})
+}
+
+func (p *Provider) exposition() []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	names := make([]string, 0, len(p.metrics))
+	for name := range p.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		m := p.metrics[name]
+		if m.help != "" {
+			buf.WriteString("# HELP " + name + " " + helpEscaper.Replace(m.help) + "\n")
+		}
+		buf.WriteString("# TYPE " + name + " " + m.metricType.String() + "\n")
+
+		keys := make([]string, 0, len(m.series))
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := m.series[key]
+			if m.metricType != Histogram {
+				writeSample(&buf, name, s.labels, "", s.value)
+				continue
+			}
+			var cumulative uint64
+			for i, count := range s.counts {
+				cumulative += count
+				bound := "+Inf"
+				if i < len(m.buckets) {
+					bound = formatFloat(m.buckets[i])
+				}
+				writeSample(&buf, name+"_bucket", s.labels, `le="`+bound+`"`, float64(cumulative))
+			}
+			writeSample(&buf, name+"_sum", s.labels, "", s.sum)
+			writeSample(&buf, name+"_count", s.labels, "", float64(cumulative))
+		}
+	}
+	return buf.Bytes()
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func writeSample(buf *bytes.Buffer, name, labels, extraLabel string, value float64) {
+	buf.WriteString(name)
+	if labels != "" || extraLabel != "" {
+		buf.WriteByte('{')
+		buf.WriteString(labels)
+		if labels != "" && extraLabel != "" {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(extraLabel)
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(value))
+	buf.WriteByte('\n')
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,293 @@
+/*
+This package exposes metrics from Record and RecordEvent for Prometheus to scrape, without needing
+the Prometheus client library. Each metric name is registered on first use, as a gauge (the
+default), counter or histogram according to Config.Types; recording a gauge sets it, recording a
+counter adds to it, and recording a histogram observes a value. time.Duration values are recorded
+in seconds. Metrics from RecordEvent are named after the event, so
+
+	log.RecordEvent("upload", log.Metrics{"bytes": 1024})
+
+sets the gauge upload_bytes. Selected context fields become labels; to keep a bad label (such as a
+user ID) from exhausting memory, each metric has at most MaxSeries label combinations, with any
+beyond that counted together under the label value "other".
+
+Serve Handler on an internal port for Prometheus to scrape.
+*/
+package prometheus
+
+import (
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MetricType int
+
+const (
+	Gauge MetricType = iota
+	Counter
+	Histogram
+)
+
+var typeNames = []string{"gauge", "counter", "histogram"}
+
+func (t MetricType) String() string {
+	if t >= 0 && int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return fmt.Sprintf("MetricType(%d)", int(t))
+}
+
+func ParseMetricType(name string) (MetricType, error) {
+	for metricType, typeName := range typeNames {
+		if strings.ToLower(name) == typeName {
+			return MetricType(metricType), nil
+		}
+	}
+	return Gauge, fmt.Errorf("not a valid metric type: %q", name)
+}
+
+// The same as the Prometheus client library's defaults, suited to request latencies in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Label value for series beyond MaxSeries
+const OverflowLabelValue = "other"
+
+type Config struct {
+	// Prepended to every metric name, e.g. "myservice_"
+	Namespace string
+	// By metric name (without Namespace, and including the event name for RecordEvent); any not
+	// listed are gauges
+	Types map[string]MetricType
+	// Upper bounds for histograms, by metric name; those not listed use DefaultBuckets
+	Buckets map[string][]float64
+	// Descriptions for the HELP line, by metric name
+	Help map[string]string
+	// Context fields to use as labels, on every metric
+	LabelFields []string
+	// Label combinations allowed per metric; defaults to 1000
+	MaxSeries int
+}
+
+type Provider struct {
+	providers.LogProvider
+	config     Config
+	labelNames []string
+	mutex      sync.Mutex
+	// By name
+	metrics     map[string]*metric
+	overflowKey string
+}
+
+type metric struct {
+	name       string
+	metricType MetricType
+	help       string
+	buckets    []float64
+	series     map[string]*series
+}
+
+type series struct {
+	labels string
+	value  float64
+	// Histograms only; counts per bucket (not cumulative), and the sum of observations
+	counts []uint64
+	sum    float64
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (*Provider, error) {
+	if config.MaxSeries <= 0 {
+		config.MaxSeries = 1000
+	}
+	for name, buckets := range config.Buckets {
+		if !sort.Float64sAreSorted(buckets) {
+			return nil, fmt.Errorf("prometheus: buckets for %q are not in increasing order", name)
+		}
+	}
+	p := &Provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		config:      config,
+		metrics:     make(map[string]*metric),
+	}
+	seen := make(map[string]string)
+	for _, field := range config.LabelFields {
+		name := sanitizeName(field, false)
+		if other, ok := seen[name]; ok {
+			return nil, fmt.Errorf("prometheus: label fields %q and %q both become label %q", other, field, name)
+		}
+		seen[name] = field
+		p.labelNames = append(p.labelNames, name)
+	}
+	overflow := make([]string, len(p.labelNames))
+	for i := range overflow {
+		overflow[i] = OverflowLabelValue
+	}
+	p.overflowKey = p.formatLabels(overflow)
+	return p, nil
+}
+
+func (p *Provider) formatLabels(values []string) string {
+	var b strings.Builder
+	for i, name := range p.labelNames {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Metric names may contain colons, but label names can't
+func sanitizeName(name string, colons bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9') || (colons && c == ':')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+func (p *Provider) labels(ctx context.Context) string {
+	if len(p.labelNames) == 0 {
+		return ""
+	}
+	fields := log.FieldsFromContext(ctx)
+	values := make([]string, len(p.labelNames))
+	for i, field := range p.config.LabelFields {
+		if val, ok := fields[field]; ok {
+			values[i] = fmt.Sprint(val)
+		}
+	}
+	return p.formatLabels(values)
+}
+
+// Must be called with mutex held
+func (p *Provider) metric(key string) *metric {
+	// By name rather than key, so keys which sanitize to the same name share a metric
+	name := sanitizeName(p.config.Namespace+key, true)
+	if m, ok := p.metrics[name]; ok {
+		return m
+	}
+	m := &metric{
+		name:       name,
+		metricType: p.config.Types[key],
+		help:       p.config.Help[key],
+		series:     make(map[string]*series),
+	}
+	if m.metricType == Histogram {
+		m.buckets = DefaultBuckets
+		if buckets, ok := p.config.Buckets[key]; ok {
+			m.buckets = buckets
+		}
+	}
+	p.metrics[name] = m
+	return m
+}
+
+// Must be called with mutex held
+func (p *Provider) series(m *metric, labels string) *series {
+	if s, ok := m.series[labels]; ok {
+		return s
+	}
+	if len(m.series) >= p.config.MaxSeries {
+		labels = p.overflowKey
+		if s, ok := m.series[labels]; ok {
+			return s
+		}
+	}
+	s := &series{labels: labels}
+	if m.metricType == Histogram {
+		s.counts = make([]uint64, len(m.buckets)+1)
+	}
+	m.series[labels] = s
+	return s
+}
+
+func numeric(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case time.Duration:
+		return v.Seconds(), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func (p *Provider) record(ctx context.Context, namePrefix string, metrics map[string]interface{}) {
+	labels := p.labels(ctx)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for key, val := range metrics {
+		value, ok := numeric(val)
+		if !ok {
+			continue
+		}
+		m := p.metric(namePrefix + key)
+		s := p.series(m, labels)
+		switch m.metricType {
+		case Gauge:
+			s.value = value
+		case Counter:
+			if value < 0 {
+				fmt.Fprintf(os.Stderr, "Ignoring negative value %v for counter %s\n", value, m.name)
+				continue
+			}
+			s.value += value
+		case Histogram:
+			s.counts[sort.SearchFloat64s(m.buckets, value)]++
+			s.sum += value
+		}
+	}
+}
+
+func (p *Provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *Provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName+"_", metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
@@ -0,0 +1,177 @@
+package prometheus
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	. "github.com/onsi/gomega"
+)
+
+var testProvider *Provider
+
+func setup(t *testing.T, config Config) {
+	RegisterTestingT(t)
+
+	provider, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	testProvider = provider
+}
+
+func scrape() string {
+	w := httptest.NewRecorder()
+	testProvider.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	Expect(w.Code).To(Equal(http.StatusOK))
+	Expect(w.Header().Get("Content-Type")).To(Equal("text/plain; version=0.0.4; charset=utf-8"))
+	return w.Body.String()
+}
+
+func lines(lines ...string) string {
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestTypes(t *testing.T) {
+	setup(t, Config{
+		Namespace: "svc_",
+		Types:     map[string]MetricType{"requests_total": Counter, "upload_elapsed": Histogram},
+		Buckets:   map[string][]float64{"upload_elapsed": {0.5, 1, 5}},
+		Help:      map[string]string{"requests_total": "Requests handled,\nso far", "queue": `Queue\length`},
+	})
+
+	ctx := context.Background()
+	testProvider.Record(ctx, log.Metrics{"requests_total": 1, "queue": 7, "status": "ok"})
+	testProvider.Record(ctx, log.Metrics{"requests_total": 2, "queue": 3})
+	testProvider.Record(ctx, log.Metrics{"requests_total": -1})
+	for _, elapsed := range []time.Duration{100 * time.Millisecond, time.Second, 2 * time.Second, time.Minute} {
+		testProvider.RecordEvent(ctx, "upload", log.Metrics{"elapsed": elapsed})
+	}
+	Expect(scrape()).To(Equal(lines(
+		`# HELP svc_queue Queue\\length`,
+		`# TYPE svc_queue gauge`,
+		`svc_queue 3`,
+		`# HELP svc_requests_total Requests handled,\nso far`,
+		`# TYPE svc_requests_total counter`,
+		`svc_requests_total 3`,
+		`# TYPE svc_upload_elapsed histogram`,
+		`svc_upload_elapsed_bucket{le="0.5"} 1`,
+		`svc_upload_elapsed_bucket{le="1"} 2`,
+		`svc_upload_elapsed_bucket{le="5"} 3`,
+		`svc_upload_elapsed_bucket{le="+Inf"} 4`,
+		`svc_upload_elapsed_sum 63.1`,
+		`svc_upload_elapsed_count 4`,
+	)))
+}
+
+func TestLabels(t *testing.T) {
+	setup(t, Config{LabelFields: []string{"route", "http.status"}})
+
+	for _, fields := range []log.Fields{
+		{"route": "/orders", "http.status": 200},
+		{"route": "/orders", "http.status": 500, "requestId": "abc"},
+		{"route": `say "hi"\`},
+		{},
+	} {
+		testProvider.Record(log.ContextWithFields(context.Background(), fields), log.Metrics{"hits": 1})
+	}
+	Expect(scrape()).To(Equal(lines(
+		`# TYPE hits gauge`,
+		`hits{route="",http_status=""} 1`,
+		`hits{route="/orders",http_status="200"} 1`,
+		`hits{route="/orders",http_status="500"} 1`,
+		`hits{route="say \"hi\"\\",http_status=""} 1`,
+	)))
+
+	_, err := LogProvider(nil, Config{LabelFields: []string{"a.b", "a_b"}})
+	Expect(err).To(MatchError(`prometheus: label fields "a.b" and "a_b" both become label "a_b"`))
+}
+
+func TestMaxSeries(t *testing.T) {
+	setup(t, Config{LabelFields: []string{"user"}, MaxSeries: 2, Types: map[string]MetricType{"logins": Counter}})
+
+	for _, user := range []string{"ann", "bob", "cat", "dan", "ann", "eve"} {
+		ctx := log.ContextWithFields(context.Background(), log.Fields{"user": user})
+		testProvider.Record(ctx, log.Metrics{"logins": 1})
+	}
+	Expect(scrape()).To(Equal(lines(
+		`# TYPE logins counter`,
+		`logins{user="ann"} 2`,
+		`logins{user="bob"} 1`,
+		`logins{user="other"} 3`,
+	)))
+}
+
+func TestNames(t *testing.T) {
+	setup(t, Config{})
+
+	testProvider.RecordEvent(context.Background(), "file upload", log.Metrics{"bytes.sent": 10, "9lives": 9})
+	testProvider.Record(context.Background(), log.Metrics{"file_upload_bytes_sent": 20, "ns:metric": 1})
+	Expect(scrape()).To(Equal(lines(
+		`# TYPE file_upload_9lives gauge`,
+		`file_upload_9lives 9`,
+		`# TYPE file_upload_bytes_sent gauge`,
+		`file_upload_bytes_sent 20`,
+		`# TYPE ns:metric gauge`,
+		`ns:metric 1`,
+	)))
+	Expect(sanitizeName("9lives", true)).To(Equal("_lives"))
+	Expect(sanitizeName("ns:label", false)).To(Equal("ns_label"))
+}
+
+func TestHandler(t *testing.T) {
+	setup(t, Config{})
+
+	w := httptest.NewRecorder()
+	testProvider.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/metrics", nil))
+	Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+	Expect(scrape()).To(BeEmpty())
+
+	_, err := LogProvider(nil, Config{Buckets: map[string][]float64{"x": {1, 0.5}}})
+	Expect(err).To(MatchError(`prometheus: buckets for "x" are not in increasing order`))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+	// Find a free port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	address := listener.Addr().String()
+	listener.Close()
+
+	chain, err := config.Parse([]byte(`
+providers:
+  - name: prometheus
+    options:
+      listen: ` + address + `
+      namespace: configured_
+      types: {latency: histogram}
+      buckets: {latency: "0.1, 1"}
+`))
+	Expect(err).To(BeNil())
+	provider, closer, err := config.OpenChain(chain)
+	Expect(err).To(BeNil())
+	provider.Record(context.Background(), log.Metrics{"latency": 0.5})
+	resp, err := http.Get("http://" + address + "/metrics")
+	Expect(err).To(BeNil())
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	Expect(err).To(BeNil())
+	Expect(string(body)).To(ContainSubstring(`configured_latency_bucket{le="1"} 1` + "\n"))
+
+	// Closing the chain stops serving, and frees the port for the next one
+	Expect(closer.Close()).To(Succeed())
+	_, err = http.Get("http://" + address + "/metrics")
+	Expect(err).NotTo(BeNil())
+	_, closer, err = config.OpenChain(chain)
+	Expect(err).To(BeNil())
+	Expect(closer.Close()).To(Succeed())
+
+	_, err = config.Build([]byte(`{"providers": [{"name": "prometheus", "options": {"listen": ":0", "types": {"x": "summary"}}}]}`))
+	Expect(err).To(MatchError(`config: providers[0] (prometheus): option "types": "x": not a valid metric type: "summary"`))
+}
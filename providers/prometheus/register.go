@@ -0,0 +1,78 @@
+package prometheus
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	config.Register("prometheus", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := Config{
+			Namespace:   options.String("namespace", ""),
+			Help:        options.StringMap("help"),
+			LabelFields: options.Strings("labelFields", nil),
+			MaxSeries:   options.Int("maxSeries", 0),
+		}
+		// Where to serve metrics, e.g. ":9090"
+		listen := options.RequiredString("listen")
+		path := options.String("path", "/metrics")
+		if types := options.StringMap("types"); len(types) > 0 {
+			providerConfig.Types = make(map[string]MetricType)
+			for name, typeName := range types {
+				metricType, err := ParseMetricType(typeName)
+				if err != nil {
+					options.Errorf("option \"types\": %q: %v", name, err)
+				}
+				providerConfig.Types[name] = metricType
+			}
+		}
+		// Comma-separated upper bounds, e.g. {latency: "0.1, 0.5, 1"}
+		if buckets := options.StringMap("buckets"); len(buckets) > 0 {
+			providerConfig.Buckets = make(map[string][]float64)
+			for name, list := range buckets {
+				for _, bound := range strings.Split(list, ",") {
+					f, err := strconv.ParseFloat(strings.TrimSpace(bound), 64)
+					if err != nil {
+						options.Errorf("option \"buckets\": %q: %q is not a number", name, bound)
+					}
+					providerConfig.Buckets[name] = append(providerConfig.Buckets[name], f)
+				}
+			}
+		}
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+
+		provider, err := LogProvider(nextProvider, providerConfig)
+		if err != nil {
+			return nil, err
+		}
+		// Only listen once the rest of the chain has been built, so a failure there doesn't leave
+		// the port taken
+		options.OnBuilt(func() error {
+			listener, err := net.Listen("tcp", listen)
+			if err != nil {
+				return err
+			}
+			mux := http.NewServeMux()
+			mux.Handle(path, provider.Handler())
+			server := &http.Server{Handler: mux}
+			go func() {
+				if err := server.Serve(listener); err != http.ErrServerClosed {
+					fmt.Fprintf(os.Stderr, "Stopped serving Prometheus metrics, %v\n", err)
+				}
+			}()
+			// Stop listening if the chain is closed, or another provider fails to start
+			options.OnClose(server.Close)
+			return nil
+		})
+		return provider, nil
+	})
+}
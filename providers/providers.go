@@ -5,6 +5,8 @@ package providers
 
 import (
 	"context"
+	"fmt"
+	"strings"
 )
 
 type LogLevel int
@@ -16,6 +18,29 @@ const (
 	Debug
 )
 
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+func (l LogLevel) String() string {
+	if l >= 0 && int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// ParseLevel accepts the names returned by String, case-insensitively, as well as "warning"
+func ParseLevel(name string) (LogLevel, error) {
+	name = strings.ToLower(name)
+	if name == "warning" {
+		return Warn, nil
+	}
+	for level, levelName := range levelNames {
+		if name == levelName {
+			return LogLevel(level), nil
+		}
+	}
+	return Error, fmt.Errorf("not a valid log level: %q", name)
+}
+
 type LogProvider interface {
 	Error(ctx context.Context, report bool, args ...interface{})
 	Warn(ctx context.Context, report bool, args ...interface{})
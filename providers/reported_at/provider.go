@@ -38,6 +38,11 @@ func LogProvider(nextProvider providers.LogProvider, config Config) providers.Lo
 }
 
 func (p provider) reportedAt(ctx context.Context) context.Context {
+	if file, line, ok := log.CallerFromContext(ctx); ok {
+		return log.ContextWithFields(ctx, log.Fields{
+			"reportedAt": fmt.Sprintf("%s:%d", file, line),
+		})
+	}
 	pc := make([]uintptr, 50)
 	runtime.Callers(1, pc)
 	frameData := runtime.FuncForPC(pc[0])
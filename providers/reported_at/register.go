@@ -0,0 +1,23 @@
+package reported_at
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"regexp"
+)
+
+func init() {
+	config.Register("reported_at", config.Enricher, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := RecommendedConfig
+		// An empty pattern disables filtering, apart from the frames we always skip
+		if pattern := options.String("ignoreStackFrames", RecommendedConfig.IgnoreStackFrames.String()); pattern == "" {
+			providerConfig.IgnoreStackFrames = nil
+		} else if ignore, err := regexp.Compile(pattern); err != nil {
+			options.Errorf("option \"ignoreStackFrames\": %v", err)
+		} else {
+			providerConfig.IgnoreStackFrames = ignore
+		}
+		return LogProvider(nextProvider, providerConfig), nil
+	})
+}
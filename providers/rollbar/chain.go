@@ -0,0 +1,164 @@
+package rollbar
+
+import (
+	"github.com/ansel1/merry"
+	goerr "github.com/go-errors/errors"
+	"github.com/myhelix/rollbar"
+
+	"github.com/myhelix/contextlogger/log"
+
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// The most errors reported from one call, in case of a cycle
+const maxChain = 20
+
+// What a report call was given: the errors among its arguments, and the rest as a message
+type report struct {
+	// The first error argument, or one made from the message if there aren't any
+	err  error
+	errs []error
+	// fmt.Sprint of the other arguments, without a trailing ": "
+	message string
+}
+
+func newReport(args []interface{}) report {
+	var r report
+	var others []interface{}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok && err != nil {
+			r.errs = append(r.errs, err)
+		} else {
+			others = append(others, arg)
+		}
+	}
+	r.message = strings.TrimSuffix(fmt.Sprint(others...), ": ")
+	if len(r.errs) > 0 {
+		r.err = r.errs[0]
+	} else {
+		// What was passed in wasn't an error, but we need an error
+		r.err = errors.New(r.message)
+	}
+	if r.message == "" {
+		r.message = r.err.Error()
+	}
+	return r
+}
+
+// The distinct errors in err's chain, outermost first, following Unwrap (including errors which
+// wrap several, like errors.Join) and merry causes. Layers which don't change the message, such
+// as merry's values, aren't included separately.
+func errorChain(err error) (chain []error) {
+	var walk func(err error, parent string)
+	walk = func(err error, parent string) {
+		for err != nil && len(chain) < maxChain {
+			if message := err.Error(); message != parent {
+				chain = append(chain, err)
+				parent = message
+			}
+			if multi, ok := err.(interface{ Unwrap() []error }); ok {
+				for _, inner := range multi.Unwrap() {
+					walk(inner, parent)
+				}
+				return
+			}
+			// For merry errors, this reaches the cause after the wrapped error's layers
+			err = errors.Unwrap(err)
+		}
+	}
+	walk(err, "")
+	return
+}
+
+// The stacks merry recorded for each error in the chain; merry.Stack also finds stacks further
+// down the chain, so those are only kept for the innermost error that has them
+func chainStacks(chain []error) [][]uintptr {
+	stacks := make([][]uintptr, len(chain))
+	for i, err := range chain {
+		stacks[i] = merry.Stack(err)
+	}
+	for i := range stacks {
+		for j := i + 1; j < len(stacks); j++ {
+			if sameStack(stacks[i], stacks[j]) {
+				stacks[i] = nil
+				break
+			}
+		}
+	}
+	return stacks
+}
+
+func sameStack(a, b []uintptr) bool {
+	return len(a) > 0 && len(a) == len(b) && &a[0] == &b[0]
+}
+
+func frames(goStack []uintptr) rollbar.Stack {
+	stack := rollbar.Stack{}
+	for _, f := range goStack {
+		sf := goerr.NewStackFrame(f)
+		stack = append(stack, rollbar.Frame{
+			Filename: rollbar.ShortenFilePath(sf.File),
+			Method:   sf.Name,
+			Line:     sf.LineNumber,
+		})
+	}
+	return stack
+}
+
+// Rollbar traces for the report, outermost first: the errors in each error argument's chain,
+// each with the stack merry recorded for it, if any. The first has the stack saved in the
+// context (e.g. by merry provider), or else the reporting callstack, and the message from the
+// other arguments, if there were any.
+func (r report) traces(ctx context.Context) []map[string]interface{} {
+	chain := []error{r.err}
+	if len(r.errs) > 0 {
+		chain = nil
+		for _, err := range r.errs {
+			chain = append(chain, errorChain(err)...)
+		}
+		if len(chain) > maxChain {
+			chain = chain[:maxChain]
+		}
+	}
+	stacks := chainStacks(chain)
+
+	goStack := log.StackFromContext(ctx)
+	if goStack == nil {
+		goStack = stacks[0]
+	}
+	// If a stack wasn't saved in the context or the error, then generate one
+	if goStack == nil {
+		goStack = make([]uintptr, 50)
+		n := runtime.Callers(1, goStack)
+		goStack = goStack[:n]
+	}
+	stacks[0] = goStack
+
+	traces := make([]map[string]interface{}, len(chain))
+	for i, err := range chain {
+		exception := map[string]interface{}{
+			"class":   errorClass(err),
+			"message": err.Error(),
+		}
+		if i == 0 && r.message != err.Error() {
+			exception["description"] = r.message
+		}
+		traces[i] = map[string]interface{}{
+			"frames":    frames(stacks[i]),
+			"exception": exception,
+		}
+	}
+	return traces
+}
+
+// An item's body: a trace_chain if there's more than one trace, which Rollbar shows as causes
+func body(traces []map[string]interface{}) map[string]interface{} {
+	if len(traces) == 1 {
+		return map[string]interface{}{"trace": traces[0]}
+	}
+	return map[string]interface{}{"trace_chain": traces}
+}
@@ -0,0 +1,78 @@
+package rollbar
+
+import (
+	"github.com/myhelix/contextlogger/providers/batching"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Rollbar's item API, which takes one item per request
+const DefaultEndpoint = "https://api.rollbar.com/api/1/item/"
+
+// Sends items to Rollbar in the background, one at a time, retrying if Rollbar is rate limiting
+// or unavailable
+type client struct {
+	endpoint     string
+	httpClient   *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+	batcher      *batching.Batcher
+}
+
+func newClient(config Config) *client {
+	c := &client{
+		endpoint:     config.Endpoint,
+		httpClient:   config.HTTPClient,
+		maxRetries:   config.MaxRetries,
+		retryBackoff: config.RetryBackoff,
+	}
+	c.batcher = batching.New(batching.Config{MaxItems: 1, QueueSize: config.QueueSize}, c.send)
+	return c
+}
+
+func (c *client) push(item map[string]interface{}) {
+	c.batcher.Add(item, 0)
+}
+
+func (c *client) wait() {
+	c.batcher.Flush()
+}
+
+func (c *client) send(items []interface{}) {
+	for _, item := range items {
+		body, err := json.Marshal(item)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode Rollbar item, %v\n", err)
+			continue
+		}
+		err = batching.Retry(c.maxRetries, c.retryBackoff, func() (time.Duration, error) {
+			return c.post(body)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to report to Rollbar, %v\n", err)
+		}
+	}
+}
+
+// Make one request, for batching.Retry
+func (c *client) post(body []byte) (time.Duration, error) {
+	resp, err := c.httpClient.Post(c.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		// Drain, so the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		return 0, nil
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return batching.RetryDelay(resp), fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+}
@@ -0,0 +1,96 @@
+package rollbar
+
+import (
+	"github.com/ansel1/merry"
+
+	"github.com/myhelix/contextlogger/log"
+
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// What's being reported, for Config.Fingerprint and Config.Title
+type Occurrence struct {
+	// The first error reported, or one made from the message if there wasn't one
+	Error error
+	// The arguments that weren't errors, or Error.Error() if there weren't any
+	Message string
+	// The class Rollbar shows, which is the error's type, or a checksum for errors.New
+	Class string
+	// The Rollbar level: error, warning, info or debug
+	Level string
+	// The error's merry values that have string keys
+	Values map[string]interface{}
+	Fields log.Fields
+}
+
+var (
+	uuidPattern   = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	numberPattern = regexp.MustCompile(`[0-9]+`)
+)
+
+// NormalizeMessage masks UUIDs and numbers, so "user 1234 not found" is "user <n> not found"
+func NormalizeMessage(message string) string {
+	message = uuidPattern.ReplaceAllString(message, "<uuid>")
+	return numberPattern.ReplaceAllString(message, "<n>")
+}
+
+// DefaultFingerprint groups occurrences by the error's type, where it was reported from (the
+// reportedAt field from the reported_at provider), and its message with IDs masked (see
+// NormalizeMessage)
+func DefaultFingerprint(o Occurrence) string {
+	reportedAt, _ := o.Fields["reportedAt"].(string)
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%T\x00%s\x00%s", o.Error, reportedAt, NormalizeMessage(o.Message))
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// Functions for Config.Title templates
+var titleFuncs = template.FuncMap{
+	"normalize": NormalizeMessage,
+}
+
+func parseTitle(title string) (*template.Template, error) {
+	if title == "" {
+		return nil, nil
+	}
+	t, err := template.New("title").Funcs(titleFuncs).Option("missingkey=zero").Parse(title)
+	if err != nil {
+		return nil, fmt.Errorf("rollbar: Title: %v", err)
+	}
+	return t, nil
+}
+
+func newOccurrence(r report, level string, fields log.Fields) Occurrence {
+	values := make(map[string]interface{})
+	for key, val := range merry.Values(r.err) {
+		if key, ok := key.(string); ok {
+			values[key] = val
+		}
+	}
+	return Occurrence{
+		Error:   r.err,
+		Message: r.message,
+		Class:   errorClass(r.err),
+		Level:   level,
+		Values:  values,
+		Fields:  fields,
+	}
+}
+
+// The item's title from the Title template, or the message if there isn't one (or it fails)
+func (p provider) makeTitle(o Occurrence) string {
+	if p.titleTemplate == nil {
+		return o.Message
+	}
+	var title strings.Builder
+	if err := p.titleTemplate.Execute(&title, o); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to make Rollbar title, %v\n", err)
+		return o.Message
+	}
+	return title.String()
+}
@@ -0,0 +1,104 @@
+package rollbar
+
+import (
+	"github.com/myhelix/contextlogger/log"
+
+	"context"
+	"fmt"
+)
+
+// The user affected by an occurrence, for Rollbar's People view; ID is required
+type Person struct {
+	ID       string
+	Username string
+	Email    string
+}
+
+// Names of the context fields to take the Person from, when there isn't one from WithPerson
+type PersonFields struct {
+	ID       string
+	Username string
+	Email    string
+}
+
+type contextPersonKey struct{}
+type contextSectionsKey struct{}
+
+// Report occurrences under ctx as affecting person, whatever the context fields say
+func WithPerson(ctx context.Context, person Person) log.ContextLogger {
+	return log.FromContext(context.WithValue(ctx, contextPersonKey{}, person))
+}
+
+// Add a top-level section to items reported under ctx, alongside Config.Sections
+func WithSection(ctx context.Context, name string, data interface{}) log.ContextLogger {
+	existing, _ := ctx.Value(contextSectionsKey{}).(map[string]interface{})
+	sections := make(map[string]interface{}, len(existing)+1)
+	for key, val := range existing {
+		sections[key] = val
+	}
+	sections[name] = data
+	return log.FromContext(context.WithValue(ctx, contextSectionsKey{}, sections))
+}
+
+// Keys of an item's data that Rollbar (or this provider) fills in, which sections can't replace;
+// "custom" is left for sections, as it's Rollbar's place for arbitrary data
+var reservedKeys = map[string]bool{
+	"environment":  true,
+	"body":         true,
+	"level":        true,
+	"timestamp":    true,
+	"code_version": true,
+	"platform":     true,
+	"language":     true,
+	"framework":    true,
+	"context":      true,
+	"request":      true,
+	"person":       true,
+	"server":       true,
+	"client":       true,
+	"fingerprint":  true,
+	"title":        true,
+	"uuid":         true,
+	"notifier":     true,
+	"logFields":    true,
+}
+
+func (p provider) person(ctx context.Context, fields log.Fields) map[string]interface{} {
+	person, ok := ctx.Value(contextPersonKey{}).(Person)
+	if !ok {
+		field := func(name string) string {
+			if val, ok := fields[name]; ok && name != "" {
+				return fmt.Sprint(val)
+			}
+			return ""
+		}
+		person = Person{
+			ID:       field(p.config.PersonFields.ID),
+			Username: field(p.config.PersonFields.Username),
+			Email:    field(p.config.PersonFields.Email),
+		}
+	}
+	if person.ID == "" {
+		return nil
+	}
+	data := map[string]interface{}{"id": person.ID}
+	if person.Username != "" {
+		data["username"] = person.Username
+	}
+	if person.Email != "" {
+		data["email"] = person.Email
+	}
+	return data
+}
+
+// Add Config.Sections and those from WithSection (which win) to an item's data
+func (p provider) addSections(ctx context.Context, data map[string]interface{}) {
+	sections, _ := ctx.Value(contextSectionsKey{}).(map[string]interface{})
+	for _, s := range []map[string]interface{}{p.config.Sections, sections} {
+		for name, section := range s {
+			if !reservedKeys[name] {
+				data[name] = section
+			}
+		}
+	}
+}
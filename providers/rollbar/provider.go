@@ -4,14 +4,19 @@
 /*
 This logger reports errors to Rollbar if they came in via {Error,Warn,Info}Report, then passes
 through for logging by the base logger, if any. It uses the stack stored by merry, if the error
-is a merry error; otherwise it generates a new one based on the reporting callstack.
+is a merry error; otherwise it generates a new one based on the reporting callstack. Errors
+which wrap others (with %w, merry causes or several at once) are sent as a trace_chain, with
+merry's stack for each cause that has one.
+
+Each provider has its own token and settings, and sends items itself in the background, so one
+process can report to several Rollbar projects.
 */
 package rollbar
 
 import (
-	goerr "github.com/go-errors/errors"
 	"github.com/myhelix/rollbar"
 
+	"github.com/myhelix/contextlogger/internal/jsonenc"
 	"github.com/myhelix/contextlogger/log"
 	"github.com/myhelix/contextlogger/providers"
 	"github.com/myhelix/contextlogger/providers/chaining"
@@ -19,80 +24,231 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/adler32"
 	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
 	"runtime"
+	"strings"
+	"text/template"
+	"time"
 )
 
+type Config struct {
+	// The project access token (post_server_item); required
+	Token string
+	// Defaults to "development"
+	Environment string
+	// Defaults to DefaultEndpoint
+	Endpoint string
+	// Reported as code_version, e.g. a git SHA
+	CodeVersion string
+	// Reported as the server's host; defaults to the hostname
+	ServerHost string
+	// Reported as the server's root, which Rollbar uses to tell project code from libraries
+	ServerRoot string
+	// Reported as the server's branch, e.g. "main"
+	ServerBranch string
+	// Request parameters and headers matching this are sent as "[FILTERED]"; defaults to
+	// rollbar.FilterFields (password, secret or token)
+	FilterFields *regexp.Regexp
+	// Items waiting to be sent, after which further items are dropped; defaults to 1000
+	QueueSize int
+	// Retries after Rollbar rate limits us or is unavailable, waiting RetryBackoff (doubling each
+	// time, or as long as Rollbar asks) in between; defaults to 3, and negative for none
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// Defaults to a client with a 10s timeout
+	HTTPClient *http.Client
+
+	// Groups occurrences into items; defaults to DefaultFingerprint
+	Fingerprint func(Occurrence) string
+	// A text/template for items' titles, executed with the Occurrence, e.g.
+	// `{{.Class}}: {{normalize .Message}} ({{.Fields.service}})`; defaults to the message
+	Title string
+
+	// Context fields for the person affected, unless there's one from WithPerson
+	PersonFields PersonFields
+	// The context field to report as Rollbar's context, e.g. a route or job name; defaults to the
+	// request's method and path, if there is a request
+	ContextField string
+	// Extra top-level sections for every item, e.g. {"deploy": {...}}; see also WithSection
+	Sections map[string]interface{}
+}
+
 type provider struct {
 	providers.LogProvider
+	config        Config
+	client        *client
+	titleTemplate *template.Template
+
+	// For tests
+	now func() time.Time
 }
 
-func LogProvider(nextProvider providers.LogProvider) (providers.LogProvider, error) {
-	if rollbar.Token == "" {
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	if config.Token == "" {
 		return nil, errors.New("Rollbar is not configured (no token)")
 	}
-	return provider{chaining.LogProvider(nextProvider)}, nil
+	if config.Environment == "" {
+		config.Environment = "development"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = DefaultEndpoint
+	}
+	if config.ServerHost == "" {
+		config.ServerHost, _ = os.Hostname()
+	}
+	if config.FilterFields == nil {
+		config.FilterFields = rollbar.FilterFields
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	} else if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = time.Second
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.Fingerprint == nil {
+		config.Fingerprint = DefaultFingerprint
+	}
+	titleTemplate, err := parseTitle(config.Title)
+	if err != nil {
+		return nil, err
+	}
+	return provider{
+		LogProvider:   chaining.LogProvider(nextProvider),
+		config:        config,
+		client:        newClient(config),
+		titleTemplate: titleTemplate,
+		now:           time.Now,
+	}, nil
 }
 
-type contextRequestKey struct{}
-
+// Same as log.ContextWithRequest, which other reporting providers also understand
 func WithRequest(ctx context.Context, req *http.Request) log.ContextLogger {
-	return log.FromContext(context.WithValue(ctx, contextRequestKey{}, req))
+	return log.FromContext(log.ContextWithRequest(ctx, req))
 }
 
-func requestFrom(ctx context.Context) *http.Request {
-	if req, ok := ctx.Value(contextRequestKey{}).(*http.Request); ok {
-		return req
+// The fields as they're sent: any that json can't encode (e.g. a channel) as text, so they don't
+// lose the whole item
+func logFields(fields log.Fields) map[string]interface{} {
+	safe := make(map[string]interface{}, len(fields))
+	for key, val := range fields {
+		safe[key] = jsonenc.Safe(val)
 	}
-	return nil
+	return safe
 }
 
-// If our list of arbitrary things is actually one error, return that error
-func listOfOneError(errs []interface{}) error {
-	if len(errs) == 1 {
-		if err, ok := errs[0].(error); ok {
-			return err
-		}
+func (p provider) reportToRollbar(ctx context.Context, level string, errs ...interface{}) {
+	r := newReport(errs)
+	fields := log.FieldsFromContext(ctx)
+	occurrence := newOccurrence(r, level, fields)
+
+	data := map[string]interface{}{
+		"environment": p.config.Environment,
+		"title":       p.makeTitle(occurrence),
+		"level":       level,
+		"timestamp":   p.now().Unix(),
+		"platform":    runtime.GOOS,
+		"language":    "go",
+		"server":      p.server(),
+		"notifier": map[string]interface{}{
+			"name": "contextlogger",
+		},
+		"body":        body(r.traces(ctx)),
+		"fingerprint": p.config.Fingerprint(occurrence),
+		"logFields":   logFields(fields),
+	}
+	if p.config.CodeVersion != "" {
+		data["code_version"] = p.config.CodeVersion
+	}
+	req := log.RequestFromContext(ctx)
+	if req != nil {
+		data["request"] = p.request(req)
+	}
+	if person := p.person(ctx, fields); person != nil {
+		data["person"] = person
+	}
+	if val, ok := fields[p.config.ContextField]; ok && p.config.ContextField != "" {
+		data["context"] = fmt.Sprint(val)
+	} else if req != nil {
+		data["context"] = req.Method + " " + req.URL.Path
 	}
-	return nil
+	p.addSections(ctx, data)
+	p.client.push(map[string]interface{}{
+		"access_token": p.config.Token,
+		"data":         data,
+	})
 }
 
-func consolidateErrs(ctx context.Context, errs []interface{}) (err error, stack rollbar.Stack) {
-	err = listOfOneError(errs)
-	if err == nil {
-		// What was passed in wasn't an error, but we need an error
-		err = errors.New(fmt.Sprint(errs...))
+func (p provider) server() map[string]interface{} {
+	server := map[string]interface{}{
+		"host": p.config.ServerHost,
+		"root": p.config.ServerRoot,
 	}
-	goStack := log.StackFromContext(ctx)
-	// If a stack wasn't saved in the context (e.g. by merry provider), then generate one
-	if goStack == nil {
-		goStack = make([]uintptr, 50)
-		runtime.Callers(1, goStack)
+	if p.config.ServerBranch != "" {
+		server["branch"] = p.config.ServerBranch
 	}
-	for _, f := range goStack {
-		sf := goerr.NewStackFrame(f)
-		stack = append(stack, rollbar.Frame{
-			Filename: rollbar.ShortenFilePath(sf.File),
-			Method:   sf.Name,
-			Line:     sf.LineNumber,
-		})
+	return server
+}
+
+// The class Rollbar shows for an error, which is its type, except for errors.New (and our own
+// errors from non-error arguments), which would all be the same
+func errorClass(err error) string {
+	class := reflect.TypeOf(err).String()
+	if class == "*errors.errorString" {
+		return fmt.Sprintf("{%x}", adler32.Checksum([]byte(err.Error())))
 	}
-	return
+	return strings.TrimPrefix(class, "*")
 }
 
-func (p provider) reportToRollbar(ctx context.Context, level string, errs ...interface{}) {
-	err, stack := consolidateErrs(ctx, errs)
+// The request in Rollbar's format, with sensitive parameters and headers filtered
+func (p provider) request(req *http.Request) map[string]interface{} {
+	query := p.filter(req.URL.Query())
+	return map[string]interface{}{
+		"url":          req.URL.String(),
+		"method":       req.Method,
+		"headers":      flatten(p.filter(req.Header)),
+		"query_string": url.Values(query).Encode(),
+		"GET":          flatten(query),
+		"POST":         flatten(p.filter(req.Form)),
+		"user_ip":      req.RemoteAddr,
+	}
+}
 
-	logFields := &rollbar.Field{
-		Name: "logFields",
-		Data: log.FieldsFromContext(ctx),
+// A copy of values, with those whose keys match FilterFields replaced
+func (p provider) filter(values map[string][]string) map[string][]string {
+	filtered := make(map[string][]string, len(values))
+	for key, vals := range values {
+		if p.config.FilterFields.MatchString(key) {
+			vals = []string{rollbar.FILTERED}
+		}
+		filtered[key] = vals
 	}
+	return filtered
+}
 
-	if req := requestFrom(ctx); req != nil {
-		rollbar.RequestErrorWithStack(level, req, err, stack, logFields)
-	} else {
-		rollbar.ErrorWithStack(level, err, stack, logFields)
+// Single values as themselves, rather than lists of one
+func flatten(values map[string][]string) map[string]interface{} {
+	flattened := make(map[string]interface{}, len(values))
+	for key, vals := range values {
+		if len(vals) == 1 {
+			flattened[key] = vals[0]
+		} else {
+			flattened[key] = vals
+		}
 	}
+	return flattened
 }
 
 func (p provider) Error(ctx context.Context, report bool, args ...interface{}) {
@@ -124,6 +280,6 @@ func (p provider) Debug(ctx context.Context, report bool, args ...interface{}) {
 }
 
 func (p provider) Wait() {
-	rollbar.Wait()
+	p.client.wait()
 	p.LogProvider.Wait()
 }
@@ -0,0 +1,424 @@
+package rollbar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	. "github.com/onsi/gomega"
+)
+
+var testProvider providers.LogProvider
+
+const testToken = "abc123"
+
+// A stand-in for Rollbar's item API, which checks and records items, responding to each request
+// with the next of the given statuses (then 200)
+type server struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	items    []map[string]interface{}
+	statuses []int
+}
+
+func newServer(t *testing.T, statuses ...int) *server {
+	s := &server{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		Expect(req.Method).To(Equal("POST"))
+		Expect(req.URL.Path).To(Equal("/api/1/item/"))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+		var item map[string]interface{}
+		Expect(json.NewDecoder(req.Body).Decode(&item)).To(Succeed())
+		Expect(item["access_token"]).To(Equal(testToken))
+		Expect(item["data"]).To(HaveKey("body"))
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.items = append(s.items, item)
+		if len(s.statuses) > 0 {
+			status := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+		}
+		w.Write([]byte(`{"err": 0, "result": {"uuid": "0123"}}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// The data of each item received
+func (s *server) received() []map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var data []map[string]interface{}
+	for _, item := range s.items {
+		data = append(data, item["data"].(map[string]interface{}))
+	}
+	return data
+}
+
+var testTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func setup(t *testing.T, s *server, config Config) {
+	RegisterTestingT(t)
+
+	config.Token = testToken
+	config.Endpoint = s.URL + "/api/1/item/"
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = time.Millisecond
+	}
+	logProvider, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	p := logProvider.(provider)
+	p.now = func() time.Time { return testTime }
+	testProvider = p
+}
+
+func TestReport(t *testing.T) {
+	s := newServer(t)
+	setup(t, s, Config{
+		Environment: "production",
+		CodeVersion: "v1.2.3",
+		ServerHost:  "web-1",
+		ServerRoot:  "github.com/myhelix/app",
+	})
+
+	// A field JSON can't encode mustn't lose the item
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"user": "sam", "done": make(chan struct{})})
+	testProvider.Error(ctx, true, errors.New("it broke"))
+	testProvider.Warn(ctx, false, "Not reported")
+	testProvider.Wait()
+
+	items := s.received()
+	Expect(items).To(HaveLen(1))
+	data := items[0]
+	Expect(data["environment"]).To(Equal("production"))
+	Expect(data["code_version"]).To(Equal("v1.2.3"))
+	Expect(data["server"]).To(Equal(map[string]interface{}{"host": "web-1", "root": "github.com/myhelix/app"}))
+	Expect(data["level"]).To(Equal("error"))
+	Expect(data["title"]).To(Equal("it broke"))
+	Expect(data["timestamp"]).To(BeNumerically("==", testTime.Unix()))
+	Expect(data["language"]).To(Equal("go"))
+	Expect(data["logFields"]).To(HaveLen(2))
+	Expect(data["logFields"]).To(HaveKeyWithValue("user", "sam"))
+	Expect(data["logFields"]).To(HaveKeyWithValue("done", MatchRegexp("^0x[0-9a-f]+$")))
+	Expect(data["fingerprint"]).To(HaveLen(40))
+
+	trace := data["body"].(map[string]interface{})["trace"].(map[string]interface{})
+	Expect(trace["exception"]).To(Equal(map[string]interface{}{"class": "{d760311}", "message": "it broke"}))
+	frames := trace["frames"].([]interface{})
+	Expect(frames).NotTo(BeEmpty())
+	Expect(frames[0]).To(HaveKey("filename"))
+	Expect(frames[0]).To(HaveKey("lineno"))
+}
+
+func TestRequest(t *testing.T) {
+	s := newServer(t)
+	setup(t, s, Config{FilterFields: regexp.MustCompile("(?i)secret|token")})
+
+	req := httptest.NewRequest("GET", "/orders?id=7&token=secret", nil)
+	req.Header.Set("Authorization", "Bearer xyz")
+	req.Header.Set("X-Secret", "shh")
+	testProvider.Warn(log.ContextWithRequest(context.Background(), req), true, "Slow")
+	testProvider.Wait()
+
+	items := s.received()
+	Expect(items).To(HaveLen(1))
+	Expect(items[0]["level"]).To(Equal("warning"))
+	Expect(items[0]["environment"]).To(Equal("development"))
+	Expect(items[0]["request"]).To(Equal(map[string]interface{}{
+		"url":          "/orders?id=7&token=secret",
+		"method":       "GET",
+		"headers":      map[string]interface{}{"Authorization": "Bearer xyz", "X-Secret": "[FILTERED]"},
+		"query_string": "id=7&token=%5BFILTERED%5D",
+		"GET":          map[string]interface{}{"id": "7", "token": "[FILTERED]"},
+		"POST":         map[string]interface{}{},
+		"user_ip":      "192.0.2.1:1234",
+	}))
+	// The request itself isn't changed
+	Expect(req.Header.Get("X-Secret")).To(Equal("shh"))
+}
+
+func TestRetry(t *testing.T) {
+	s := newServer(t, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	setup(t, s, Config{})
+	testProvider.Error(context.Background(), true, "Retried")
+	testProvider.Wait()
+	Expect(s.received()).To(HaveLen(3))
+
+	// Not retried, as it would fail again
+	s = newServer(t, http.StatusUnauthorized)
+	setup(t, s, Config{})
+	testProvider.Error(context.Background(), true, "Rejected")
+	testProvider.Wait()
+	Expect(s.received()).To(HaveLen(1))
+}
+
+func TestSeparateProjects(t *testing.T) {
+	RegisterTestingT(t)
+	first, second := newServer(t), newServer(t)
+
+	setup(t, first, Config{Environment: "first"})
+	firstProvider := testProvider
+	setup(t, second, Config{Environment: "second"})
+	firstProvider.Error(context.Background(), true, "One")
+	testProvider.Error(context.Background(), true, "Two")
+	firstProvider.Wait()
+	testProvider.Wait()
+
+	Expect(first.received()).To(HaveLen(1))
+	Expect(first.received()[0]["environment"]).To(Equal("first"))
+	Expect(second.received()).To(HaveLen(1))
+	Expect(second.received()[0]["environment"]).To(Equal("second"))
+
+	_, err := LogProvider(nil, Config{})
+	Expect(err).To(MatchError("Rollbar is not configured (no token)"))
+}
+
+func TestNormalizeMessage(t *testing.T) {
+	RegisterTestingT(t)
+	Expect(NormalizeMessage("user 1234 not found")).To(Equal("user <n> not found"))
+	Expect(NormalizeMessage("order 0b6e3a4c-1f2d-4e5f-8a9b-0c1d2e3f4a5b failed after 3 tries")).
+		To(Equal("order <uuid> failed after <n> tries"))
+}
+
+func TestFingerprint(t *testing.T) {
+	s := newServer(t)
+	setup(t, s, Config{})
+
+	at := func(reportedAt string) context.Context {
+		return log.ContextWithFields(context.Background(), log.Fields{"reportedAt": reportedAt})
+	}
+	testProvider.Error(at("main.go:10"), true, errors.New("user 1234 not found"))
+	testProvider.Error(at("main.go:10"), true, errors.New("user 5678 not found"))
+	testProvider.Error(at("main.go:20"), true, errors.New("user 1234 not found"))
+	testProvider.Error(at("main.go:10"), true, merry.New("user 1234 not found"))
+	testProvider.Error(at("main.go:10"), true, errors.New("order 1234 not found"))
+	testProvider.Wait()
+
+	items := s.received()
+	Expect(items).To(HaveLen(5))
+	fingerprints := make([]interface{}, len(items))
+	for i, item := range items {
+		fingerprints[i] = item["fingerprint"]
+	}
+	Expect(fingerprints[1]).To(Equal(fingerprints[0]))
+	// Different location, error type or message
+	Expect(fingerprints[2:]).NotTo(ContainElement(fingerprints[0]))
+	Expect(fingerprints[3]).NotTo(Equal(fingerprints[2]))
+	Expect(fingerprints[4]).NotTo(Equal(fingerprints[3]))
+
+	// A custom function
+	s = newServer(t)
+	var occurrence Occurrence
+	setup(t, s, Config{Fingerprint: func(o Occurrence) string {
+		occurrence = o
+		return o.Level + "/" + o.Values["code"].(string)
+	}})
+	err := merry.New("card declined").WithValue("code", "declined")
+	testProvider.Warn(at("main.go:10"), true, err)
+	testProvider.Wait()
+	Expect(s.received()[0]["fingerprint"]).To(Equal("warning/declined"))
+	Expect(occurrence.Error).To(Equal(err))
+	Expect(occurrence.Message).To(Equal("card declined"))
+	Expect(occurrence.Class).To(Equal("merry.errImpl"))
+	Expect(occurrence.Fields).To(Equal(log.Fields{"reportedAt": "main.go:10"}))
+}
+
+func TestTitle(t *testing.T) {
+	s := newServer(t)
+	setup(t, s, Config{Title: `{{.Level}}: {{normalize .Message}} in {{.Fields.service}}{{with .Values.code}} ({{.}}){{end}}`})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"service": "billing"})
+	testProvider.Error(ctx, true, merry.New("charge 42 failed").WithValue("code", "declined"))
+	testProvider.Error(ctx, true, "Plain 7")
+	testProvider.Wait()
+
+	items := s.received()
+	Expect(items[0]["title"]).To(Equal("error: charge <n> failed in billing (declined)"))
+	Expect(items[1]["title"]).To(Equal("error: Plain <n> in billing"))
+	// The message itself isn't changed
+	trace := items[0]["body"].(map[string]interface{})["trace"].(map[string]interface{})
+	Expect(trace["exception"].(map[string]interface{})["message"]).To(Equal("charge 42 failed"))
+
+	_, err := LogProvider(nil, Config{Token: testToken, Title: "{{.Message"})
+	Expect(err).To(MatchError(ContainSubstring("rollbar: Title: ")))
+}
+
+func TestPerson(t *testing.T) {
+	s := newServer(t)
+	setup(t, s, Config{PersonFields: PersonFields{ID: "userId", Email: "email"}})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"userId": 1234, "email": "sam@example.com"})
+	testProvider.Error(ctx, true, "From fields")
+	testProvider.Error(WithPerson(ctx, Person{ID: "5678", Username: "frodo"}), true, "From WithPerson")
+	testProvider.Error(log.ContextWithFields(context.Background(), log.Fields{"email": "sam@example.com"}), true, "No ID")
+	testProvider.Wait()
+
+	items := s.received()
+	Expect(items[0]["person"]).To(Equal(map[string]interface{}{"id": "1234", "email": "sam@example.com"}))
+	Expect(items[1]["person"]).To(Equal(map[string]interface{}{"id": "5678", "username": "frodo"}))
+	Expect(items[2]).NotTo(HaveKey("person"))
+}
+
+func TestContextAndSections(t *testing.T) {
+	s := newServer(t)
+	setup(t, s, Config{
+		ServerBranch: "main",
+		ContextField: "job",
+		Sections:     map[string]interface{}{"deploy": "blue", "level": "ignored", "custom": map[string]interface{}{"team": "billing"}},
+	})
+
+	req := httptest.NewRequest("POST", "/orders?id=7", nil)
+	testProvider.Error(log.ContextWithRequest(context.Background(), req), true, "From request")
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"job": "nightly-invoices"})
+	ctx = WithSection(WithSection(ctx, "deploy", "green"), "batch", map[string]interface{}{"size": 100})
+	testProvider.Error(ctx, true, "From field")
+	testProvider.Wait()
+
+	items := s.received()
+	Expect(items[0]["context"]).To(Equal("POST /orders"))
+	Expect(items[0]["server"]).To(HaveKeyWithValue("branch", "main"))
+	Expect(items[0]["deploy"]).To(Equal("blue"))
+	Expect(items[0]["custom"]).To(Equal(map[string]interface{}{"team": "billing"}))
+	Expect(items[0]["level"]).To(Equal("error"))
+	Expect(items[0]).NotTo(HaveKey("batch"))
+	Expect(items[1]["context"]).To(Equal("nightly-invoices"))
+	Expect(items[1]["deploy"]).To(Equal("green"))
+	Expect(items[1]["batch"]).To(Equal(map[string]interface{}{"size": float64(100)}))
+}
+
+// Like errors.Join, which needs a newer Go
+type multiError []error
+
+func (m multiError) Error() string {
+	var messages []string
+	for _, err := range m {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (m multiError) Unwrap() []error {
+	return m
+}
+
+func TestErrorChain(t *testing.T) {
+	s := newServer(t)
+	setup(t, s, Config{})
+
+	ctx := context.Background()
+	testProvider.Error(ctx, true, fmt.Errorf("saving order: %w", fmt.Errorf("writing row: %w", errors.New("disk full"))))
+	testProvider.Error(ctx, true, merry.New("query failed").WithValue("table", "orders").WithCause(merry.New("connection refused")))
+	testProvider.Error(ctx, true, "Import failed: ", multiError{errors.New("bad row"), errors.New("bad column")})
+	testProvider.Error(ctx, true, errors.New("first"), errors.New("second"))
+	testProvider.Wait()
+
+	items := s.received()
+	Expect(items).To(HaveLen(4))
+	type trace struct {
+		class, message, description string
+		frames                      int
+	}
+	chain := func(data map[string]interface{}) (traces []trace) {
+		for _, t := range data["body"].(map[string]interface{})["trace_chain"].([]interface{}) {
+			t := t.(map[string]interface{})
+			exception := t["exception"].(map[string]interface{})
+			description, _ := exception["description"].(string)
+			traces = append(traces, trace{
+				class:       exception["class"].(string),
+				message:     exception["message"].(string),
+				description: description,
+				frames:      len(t["frames"].([]interface{})),
+			})
+		}
+		return
+	}
+
+	traces := chain(items[0])
+	Expect(traces).To(HaveLen(3))
+	Expect(traces[0].class).To(Equal("fmt.wrapError"))
+	Expect(traces[0].message).To(Equal("saving order: writing row: disk full"))
+	Expect(traces[0].frames).NotTo(BeZero())
+	Expect(traces[1].message).To(Equal("writing row: disk full"))
+	Expect(traces[1].frames).To(BeZero())
+	Expect(traces[2].message).To(Equal("disk full"))
+	Expect(items[0]["title"]).To(Equal("saving order: writing row: disk full"))
+
+	// Merry's layers are one error, and each merry error has its own stack
+	traces = chain(items[1])
+	Expect(traces).To(HaveLen(2))
+	Expect(traces[0].message).To(Equal("query failed"))
+	Expect(traces[1].message).To(Equal("connection refused"))
+	Expect(traces[0].frames).NotTo(BeZero())
+	Expect(traces[1].frames).NotTo(BeZero())
+
+	traces = chain(items[2])
+	Expect(traces).To(Equal([]trace{
+		{class: "rollbar.multiError", message: "bad row; bad column", description: "Import failed", frames: traces[0].frames},
+		{class: errorClass(errors.New("bad row")), message: "bad row"},
+		{class: errorClass(errors.New("bad column")), message: "bad column"},
+	}))
+	Expect(items[2]["title"]).To(Equal("Import failed"))
+
+	traces = chain(items[3])
+	Expect(traces).To(HaveLen(2))
+	Expect(traces[0].message).To(Equal("first"))
+	Expect(traces[0].description).To(BeEmpty())
+	Expect(traces[1].message).To(Equal("second"))
+	Expect(items[3]["title"]).To(Equal("first"))
+}
+
+func TestReportMessage(t *testing.T) {
+	RegisterTestingT(t)
+	err := errors.New("it broke")
+	Expect(newReport([]interface{}{"Import failed: ", err}).message).To(Equal("Import failed"))
+	// Only the separator is trimmed, not other trailing colons and spaces
+	Expect(newReport([]interface{}{"Bad ratio 1:", err}).message).To(Equal("Bad ratio 1:"))
+	Expect(newReport([]interface{}{"Waiting for: ", ": "}).message).To(Equal("Waiting for: "))
+	Expect(newReport([]interface{}{err}).message).To(Equal("it broke"))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+	s := newServer(t)
+
+	provider, err := config.Build([]byte(`
+providers:
+  - name: rollbar
+    options:
+      token: ` + testToken + `
+      endpoint: ` + s.URL + `/api/1/item/
+      environment: staging
+      codeVersion: abcdef
+      title: "{{.Level}}: {{.Message}}"
+      personFields: {id: userId}
+`))
+	Expect(err).To(BeNil())
+	provider.Error(log.ContextWithFields(context.Background(), log.Fields{"userId": "u1"}), true, "Reported")
+	provider.Wait()
+	items := s.received()
+	Expect(items).To(HaveLen(1))
+	Expect(items[0]["environment"]).To(Equal("staging"))
+	Expect(items[0]["code_version"]).To(Equal("abcdef"))
+	Expect(items[0]["title"]).To(Equal("error: Reported"))
+	Expect(items[0]["person"]).To(Equal(map[string]interface{}{"id": "u1"}))
+
+	_, err = config.Build([]byte(`{"providers": [{"name": "rollbar", "options": {"environment": "staging"}}]}`))
+	Expect(err).To(MatchError(ContainSubstring(`option "token"`)))
+}
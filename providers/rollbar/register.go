@@ -0,0 +1,51 @@
+package rollbar
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"regexp"
+	"time"
+)
+
+func init() {
+	config.Register("rollbar", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := Config{
+			Token:        options.RequiredString("token"),
+			Environment:  options.String("environment", ""),
+			Endpoint:     options.String("endpoint", ""),
+			CodeVersion:  options.String("codeVersion", ""),
+			ServerHost:   options.String("serverHost", ""),
+			ServerRoot:   options.String("serverRoot", ""),
+			ServerBranch: options.String("serverBranch", ""),
+			QueueSize:    options.Int("queueSize", 0),
+			MaxRetries:   options.Int("maxRetries", 0),
+			RetryBackoff: options.Duration("retryBackoff", time.Second),
+			Title:        options.String("title", ""),
+			ContextField: options.String("contextField", ""),
+		}
+		personFields := options.StringMap("personFields")
+		for key, field := range personFields {
+			switch key {
+			case "id":
+				providerConfig.PersonFields.ID = field
+			case "username":
+				providerConfig.PersonFields.Username = field
+			case "email":
+				providerConfig.PersonFields.Email = field
+			default:
+				options.Errorf("option \"personFields\": unknown key %q (expected id, username or email)", key)
+			}
+		}
+		if pattern := options.String("filterFields", ""); pattern != "" {
+			var err error
+			if providerConfig.FilterFields, err = regexp.Compile(pattern); err != nil {
+				options.Errorf("option \"filterFields\": %v", err)
+			}
+		}
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+		return LogProvider(nextProvider, providerConfig)
+	})
+}
@@ -0,0 +1,260 @@
+package sentry
+
+import (
+	"github.com/myhelix/contextlogger/internal/jsonenc"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	sdkName    = "contextlogger"
+	sdkVersion = "1.0"
+)
+
+// The subset of the Sentry event payload that we fill in; see https://develop.sentry.dev/sdk/event-payloads/
+type event struct {
+	EventID     string                 `json:"event_id"`
+	Timestamp   string                 `json:"timestamp"`
+	Platform    string                 `json:"platform"`
+	Level       string                 `json:"level"`
+	Message     *message               `json:"message,omitempty"`
+	Exception   *exceptions            `json:"exception,omitempty"`
+	Request     *request               `json:"request,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+	ServerName  string                 `json:"server_name,omitempty"`
+	Release     string                 `json:"release,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	SDK         sdk                    `json:"sdk"`
+}
+
+type message struct {
+	Formatted string `json:"formatted"`
+}
+
+type exceptions struct {
+	Values []exception `json:"values"`
+}
+
+type exception struct {
+	Type       string      `json:"type"`
+	Value      string      `json:"value"`
+	Stacktrace *stacktrace `json:"stacktrace,omitempty"`
+}
+
+type stacktrace struct {
+	Frames []frame `json:"frames"`
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type request struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+type sdk struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+var levels = map[providers.LogLevel]string{
+	providers.Error: "error",
+	providers.Warn:  "warning",
+	providers.Info:  "info",
+	providers.Debug: "debug",
+}
+
+func eventID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func (p *provider) buildEvent(ctx context.Context, level providers.LogLevel, args []interface{}) *event {
+	e := &event{
+		EventID:     eventID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Platform:    "go",
+		Level:       levels[level],
+		ServerName:  p.config.ServerName,
+		Release:     p.config.Release,
+		Environment: p.config.Environment,
+		SDK:         sdk{sdkName, sdkVersion},
+	}
+
+	var err error
+	if len(args) == 1 {
+		err, _ = args[0].(error)
+	}
+	if err == nil {
+		// What was passed in wasn't an error, but we need an error
+		err = errors.New(fmt.Sprint(args...))
+		e.Exception = &exceptions{[]exception{{Type: "error", Value: err.Error()}}}
+	} else {
+		e.Exception = &exceptions{chain(err)}
+	}
+	e.Message = &message{err.Error()}
+
+	stack := log.StackFromContext(ctx)
+	// If a stack wasn't saved in the context (e.g. by merry provider), then generate one
+	if stack == nil {
+		stack = make([]uintptr, 50)
+		stack = stack[:runtime.Callers(1, stack)]
+	}
+	// The outermost error is last, and gets the stack
+	e.Exception.Values[len(e.Exception.Values)-1].Stacktrace = &stacktrace{frames(stack)}
+
+	for key, val := range log.FieldsFromContext(ctx) {
+		if key == stackTraceField {
+			continue
+		}
+		if tag, ok := p.tag(key, val); ok {
+			if e.Tags == nil {
+				e.Tags = make(map[string]string)
+			}
+			e.Tags[key] = tag
+		} else {
+			if e.Extra == nil {
+				e.Extra = make(map[string]interface{})
+			}
+			e.Extra[key] = jsonenc.Safe(val)
+		}
+	}
+
+	if req := log.RequestFromContext(ctx); req != nil {
+		e.Request = newRequest(req)
+	}
+	return e
+}
+
+// Added by the merry provider; the same information is already in the exception
+const stackTraceField = "~stackTrace"
+
+// Sentry limits tag keys to 32 characters, and values to 200
+func (p *provider) tag(key string, val interface{}) (string, bool) {
+	if p.tagFields != nil {
+		if !p.tagFields[key] {
+			return "", false
+		}
+	} else {
+		switch val.(type) {
+		case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		default:
+			return "", false
+		}
+	}
+	tag := fmt.Sprint(val)
+	if len(key) > 32 || len(tag) > 200 || tag == "" {
+		return "", false
+	}
+	return tag, true
+}
+
+// The chain of wrapped errors, innermost (i.e. the root cause) first, as Sentry expects
+func chain(err error) []exception {
+	var values []exception
+	for ; err != nil && len(values) < 10; err = errors.Unwrap(err) {
+		values = append([]exception{{Type: fmt.Sprintf("%T", err), Value: err.Error()}}, values...)
+	}
+	return values
+}
+
+// Sentry wants frames oldest first, the opposite of runtime.Callers
+func frames(stack []uintptr) []frame {
+	var result []frame
+	callers := runtime.CallersFrames(stack)
+	for {
+		f, more := callers.Next()
+		if f.Function != "" {
+			module, function := splitFunction(f.Function)
+			result = append([]frame{{
+				Function: function,
+				Module:   module,
+				Filename: shortenPath(module, f.File),
+				AbsPath:  f.File,
+				Lineno:   f.Line,
+				InApp:    inApp(module),
+			}}, result...)
+		}
+		if !more {
+			return result
+		}
+	}
+}
+
+// github.com/a/b.(*T).M is function (*T).M in module github.com/a/b
+func splitFunction(name string) (module, function string) {
+	start := strings.LastIndex(name, "/") + 1
+	if dot := strings.Index(name[start:], "."); dot >= 0 {
+		return name[:start+dot], name[start+dot+1:]
+	}
+	return "", name
+}
+
+// Name files by package rather than wherever they were built, e.g. github.com/a/b/c.go
+func shortenPath(module, file string) string {
+	if module == "" {
+		return file
+	}
+	return module + "/" + path.Base(file)
+}
+
+// Standard library and runtime packages have no dot in their first path element
+func inApp(module string) bool {
+	first := module
+	if slash := strings.Index(first, "/"); slash >= 0 {
+		first = first[:slash]
+	}
+	return strings.Contains(first, ".") && !strings.HasPrefix(module, "github.com/myhelix/contextlogger/")
+}
+
+// Headers which could let someone impersonate the user aren't sent
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+func newRequest(req *http.Request) *request {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	r := &request{
+		URL:         scheme + "://" + req.Host + req.URL.Path,
+		Method:      req.Method,
+		QueryString: req.URL.RawQuery,
+		Headers:     make(map[string]string),
+	}
+	for name, values := range req.Header {
+		if !sensitiveHeaders[name] {
+			r.Headers[name] = strings.Join(values, ",")
+		}
+	}
+	if req.RemoteAddr != "" {
+		r.Env = map[string]string{"REMOTE_ADDR": req.RemoteAddr}
+	}
+	return r
+}
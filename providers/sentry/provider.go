@@ -0,0 +1,190 @@
+/*
+This logger reports errors to Sentry if they came in via {Error,Warn,Info,Debug}Report, then passes
+through for logging by the base logger, if any. Like the rollbar provider, it uses the stack stored
+by merry, if the error is a merry error; otherwise it generates a new one based on the reporting
+callstack. Context fields become tags (or extra data, for values which don't make good tags), and
+a request attached with log.ContextWithRequest is included too.
+
+Events are sent in the background; Wait blocks until they have all been sent.
+*/
+package sentry
+
+import (
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// From the project's Client Keys settings, e.g. https://public@o1.ingest.sentry.io/42
+	DSN         string
+	Environment string
+	Release     string
+	// Defaults to os.Hostname
+	ServerName string
+	// Context fields to send as tags, with all others sent as extra data; if nil, fields with
+	// string, bool or integer values become tags
+	TagFields []string
+	// How many events can be waiting to be sent before more are dropped; defaults to 100
+	BufferSize int
+	// Defaults to a client with a 30s timeout
+	HTTPClient *http.Client
+}
+
+type provider struct {
+	providers.LogProvider
+	config    Config
+	dsn       *dsn
+	tagFields map[string]bool
+
+	queue chan *event
+	// Events queued or being sent, so Wait knows when we're done
+	pending     int
+	pendingCond *sync.Cond
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	dsn, err := parseDSN(config.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if config.ServerName == "" {
+		config.ServerName, _ = os.Hostname()
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 100
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	p := &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		config:      config,
+		dsn:         dsn,
+		queue:       make(chan *event, config.BufferSize),
+		pendingCond: sync.NewCond(new(sync.Mutex)),
+	}
+	if config.TagFields != nil {
+		p.tagFields = make(map[string]bool)
+		for _, field := range config.TagFields {
+			p.tagFields[field] = true
+		}
+	}
+	go p.send()
+	return p, nil
+}
+
+func (p *provider) report(ctx context.Context, level providers.LogLevel, args []interface{}) {
+	e := p.buildEvent(ctx, level, args)
+	p.pendingCond.L.Lock()
+	p.pending++
+	p.pendingCond.L.Unlock()
+	select {
+	case p.queue <- e:
+	default:
+		p.done()
+		fmt.Fprintf(os.Stderr, "Sentry queue is full, dropping event: %s\n", e.Message.Formatted)
+	}
+}
+
+func (p *provider) done() {
+	p.pendingCond.L.Lock()
+	p.pending--
+	if p.pending == 0 {
+		p.pendingCond.Broadcast()
+	}
+	p.pendingCond.L.Unlock()
+}
+
+func (p *provider) send() {
+	for e := range p.queue {
+		if err := p.post(e); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send event to Sentry, %v\n", err)
+		}
+		p.done()
+	}
+}
+
+// Post an event as an envelope; see https://develop.sentry.dev/sdk/envelopes/
+func (p *provider) post(e *event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	header, _ := json.Marshal(map[string]string{
+		"event_id": e.EventID,
+		"dsn":      p.dsn.original,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	body.Write(header)
+	fmt.Fprintf(&body, "\n{\"type\":\"event\",\"length\":%d}\n", len(payload))
+	body.Write(payload)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequest("POST", p.dsn.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", p.dsn.authHeader())
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	// Drain, so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+func (p *provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	if report {
+		p.report(ctx, providers.Error, args)
+	}
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	if report {
+		p.report(ctx, providers.Warn, args)
+	}
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	if report {
+		p.report(ctx, providers.Info, args)
+	}
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	if report {
+		p.report(ctx, providers.Debug, args)
+	}
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *provider) Wait() {
+	p.pendingCond.L.Lock()
+	for p.pending > 0 {
+		p.pendingCond.Wait()
+	}
+	p.pendingCond.L.Unlock()
+	p.LogProvider.Wait()
+}
@@ -0,0 +1,279 @@
+package sentry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ansel1/merry"
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	merryProvider "github.com/myhelix/contextlogger/providers/merry"
+	. "github.com/onsi/gomega"
+)
+
+var testProvider providers.LogProvider
+
+// A stand-in for Sentry, collecting the events posted to it
+type server struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	events   []event
+	requests []*http.Request
+	status   int
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, r)
+
+	body := bufio.NewReader(r.Body)
+	var header, item map[string]interface{}
+	Expect(json.NewDecoder(strings.NewReader(readLine(body))).Decode(&header)).To(Succeed())
+	Expect(json.NewDecoder(strings.NewReader(readLine(body))).Decode(&item)).To(Succeed())
+	Expect(item["type"]).To(Equal("event"))
+	payload := readLine(body)
+	Expect(item["length"]).To(BeNumerically("==", len(payload)))
+	var e event
+	Expect(json.Unmarshal([]byte(payload), &e)).To(Succeed())
+	Expect(header["event_id"]).To(Equal(e.EventID))
+	s.events = append(s.events, e)
+
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		fmt.Fprintln(w, "go away")
+	}
+}
+
+func readLine(r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	Expect(err).To(BeNil())
+	return strings.TrimSuffix(line, "\n")
+}
+
+func (s *server) received() []event {
+	testProvider.Wait()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.events
+}
+
+func setup(t *testing.T, config Config) *server {
+	RegisterTestingT(t)
+
+	s := &server{}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+	config.DSN = strings.Replace(s.URL, "http://", "http://public:secret@", 1) + "/sentry/42"
+	if config.ServerName == "" {
+		config.ServerName = "test-host"
+	}
+	provider, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	testProvider = provider
+	return s
+}
+
+func frameFunctions(e event) []string {
+	var functions []string
+	for _, f := range e.Exception.Values[len(e.Exception.Values)-1].Stacktrace.Frames {
+		functions = append(functions, f.Module+"."+f.Function)
+	}
+	return functions
+}
+
+func TestReport(t *testing.T) {
+	s := setup(t, Config{Environment: "test", Release: "1.2.3"})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		"requestId": "abc",
+		"attempt":   2,
+		"nested":    map[string]interface{}{"a": 1},
+		"cause":     errors.New("some cause"),
+		// Can't be encoded as JSON, but mustn't lose the event
+		"ratio": math.NaN(),
+	})
+	testProvider.Error(ctx, true, errors.New("it broke"))
+	testProvider.Error(ctx, false, "not reported")
+	testProvider.Warn(ctx, true, "Something ", "odd")
+
+	events := s.received()
+	Expect(events).To(HaveLen(2))
+	e := events[0]
+	Expect(e.EventID).To(MatchRegexp("^[0-9a-f]{32}$"))
+	Expect(e.Level).To(Equal("error"))
+	Expect(e.Platform).To(Equal("go"))
+	Expect(e.Environment).To(Equal("test"))
+	Expect(e.Release).To(Equal("1.2.3"))
+	Expect(e.ServerName).To(Equal("test-host"))
+	Expect(e.Message.Formatted).To(Equal("it broke"))
+	Expect(e.Exception.Values).To(HaveLen(1))
+	Expect(e.Exception.Values[0].Type).To(Equal("*errors.errorString"))
+	Expect(e.Exception.Values[0].Value).To(Equal("it broke"))
+	Expect(e.Tags).To(Equal(map[string]string{"requestId": "abc", "attempt": "2"}))
+	Expect(e.Extra).To(Equal(map[string]interface{}{"nested": map[string]interface{}{"a": 1.0}, "cause": "some cause", "ratio": "NaN"}))
+	Expect(e.Request).To(BeNil())
+
+	// Oldest first, so the reporting code is near the end
+	functions := frameFunctions(e)
+	Expect(functions).To(ContainElement("github.com/myhelix/contextlogger/providers/sentry.TestReport"))
+	Expect(functions[0]).To(Equal("runtime.goexit"))
+	last := e.Exception.Values[0].Stacktrace.Frames[len(functions)-1]
+	Expect(last.InApp).To(BeFalse())
+	Expect(last.Filename).To(Equal("github.com/myhelix/contextlogger/providers/sentry/event.go"))
+
+	Expect(events[1].Level).To(Equal("warning"))
+	Expect(events[1].Message.Formatted).To(Equal("Something odd"))
+	Expect(events[1].Exception.Values[0].Type).To(Equal("error"))
+
+	r := s.requests[0]
+	Expect(r.URL.Path).To(Equal("/sentry/api/42/envelope/"))
+	Expect(r.Header.Get("Content-Type")).To(Equal("application/x-sentry-envelope"))
+	Expect(r.Header.Get("X-Sentry-Auth")).To(Equal("Sentry sentry_version=7, sentry_client=contextlogger/1.0, sentry_key=public, sentry_secret=secret"))
+}
+
+func makeMerryError() error {
+	return merry.New("merry error").WithValue("orderId", 99)
+}
+
+func TestMerryStack(t *testing.T) {
+	s := setup(t, Config{})
+	testProvider = merryProvider.LogProvider(testProvider)
+
+	testProvider.Error(context.Background(), true, makeMerryError())
+	events := s.received()
+	Expect(events).To(HaveLen(1))
+	functions := frameFunctions(events[0])
+	// The stack is from where the error was made, not where it was reported
+	Expect(functions[len(functions)-1]).To(Equal("github.com/myhelix/contextlogger/providers/sentry.makeMerryError"))
+	Expect(events[0].Tags).To(Equal(map[string]string{"orderId": "99"}))
+	Expect(events[0].Extra).To(BeEmpty())
+}
+
+func TestErrorChain(t *testing.T) {
+	s := setup(t, Config{})
+
+	root := errors.New("connection refused")
+	testProvider.Error(context.Background(), true, fmt.Errorf("saving order: %w", root))
+	values := s.received()[0].Exception.Values
+	Expect(values).To(HaveLen(2))
+	Expect(values[0].Value).To(Equal("connection refused"))
+	Expect(values[0].Stacktrace).To(BeNil())
+	Expect(values[1].Type).To(Equal("*fmt.wrapError"))
+	Expect(values[1].Value).To(Equal("saving order: connection refused"))
+	Expect(values[1].Stacktrace).ToNot(BeNil())
+}
+
+func TestRequest(t *testing.T) {
+	s := setup(t, Config{})
+
+	req := httptest.NewRequest("POST", "http://example.com/orders?id=7", nil)
+	req.Header.Set("User-Agent", "curl/7.0")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	ctx := log.ContextWithRequest(context.Background(), req)
+	testProvider.Info(ctx, true, "with request")
+
+	Expect(s.received()[0].Request).To(Equal(&request{
+		URL:         "http://example.com/orders",
+		Method:      "POST",
+		QueryString: "id=7",
+		Headers:     map[string]string{"User-Agent": "curl/7.0"},
+		Env:         map[string]string{"REMOTE_ADDR": "192.0.2.1:1234"},
+	}))
+}
+
+func TestTagFields(t *testing.T) {
+	s := setup(t, Config{TagFields: []string{"user", "long", "status"}})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		"user":      map[string]interface{}{"id": 7},
+		"long":      strings.Repeat("x", 201),
+		"status":    200,
+		"requestId": "abc",
+	})
+	testProvider.Debug(ctx, true, "tagged")
+	e := s.received()[0]
+	Expect(e.Level).To(Equal("debug"))
+	Expect(e.Tags).To(Equal(map[string]string{"user": "map[id:7]", "status": "200"}))
+	Expect(e.Extra).To(HaveKey("long"))
+	Expect(e.Extra).To(HaveKey("requestId"))
+}
+
+func TestServerError(t *testing.T) {
+	s := setup(t, Config{})
+	s.status = http.StatusTooManyRequests
+
+	// Logged to stderr, and otherwise ignored
+	testProvider.Error(context.Background(), true, "rate limited")
+	Expect(s.received()).To(HaveLen(1))
+}
+
+func TestQueueFull(t *testing.T) {
+	s := setup(t, Config{BufferSize: 1})
+	s.mutex.Lock()
+
+	for i := 0; i < 10; i++ {
+		testProvider.Error(context.Background(), true, "flood ", i)
+	}
+	s.mutex.Unlock()
+	// One being sent, one queued, and the rest dropped
+	Expect(len(s.received())).To(BeNumerically("<=", 2))
+}
+
+func TestDSN(t *testing.T) {
+	RegisterTestingT(t)
+
+	d, err := parseDSN("https://abc@o1.ingest.sentry.io/123")
+	Expect(err).To(BeNil())
+	Expect(d.endpoint).To(Equal("https://o1.ingest.sentry.io/api/123/envelope/"))
+	Expect(d.authHeader()).To(Equal("Sentry sentry_version=7, sentry_client=contextlogger/1.0, sentry_key=abc"))
+
+	for dsn, problem := range map[string]string{
+		"":                            `invalid DSN "": scheme must be http or https`,
+		"ftp://abc@example.com/1":     `invalid DSN "ftp://abc@example.com/1": scheme must be http or https`,
+		"https://example.com/1":       `invalid DSN "https://example.com/1": no public key`,
+		"https://abc@example.com/":    `invalid DSN "https://abc@example.com/": no project ID`,
+		"https://abc@example.com":     `invalid DSN "https://abc@example.com": no project ID`,
+		"https://abc@example.com/%zz": `invalid DSN: parse "https://abc@example.com/%zz": invalid URL escape "%zz"`,
+	} {
+		_, err := LogProvider(nil, Config{DSN: dsn})
+		Expect(err).To(MatchError("sentry: "+problem), dsn)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	s := setup(t, Config{})
+
+	provider, err := config.Build([]byte(`
+providers:
+  - name: sentry
+    options:
+      dsn: ` + strconv.Quote(strings.Replace(s.URL, "http://", "http://key@", 1)+"/7") + `
+      environment: configured
+      tagFields: [requestId]
+`))
+	Expect(err).To(BeNil())
+	testProvider = provider
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"requestId": "abc", "user": "sam"})
+	provider.Error(ctx, true, "from config")
+	e := s.received()[0]
+	Expect(e.Environment).To(Equal("configured"))
+	Expect(e.Tags).To(Equal(map[string]string{"requestId": "abc"}))
+	Expect(e.Extra).To(Equal(map[string]interface{}{"user": "sam"}))
+	Expect(s.requests[0].URL.Path).To(Equal("/api/7/envelope/"))
+
+	_, err = config.Build([]byte(`{"providers": [{"name": "sentry"}]}`))
+	Expect(err).To(MatchError(`config: providers[0] (sentry): missing required option "dsn"`))
+}
@@ -0,0 +1,23 @@
+package sentry
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+)
+
+func init() {
+	config.Register("sentry", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := Config{
+			DSN:         options.RequiredString("dsn"),
+			Environment: options.String("environment", ""),
+			Release:     options.String("release", ""),
+			ServerName:  options.String("serverName", ""),
+			TagFields:   options.Strings("tagFields", nil),
+			BufferSize:  options.Int("bufferSize", 0),
+		}
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+		return LogProvider(nextProvider, providerConfig)
+	})
+}
@@ -0,0 +1,270 @@
+/*
+This package sends metrics from Record and RecordEvent to StatsD, or to DogStatsD with tags and
+events. Numeric metrics become gauges unless configured as counts or timings; time.Duration values
+are timings in milliseconds. Metrics from RecordEvent are named after the event, so
+
+	log.RecordEvent("upload", log.Metrics{"bytes": 1024})
+
+sends upload.bytes:1024|g. Lines are batched into packets of up to MaxPacketSize, which are sent
+every FlushInterval, and by Wait.
+*/
+package statsd
+
+import (
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/batching"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MetricType string
+
+const (
+	Gauge  MetricType = "g"
+	Count  MetricType = "c"
+	Timing MetricType = "ms"
+)
+
+type Config struct {
+	// "udp" or "unixgram"; defaults to udp
+	Network string
+	// Defaults to 127.0.0.1:8125
+	Address string
+	// Prepended to every metric name, e.g. "myservice."
+	Prefix string
+	// By metric name (without Prefix, and including the event name for RecordEvent); any not
+	// listed are gauges, or timings for time.Duration values
+	Types map[string]MetricType
+	// Context fields to send as DogStatsD tags, e.g. "region" for region:us-east-1
+	TagFields []string
+	// DogStatsD tags sent with everything, e.g. "env:prod"
+	Tags []string
+	// Also send each RecordEvent as a DogStatsD event, with its metrics as the text
+	Events bool
+	// Defaults to 1432 bytes for udp (to fit a typical MTU), 8192 for unixgram
+	MaxPacketSize int
+	// Defaults to 100ms
+	FlushInterval time.Duration
+}
+
+type provider struct {
+	providers.LogProvider
+	config  Config
+	conn    net.Conn
+	batcher *batching.Batcher
+	// Constant tags, already formatted, with a leading comma if there are any
+	tags string
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	if config.Network == "" {
+		config.Network = "udp"
+	}
+	if config.Address == "" {
+		config.Address = "127.0.0.1:8125"
+	}
+	if config.MaxPacketSize == 0 {
+		config.MaxPacketSize = 1432
+		if config.Network == "unixgram" {
+			config.MaxPacketSize = 8192
+		}
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 100 * time.Millisecond
+	}
+	for name, metricType := range config.Types {
+		switch metricType {
+		case Gauge, Count, Timing:
+		default:
+			return nil, fmt.Errorf("statsd: metric %q has unknown type %q (expected g, c or ms)", name, metricType)
+		}
+	}
+	switch config.Network {
+	case "udp", "udp4", "udp6", "unixgram":
+	default:
+		return nil, fmt.Errorf("statsd: unsupported network %q (expected udp or unixgram)", config.Network)
+	}
+	conn, err := net.Dial(config.Network, config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+
+	p := &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		config:      config,
+		conn:        conn,
+	}
+	for _, tag := range config.Tags {
+		p.tags += "," + sanitizeTag(tag)
+	}
+	p.batcher = batching.New(batching.Config{
+		// Lines are separated by newlines, but the last doesn't need one
+		MaxBytes:      config.MaxPacketSize + 1,
+		FlushInterval: config.FlushInterval,
+	}, p.send)
+	return p, nil
+}
+
+func (p *provider) send(lines []interface{}) {
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line.([]byte)...)
+	}
+	if _, err := p.conn.Write(packet); err != nil {
+		// Sockets can be left broken by the agent restarting, so try again with a fresh one
+		p.conn.Close()
+		if p.conn, err = net.Dial(p.config.Network, p.config.Address); err == nil {
+			_, err = p.conn.Write(packet)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send metrics to StatsD, %v\n", err)
+		}
+	}
+}
+
+func (p *provider) add(line []byte) {
+	p.batcher.Add(line, len(line)+1)
+}
+
+// The DogStatsD tags section for a line (e.g. |#env:prod,region:us-east-1), if any
+func (p *provider) contextTags(ctx context.Context) string {
+	tags := p.tags
+	if len(p.config.TagFields) > 0 {
+		fields := log.FieldsFromContext(ctx)
+		for _, field := range p.config.TagFields {
+			if val, ok := fields[field]; ok {
+				tags += "," + sanitizeTag(field+":"+fmt.Sprint(val))
+			}
+		}
+	}
+	if tags == "" {
+		return ""
+	}
+	return "|#" + tags[1:]
+}
+
+func (p *provider) record(ctx context.Context, namePrefix string, metrics map[string]interface{}) {
+	tags := p.contextTags(ctx)
+	for _, key := range sortedKeys(metrics) {
+		value, metricType, ok := numeric(metrics[key])
+		if !ok {
+			continue
+		}
+		if configured, ok := p.config.Types[namePrefix+key]; ok {
+			metricType = configured
+		}
+		name := sanitizeName(p.config.Prefix + namePrefix + key)
+		if metricType == Gauge && value < 0 {
+			// A signed gauge value is a change rather than a new value, so reset it first
+			p.add([]byte(name + ":0|g" + tags))
+		}
+		p.add([]byte(name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + string(metricType) + tags))
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// The numeric value of a metric, and its default type
+func numeric(val interface{}) (float64, MetricType, bool) {
+	switch v := val.(type) {
+	case time.Duration:
+		return float64(v) / float64(time.Millisecond), Timing, true
+	case int:
+		return float64(v), Gauge, true
+	case int8:
+		return float64(v), Gauge, true
+	case int16:
+		return float64(v), Gauge, true
+	case int32:
+		return float64(v), Gauge, true
+	case int64:
+		return float64(v), Gauge, true
+	case uint:
+		return float64(v), Gauge, true
+	case uint8:
+		return float64(v), Gauge, true
+	case uint16:
+		return float64(v), Gauge, true
+	case uint32:
+		return float64(v), Gauge, true
+	case uint64:
+		return float64(v), Gauge, true
+	case float32:
+		return float64(v), Gauge, true
+	case float64:
+		return v, Gauge, true
+	}
+	return 0, "", false
+}
+
+// Characters with meaning in the StatsD protocol can't appear in names
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '\n', '\r':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func sanitizeTag(tag string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', ',', ' ', '\n', '\r':
+			return '_'
+		}
+		return r
+	}, tag)
+}
+
+// Event titles and text can't contain newlines, or pipes which would end them
+var eventEscaper = strings.NewReplacer("\n", `\n`, "|", "_")
+
+// A DogStatsD event: _e{title length,text length}:title|text|#tags
+func (p *provider) event(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	var text []string
+	for _, key := range sortedKeys(metrics) {
+		text = append(text, fmt.Sprintf("%s=%v", key, metrics[key]))
+	}
+	title := eventEscaper.Replace(p.config.Prefix + eventName)
+	body := eventEscaper.Replace(strings.Join(text, "\n"))
+	p.add([]byte(fmt.Sprintf("_e{%d,%d}:%s|%s%s", len(title), len(body), title, body, p.contextTags(ctx))))
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName+".", metrics)
+	if p.config.Events {
+		p.event(ctx, eventName, metrics)
+	}
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
+
+func (p *provider) Wait() {
+	p.batcher.Flush()
+	p.LogProvider.Wait()
+}
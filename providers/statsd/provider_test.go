@@ -0,0 +1,188 @@
+package statsd
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	. "github.com/onsi/gomega"
+)
+
+var testProvider providers.LogProvider
+
+// Packets received by the test listener
+var received chan string
+
+func listen(t *testing.T, network, address string) string {
+	listener, err := net.ListenPacket(network, address)
+	Expect(err).To(BeNil())
+	t.Cleanup(func() { listener.Close() })
+	received = make(chan string, 100)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, _, err := listener.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			received <- string(buf[:n])
+		}
+	}()
+	return listener.LocalAddr().String()
+}
+
+func setup(t *testing.T, config Config) {
+	RegisterTestingT(t)
+
+	if config.Address == "" {
+		config.Address = listen(t, "udp", "127.0.0.1:0")
+	}
+	if config.FlushInterval == 0 {
+		// Only flush from Wait, to make packets predictable
+		config.FlushInterval = time.Hour
+	}
+	provider, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	testProvider = provider
+}
+
+// Wait, then return all the packets sent
+func packets() []string {
+	testProvider.Wait()
+	var result []string
+	for {
+		select {
+		case packet := <-received:
+			result = append(result, packet)
+		case <-time.After(100 * time.Millisecond):
+			return result
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	setup(t, Config{Prefix: "svc.", Types: map[string]MetricType{"requests": Count, "upload.elapsed": Gauge}})
+
+	ctx := context.Background()
+	testProvider.Record(ctx, log.Metrics{
+		"requests": 1,
+		"load":     0.75,
+		"latency":  1500 * time.Microsecond,
+		"status":   "ok",
+	})
+	testProvider.RecordEvent(ctx, "upload", log.Metrics{"bytes": uint64(1024), "elapsed": 2 * time.Second})
+	Expect(packets()).To(Equal([]string{strings.Join([]string{
+		"svc.latency:1.5|ms",
+		"svc.load:0.75|g",
+		"svc.requests:1|c",
+		"svc.upload.bytes:1024|g",
+		"svc.upload.elapsed:2000|g",
+	}, "\n")}))
+}
+
+func TestNegativeGauge(t *testing.T) {
+	setup(t, Config{})
+
+	testProvider.Record(context.Background(), log.Metrics{"temperature": -5})
+	Expect(packets()).To(Equal([]string{"temperature:0|g\ntemperature:-5|g"}))
+}
+
+func TestTags(t *testing.T) {
+	setup(t, Config{TagFields: []string{"region", "route"}, Tags: []string{"env:test"}})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		"region":    "us-east-1",
+		"route":     "/orders, all",
+		"requestId": "abc",
+	})
+	testProvider.Record(ctx, log.Metrics{"bad:name|x": 1})
+	testProvider.Record(context.Background(), log.Metrics{"untagged": 2})
+	Expect(packets()).To(Equal([]string{
+		"bad_name_x:1|g|#env:test,region:us-east-1,route:/orders__all\n" +
+			"untagged:2|g|#env:test",
+	}))
+}
+
+func TestEvents(t *testing.T) {
+	setup(t, Config{Events: true, TagFields: []string{"region"}})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"region": "eu"})
+	testProvider.RecordEvent(ctx, "deploy", log.Metrics{"version": "1.2.3", "hosts": 3})
+	Expect(packets()).To(Equal([]string{
+		"deploy.hosts:3|g|#region:eu\n" +
+			`_e{6,22}:deploy|hosts=3\nversion=1.2.3|#region:eu`,
+	}))
+}
+
+func TestPacketSize(t *testing.T) {
+	setup(t, Config{MaxPacketSize: 31})
+
+	// Each line is 15 bytes, so two fit in a packet with the newline between them
+	for _, name := range []string{"metric_a", "metric_b", "metric_c", "metric_d", "metric_e"} {
+		testProvider.Record(context.Background(), log.Metrics{name: 1000})
+	}
+	Expect(packets()).To(Equal([]string{
+		"metric_a:1000|g\nmetric_b:1000|g",
+		"metric_c:1000|g\nmetric_d:1000|g",
+		"metric_e:1000|g",
+	}))
+}
+
+func TestFlushInterval(t *testing.T) {
+	setup(t, Config{FlushInterval: 20 * time.Millisecond})
+
+	testProvider.Record(context.Background(), log.Metrics{"ticks": 1})
+	Eventually(received).Should(Receive(Equal("ticks:1|g")))
+}
+
+func TestUnixgram(t *testing.T) {
+	RegisterTestingT(t)
+	// Socket paths have to be short, so not t.TempDir
+	dir, err := ioutil.TempDir("", "statsd")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dsd.socket")
+	listen(t, "unixgram", path)
+	setup(t, Config{Network: "unixgram", Address: path})
+
+	testProvider.Record(context.Background(), log.Metrics{"local": 1})
+	Expect(packets()).To(Equal([]string{"local:1|g"}))
+}
+
+func TestConfigErrors(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := LogProvider(nil, Config{Types: map[string]MetricType{"x": "h"}})
+	Expect(err).To(MatchError(`statsd: metric "x" has unknown type "h" (expected g, c or ms)`))
+	_, err = LogProvider(nil, Config{Network: "tcp"})
+	Expect(err).To(MatchError(`statsd: unsupported network "tcp" (expected udp or unixgram)`))
+	_, err = LogProvider(nil, Config{Network: "unixgram", Address: "/nonexistent/socket"})
+	Expect(err).To(MatchError(ContainSubstring("statsd: dial unixgram /nonexistent/socket")))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+	address := listen(t, "udp", "127.0.0.1:0")
+
+	provider, err := config.Build([]byte(`
+providers:
+  - name: statsd
+    options:
+      address: ` + address + `
+      prefix: configured.
+      tags: env:test
+      types: {hits: c}
+`))
+	Expect(err).To(BeNil())
+	testProvider = provider
+	provider.Record(context.Background(), log.Metrics{"hits": 3})
+	Expect(packets()).To(Equal([]string{"configured.hits:3|c|#env:test"}))
+}
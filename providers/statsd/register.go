@@ -0,0 +1,31 @@
+package statsd
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+)
+
+func init() {
+	config.Register("statsd", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := Config{
+			Network:       options.String("network", ""),
+			Address:       options.String("address", ""),
+			Prefix:        options.String("prefix", ""),
+			TagFields:     options.Strings("tagFields", nil),
+			Tags:          options.Strings("tags", nil),
+			Events:        options.Bool("events", false),
+			MaxPacketSize: options.Int("maxPacketSize", 0),
+			FlushInterval: options.Duration("flushInterval", 0),
+		}
+		if types := options.StringMap("types"); len(types) > 0 {
+			providerConfig.Types = make(map[string]MetricType)
+			for name, metricType := range types {
+				providerConfig.Types[name] = MetricType(metricType)
+			}
+		}
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+		return LogProvider(nextProvider, providerConfig)
+	})
+}
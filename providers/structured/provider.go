@@ -35,7 +35,7 @@ type StructuredOutputLogProvider struct {
 }
 
 // Return list of log calls, filtered to only selected levels (if any present)
-func (p StructuredOutputLogProvider) LogCalls(levels ...providers.LogLevel) (result []*LogCallArgs) {
+func (p *StructuredOutputLogProvider) LogCalls(levels ...providers.LogLevel) (result []*LogCallArgs) {
 	p.logMutex.RLock()
 	defer p.logMutex.RUnlock()
 
@@ -55,7 +55,7 @@ func (p StructuredOutputLogProvider) LogCalls(levels ...providers.LogLevel) (res
 	return
 }
 
-func (p StructuredOutputLogProvider) RecordCalls() []*RecordCallArgs {
+func (p *StructuredOutputLogProvider) RecordCalls() []*RecordCallArgs {
 	p.recordMutex.RLock()
 	defer p.recordMutex.RUnlock()
 
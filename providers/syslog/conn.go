@@ -0,0 +1,104 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Where local syslog daemons listen, in the same order as log/syslog tries them
+var localSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+type conn struct {
+	network, address string
+	tlsConfig        *tls.Config
+	timeout          time.Duration
+	// Stream transports need each message prefixed with its length
+	framed bool
+
+	c net.Conn
+}
+
+func dial(config Config) (*conn, error) {
+	c := &conn{
+		network:   config.Network,
+		address:   config.Address,
+		tlsConfig: config.TLSConfig,
+		timeout:   config.Timeout,
+	}
+	switch c.network {
+	case "udp", "udp4", "udp6", "unixgram":
+	case "tcp", "tcp4", "tcp6", "tls":
+		c.framed = true
+	case "":
+		if c.address != "" {
+			return nil, fmt.Errorf("syslog: no network configured for address %q", c.address)
+		}
+		return c, c.dialLocal()
+	default:
+		return nil, fmt.Errorf("syslog: unsupported network %q (expected udp, tcp, tls or unixgram)", c.network)
+	}
+	if c.address == "" {
+		return nil, fmt.Errorf("syslog: no address configured for network %q", c.network)
+	}
+	return c, c.dial()
+}
+
+func (c *conn) dialLocal() error {
+	var err error
+	for _, path := range localSockets {
+		c.network, c.address = "unixgram", path
+		if err = c.dial(); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("syslog: no local syslog daemon found, %v", err)
+}
+
+func (c *conn) dial() (err error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	if c.network == "tls" {
+		c.c, err = tls.DialWithDialer(dialer, "tcp", c.address, c.tlsConfig)
+	} else {
+		c.c, err = dialer.Dial(c.network, c.address)
+	}
+	return err
+}
+
+func (c *conn) writeOnce(msg []byte) error {
+	if c.c == nil {
+		if err := c.dial(); err != nil {
+			return err
+		}
+	}
+	if err := c.c.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if c.framed {
+		framed := make([]byte, 0, len(msg)+8)
+		framed = strconv.AppendInt(framed, int64(len(msg)), 10)
+		framed = append(framed, ' ')
+		msg = append(framed, msg...)
+	}
+	_, err := c.c.Write(msg)
+	return err
+}
+
+// Write a message, reconnecting and trying once more if that fails
+func (c *conn) write(msg []byte) error {
+	err := c.writeOnce(msg)
+	if err == nil {
+		return nil
+	}
+	var netErr net.Error
+	if c.c != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		// A timeout probably means the server is overloaded, and retrying would only make it worse
+		c.c.Close()
+		c.c = nil
+		err = c.writeOnce(msg)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package syslog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Header fields are printable ASCII with a maximum length, or "-" for none
+func headerField(s string, maxLength int) string {
+	if s == "" {
+		return "-"
+	}
+	var b strings.Builder
+	for i := 0; i < len(s) && b.Len() < maxLength; i++ {
+		if c := s[i]; c > ' ' && c < 0x7f {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// PARAM-NAME is like a header field, but also excluding '=', ']' and '"'
+func paramName(key string) string {
+	name := []byte(headerField(key, 32))
+	for i, c := range name {
+		if c == '=' || c == ']' || c == '"' {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// Append an SD-ELEMENT, e.g. [fields@32473 a="1" b="2"]
+func appendElement(buf []byte, id string, params map[string]interface{}, keys []string) []byte {
+	buf = append(buf, '[')
+	buf = append(buf, id...)
+	for _, key := range keys {
+		buf = append(buf, ' ')
+		buf = append(buf, paramName(key)...)
+		buf = append(buf, '=', '"')
+		for _, c := range []byte(formatValue(params[key])) {
+			if c == '"' || c == '\\' || c == ']' {
+				buf = append(buf, '\\')
+			}
+			buf = append(buf, c)
+		}
+		buf = append(buf, '"')
+	}
+	return append(buf, ']')
+}
+
+func formatValue(val interface{}) (result string) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = fmt.Sprintf("!PANIC(%T): %v", val, r)
+		}
+	}()
+
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprintf("%+v", val)
+}
@@ -0,0 +1,59 @@
+package syslog
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Facility int
+
+const (
+	Kern Facility = iota
+	User
+	Mail
+	Daemon
+	Auth
+	Syslog
+	LPR
+	News
+	UUCP
+	Cron
+	AuthPriv
+	FTP
+	NTP
+	Security
+	Console
+	SolarisCron
+	Local0
+	Local1
+	Local2
+	Local3
+	Local4
+	Local5
+	Local6
+	Local7
+)
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv",
+	"ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+func (f Facility) String() string {
+	if f >= 0 && int(f) < len(facilityNames) {
+		return facilityNames[f]
+	}
+	return fmt.Sprintf("Facility(%d)", int(f))
+}
+
+// ParseFacility accepts the names returned by String, case-insensitively
+func ParseFacility(name string) (Facility, error) {
+	name = strings.ToLower(name)
+	for facility, facilityName := range facilityNames {
+		if name == facilityName {
+			return Facility(facility), nil
+		}
+	}
+	return Kern, fmt.Errorf("not a valid syslog facility: %q", name)
+}
@@ -0,0 +1,218 @@
+/*
+This package provides RFC 5424 syslog output, over UDP, TCP (with RFC 6587 octet-counting framing),
+TLS (RFC 5425), or a unix datagram socket. Context fields are sent as structured data, so
+
+	log.ContextWithFields(ctx, log.Fields{"requestId": "abc"})
+
+is written as
+
+	<14>1 2019-04-01T12:00:00.000000Z host app 1234 - [fields@32473 requestId="abc"] message
+
+Connections are made when the provider is created, and remade if a write fails.
+*/
+package syslog
+
+import (
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// "udp", "tcp", "tls" or "unixgram"; if both this and Address are empty, the local syslog
+	// daemon's socket is used
+	Network string
+	Address string
+	// For the "tls" network; nil uses the system roots, and the host from Address as ServerName
+	TLSConfig *tls.Config
+	// For dialing and for each write; defaults to 5s
+	Timeout time.Duration
+
+	Level string
+	// Defaults to User, since Kern (0) is reserved for the kernel
+	Facility Facility
+	// Defaults to the program name
+	AppName string
+	// Defaults to os.Hostname
+	Hostname string
+	// IANA Private Enterprise Number used in the structured data IDs (fields@N and metrics@N);
+	// defaults to 32473, which is reserved for documentation, so set your own if you have one
+	EnterpriseNumber int
+}
+
+const DefaultEnterpriseNumber = 32473
+
+type provider struct {
+	providers.LogProvider
+	level    providers.LogLevel
+	facility Facility
+	// Fixed header fields, already sanitized
+	hostname, appName, procID string
+	fieldsID, metricsID       string
+
+	writeMutex sync.Mutex
+	conn       *conn
+
+	// For tests
+	now func() time.Time
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	level, err := providers.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config.Facility == Kern {
+		config.Facility = User
+	}
+	if config.Facility < Kern || config.Facility > Local7 {
+		return nil, fmt.Errorf("syslog: not a valid facility: %d", config.Facility)
+	}
+	if config.AppName == "" {
+		config.AppName = filepath.Base(os.Args[0])
+	}
+	if config.Hostname == "" {
+		config.Hostname, _ = os.Hostname()
+	}
+	if config.EnterpriseNumber == 0 {
+		config.EnterpriseNumber = DefaultEnterpriseNumber
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	c, err := dial(config)
+	if err != nil {
+		return nil, err
+	}
+	return &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		level:       level,
+		facility:    config.Facility,
+		hostname:    headerField(config.Hostname, 255),
+		appName:     headerField(config.AppName, 48),
+		procID:      strconv.Itoa(os.Getpid()),
+		fieldsID:    "fields@" + strconv.Itoa(config.EnterpriseNumber),
+		metricsID:   "metrics@" + strconv.Itoa(config.EnterpriseNumber),
+		conn:        c,
+		now:         time.Now,
+	}, nil
+}
+
+var severities = map[providers.LogLevel]int{
+	providers.Error: 3, // err
+	providers.Warn:  4, // warning
+	providers.Info:  6, // informational
+	providers.Debug: 7, // debug
+}
+
+// RFC 5424 allows at most 6 digits of fractional seconds
+const timeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+func (p *provider) format(
+	level providers.LogLevel,
+	msgID string,
+	ctx context.Context,
+	metrics map[string]interface{},
+	msg string,
+) []byte {
+	buf := make([]byte, 0, 256)
+	buf = append(buf, '<')
+	buf = strconv.AppendInt(buf, int64(int(p.facility)*8+severities[level]), 10)
+	buf = append(buf, ">1 "...)
+	buf = p.now().AppendFormat(buf, timeFormat)
+	buf = append(buf, ' ')
+	buf = append(buf, p.hostname...)
+	buf = append(buf, ' ')
+	buf = append(buf, p.appName...)
+	buf = append(buf, ' ')
+	buf = append(buf, p.procID...)
+	buf = append(buf, ' ')
+	buf = append(buf, headerField(msgID, 32)...)
+	buf = append(buf, ' ')
+
+	fields := log.FieldsFromContext(ctx)
+	if len(fields) == 0 && len(metrics) == 0 {
+		buf = append(buf, '-')
+	}
+	if len(fields) > 0 {
+		buf = appendElement(buf, p.fieldsID, fields, log.OrderedKeys(ctx))
+	}
+	if len(metrics) > 0 {
+		metricKeys := make([]string, 0, len(metrics))
+		for key := range metrics {
+			metricKeys = append(metricKeys, key)
+		}
+		sort.Strings(metricKeys)
+		buf = appendElement(buf, p.metricsID, metrics, metricKeys)
+	}
+
+	if msg != "" {
+		buf = append(buf, ' ')
+		buf = append(buf, msg...)
+	}
+	return buf
+}
+
+func (p *provider) write(msg []byte) {
+	p.writeMutex.Lock()
+	err := p.conn.write(msg)
+	p.writeMutex.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write to syslog, %v\n", err)
+	}
+}
+
+func (p *provider) log(ctx context.Context, level providers.LogLevel, args []interface{}) {
+	if level > p.level {
+		return
+	}
+	p.write(p.format(level, "", ctx, nil, fmt.Sprint(args...)))
+}
+
+func (p *provider) record(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	if providers.Info > p.level {
+		return
+	}
+	p.write(p.format(providers.Info, eventName, ctx, metrics, "Reporting metrics"))
+}
+
+func (p *provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Error, args)
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Warn, args)
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Info, args)
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Debug, args)
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName, metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
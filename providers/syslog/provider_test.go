@@ -0,0 +1,319 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	. "github.com/onsi/gomega"
+)
+
+var testProvider providers.LogProvider
+
+// Messages received by the test listener
+var received chan string
+
+func setup(t *testing.T, config Config) {
+	RegisterTestingT(t)
+
+	if config.Level == "" {
+		config.Level = "debug"
+	}
+	if config.AppName == "" {
+		config.AppName = "test"
+	}
+	if config.Hostname == "" {
+		config.Hostname = "host"
+	}
+	l, err := LogProvider(nil, config)
+	Expect(err).To(BeNil())
+	p := l.(*provider)
+	p.now = func() time.Time { return time.Date(2019, 4, 1, 12, 0, 0, 123456789, time.UTC) }
+	p.procID = "1234"
+	testProvider = p
+	t.Cleanup(func() {
+		if p.conn.c != nil {
+			p.conn.c.Close()
+		}
+	})
+}
+
+func listenPacket(t *testing.T, network, address string) string {
+	listener, err := net.ListenPacket(network, address)
+	Expect(err).To(BeNil())
+	t.Cleanup(func() { listener.Close() })
+	received = make(chan string, 10)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, _, err := listener.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			received <- string(buf[:n])
+		}
+	}()
+	return listener.LocalAddr().String()
+}
+
+// Read octet-counted messages from each connection accepted
+func listenStream(t *testing.T, listener net.Listener) string {
+	t.Cleanup(func() { listener.Close() })
+	received = make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					length, err := r.ReadString(' ')
+					if err != nil {
+						return
+					}
+					n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+					if err != nil {
+						received <- "bad frame length: " + length
+						return
+					}
+					msg := make([]byte, n)
+					if _, err := io.ReadFull(r, msg); err != nil {
+						return
+					}
+					received <- string(msg)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func receive() string {
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(2 * time.Second):
+		return "nothing received"
+	}
+}
+
+func TestUDP(t *testing.T) {
+	RegisterTestingT(t)
+	address := listenPacket(t, "udp", "127.0.0.1:0")
+	setup(t, Config{Network: "udp", Address: address})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"requestId": "abc"})
+	ctx = log.ContextWithFields(ctx, log.Fields{"user": 7})
+	testProvider.Info(ctx, false, "Hello ", "syslog")
+	Expect(receive()).To(Equal(`<14>1 2019-04-01T12:00:00.123456Z host test 1234 - [fields@32473 requestId="abc" user="7"] Hello syslog`))
+
+	testProvider.Error(context.Background(), false, "no fields")
+	Expect(receive()).To(Equal(`<11>1 2019-04-01T12:00:00.123456Z host test 1234 - - no fields`))
+}
+
+func TestSeverityAndFacility(t *testing.T) {
+	RegisterTestingT(t)
+	address := listenPacket(t, "udp", "127.0.0.1:0")
+	setup(t, Config{Network: "udp", Address: address, Facility: Local3, Level: "info"})
+
+	ctx := context.Background()
+	testProvider.Debug(ctx, false, "hidden")
+	testProvider.Info(ctx, false, "info")
+	testProvider.Warn(ctx, false, "warn")
+	testProvider.Error(ctx, true, "error")
+	for _, pri := range []string{"<158>", "<156>", "<155>"} {
+		Expect(receive()).To(HavePrefix(pri))
+	}
+	Expect(received).To(BeEmpty())
+
+	_, err := LogProvider(nil, Config{Network: "udp", Address: address, Level: "info", Facility: 24})
+	Expect(err).To(MatchError("syslog: not a valid facility: 24"))
+	facility, err := ParseFacility("LOCAL3")
+	Expect(err).To(BeNil())
+	Expect(facility).To(Equal(Local3))
+	Expect(facility.String()).To(Equal("local3"))
+}
+
+func TestEscaping(t *testing.T) {
+	RegisterTestingT(t)
+	address := listenPacket(t, "udp", "127.0.0.1:0")
+	setup(t, Config{Network: "udp", Address: address, AppName: "my app", Hostname: strings.Repeat("h", 300)})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		`quote"d`:                            `say "hi" [here]\now`,
+		"equals=]":                           nil,
+		"a_very_long_key_name_which_goes_on": "x",
+	})
+	testProvider.Info(ctx, false, "escaped")
+	msg := receive()
+	Expect(msg).To(HavePrefix(`<14>1 2019-04-01T12:00:00.123456Z ` + strings.Repeat("h", 255) + " my_app 1234 - "))
+	Expect(msg).To(HaveSuffix(`[fields@32473 a_very_long_key_name_which_goes_="x" equals__="" quote_d="say \"hi\" [here\]\\now"] escaped`))
+}
+
+func TestRecord(t *testing.T) {
+	RegisterTestingT(t)
+	address := listenPacket(t, "udp", "127.0.0.1:0")
+	setup(t, Config{Network: "udp", Address: address, EnterpriseNumber: 99999})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"requestId": "abc"})
+	testProvider.Record(ctx, log.Metrics{"count": 2, "bytes": 1024})
+	Expect(receive()).To(Equal(`<14>1 2019-04-01T12:00:00.123456Z host test 1234 - [fields@99999 requestId="abc"][metrics@99999 bytes="1024" count="2"] Reporting metrics`))
+	testProvider.RecordEvent(context.Background(), "File Upload", log.Metrics{"elapsed": 1500 * time.Millisecond})
+	Expect(receive()).To(Equal(`<14>1 2019-04-01T12:00:00.123456Z host test 1234 File_Upload [metrics@99999 elapsed="1.5s"] Reporting metrics`))
+}
+
+func TestTCP(t *testing.T) {
+	RegisterTestingT(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	address := listenStream(t, listener)
+	setup(t, Config{Network: "tcp", Address: address})
+
+	testProvider.Info(context.Background(), false, "first")
+	testProvider.Warn(context.Background(), false, "multi\nline")
+	Expect(receive()).To(HaveSuffix(" - - first"))
+	Expect(receive()).To(HaveSuffix(" - - multi\nline"))
+}
+
+func TestTLS(t *testing.T) {
+	RegisterTestingT(t)
+	// Borrow httptest's certificate, which is valid for 127.0.0.1
+	server := httptest.NewTLSServer(nil)
+	certificate := server.TLS.Certificates[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	server.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	Expect(err).To(BeNil())
+	address := listenStream(t, listener)
+	setup(t, Config{Network: "tls", Address: address, TLSConfig: &tls.Config{RootCAs: roots}})
+
+	testProvider.Info(context.Background(), false, "secret")
+	Expect(receive()).To(HaveSuffix(" - - secret"))
+
+	_, err = LogProvider(nil, Config{Network: "tls", Address: address, Level: "info"})
+	Expect(err).To(HaveOccurred())
+}
+
+func TestUnixgram(t *testing.T) {
+	RegisterTestingT(t)
+	// Socket paths have to be short, so not t.TempDir
+	dir, err := ioutil.TempDir("", "syslog")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	listenPacket(t, "unixgram", path)
+	setup(t, Config{Network: "unixgram", Address: path})
+
+	testProvider.Info(context.Background(), false, "local")
+	Expect(receive()).To(HaveSuffix(" - - local"))
+}
+
+func TestReconnect(t *testing.T) {
+	RegisterTestingT(t)
+	dir, err := ioutil.TempDir("", "syslog")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	listener, err := net.ListenPacket("unixgram", path)
+	Expect(err).To(BeNil())
+	setup(t, Config{Network: "unixgram", Address: path})
+
+	// As if the syslog daemon had restarted
+	listener.Close()
+	os.Remove(path)
+	listenPacket(t, "unixgram", path)
+	testProvider.Info(context.Background(), false, "after restart")
+	Expect(receive()).To(HaveSuffix(" - - after restart"))
+}
+
+func TestReconnectTCP(t *testing.T) {
+	RegisterTestingT(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	setup(t, Config{Network: "tcp", Address: listener.Addr().String()})
+
+	// The server drops the connection; the first write may still appear to succeed, but one soon
+	// fails and the provider reconnects
+	(<-accepted).Close()
+	Eventually(func() int {
+		testProvider.Info(context.Background(), false, "retry")
+		return len(accepted)
+	}, 2*time.Second, 10*time.Millisecond).Should(Equal(1))
+	conn := <-accepted
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('y')
+	Expect(err).To(BeNil())
+	Expect(line).To(HaveSuffix(" - - retry"))
+}
+
+func TestConfigErrors(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, c := range []struct {
+		config  Config
+		problem string
+	}{
+		{Config{Network: "carrier-pigeon", Address: "coop"}, `syslog: unsupported network "carrier-pigeon" (expected udp, tcp, tls or unixgram)`},
+		{Config{Network: "udp"}, `syslog: no address configured for network "udp"`},
+		{Config{Address: "127.0.0.1:514"}, `syslog: no network configured for address "127.0.0.1:514"`},
+	} {
+		c.config.Level = "info"
+		_, err := LogProvider(nil, c.config)
+		Expect(err).To(MatchError(c.problem))
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+	address := listenPacket(t, "udp", "127.0.0.1:0")
+
+	provider, err := config.Build([]byte(`
+providers:
+  - name: syslog
+    options:
+      network: udp
+      address: ` + address + `
+      facility: local0
+      appName: configured
+`))
+	Expect(err).To(BeNil())
+	provider.Warn(context.Background(), false, "from config")
+	Expect(receive()).To(MatchRegexp(`^<132>1 \S+ \S+ configured \d+ - - from config$`))
+
+	_, err = config.Build([]byte(`
+providers:
+  - name: syslog
+    options: {network: udp, address: "` + address + `", facility: local9}`))
+	Expect(err).To(MatchError(`config: providers[0] (syslog): option "facility": not a valid syslog facility: "local9"`))
+}
@@ -0,0 +1,48 @@
+package syslog
+
+import (
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+)
+
+func init() {
+	config.Register("syslog", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		providerConfig := Config{
+			Network:          options.String("network", ""),
+			Address:          options.String("address", ""),
+			Timeout:          options.Duration("timeout", 0),
+			Level:            options.String("level", "info"),
+			AppName:          options.String("appName", ""),
+			Hostname:         options.String("hostname", ""),
+			EnterpriseNumber: options.Int("enterpriseNumber", 0),
+		}
+		if name := options.String("facility", ""); name != "" {
+			facility, err := ParseFacility(name)
+			if err != nil {
+				options.Errorf("option \"facility\": %v", err)
+			}
+			providerConfig.Facility = facility
+		}
+		if caFile := options.String("caFile", ""); caFile != "" {
+			pem, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				options.Errorf("option \"caFile\": %v", err)
+			} else {
+				roots := x509.NewCertPool()
+				if !roots.AppendCertsFromPEM(pem) {
+					options.Errorf("option \"caFile\": no certificates found in %s", caFile)
+				}
+				providerConfig.TLSConfig = &tls.Config{RootCAs: roots}
+			}
+		}
+		if err := options.Err(); err != nil {
+			// Don't go dialing with a half-valid configuration
+			return nil, err
+		}
+		return LogProvider(nextProvider, providerConfig)
+	})
+}
@@ -0,0 +1,199 @@
+/*
+This package logs through a zap Logger (go.uber.org/zap), for services that already use zap's
+encoders and sinks. Context fields become typed zap fields, so the common types (strings, numbers,
+bools, durations, times and errors) are encoded without reflection; anything else goes through
+zap.Any. Levels are filtered by the Logger's own level.
+
+{Error,Warn,Info,Debug}Report calls get a "report": true field, and a stack trace from the merry
+provider becomes the entry's stack trace, under the encoder's StacktraceKey. Record and RecordEvent
+are logged as "Reporting metrics" at info, with the metrics as fields. Wait syncs the Logger.
+*/
+package zap
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+type Config struct {
+	// Required
+	Logger *zap.Logger
+}
+
+// Added to {Error,Warn,Info,Debug}Report calls
+const reportField = "report"
+
+// From the merry provider
+const stackTraceField = "~stackTrace"
+
+var levels = map[providers.LogLevel]zapcore.Level{
+	providers.Debug: zapcore.DebugLevel,
+	providers.Info:  zapcore.InfoLevel,
+	providers.Warn:  zapcore.WarnLevel,
+	providers.Error: zapcore.ErrorLevel,
+}
+
+type provider struct {
+	providers.LogProvider
+	logger *zap.Logger
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	if config.Logger == nil {
+		return nil, fmt.Errorf("zap: Logger is required")
+	}
+	return &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		logger:      config.Logger,
+	}, nil
+}
+
+// A typed zap field for common types, so zap doesn't need reflection to encode them
+func field(key string, val interface{}) zap.Field {
+	switch v := val.(type) {
+	case string:
+		return zap.String(key, v)
+	case int:
+		return zap.Int(key, v)
+	case int64:
+		return zap.Int64(key, v)
+	case int32:
+		return zap.Int32(key, v)
+	case int16:
+		return zap.Int16(key, v)
+	case int8:
+		return zap.Int8(key, v)
+	case uint:
+		return zap.Uint(key, v)
+	case uint64:
+		return zap.Uint64(key, v)
+	case uint32:
+		return zap.Uint32(key, v)
+	case uint16:
+		return zap.Uint16(key, v)
+	case uint8:
+		return zap.Uint8(key, v)
+	case float64:
+		return zap.Float64(key, v)
+	case float32:
+		return zap.Float32(key, v)
+	case bool:
+		return zap.Bool(key, v)
+	case time.Duration:
+		return zap.Duration(key, v)
+	case time.Time:
+		return zap.Time(key, v)
+	case error:
+		return zap.NamedError(key, v)
+	case fmt.Stringer:
+		return zap.Stringer(key, v)
+	}
+	return zap.Any(key, val)
+}
+
+// Fields in the order they were added, leaving out any that are in skip
+func (p *provider) fields(ctx context.Context, skip map[string]interface{}, extra int) []zap.Field {
+	fields := log.FieldsFromContext(ctx)
+	keys := log.OrderedKeys(ctx)
+	zapFields := make([]zap.Field, 0, len(keys)+extra)
+	for _, key := range keys {
+		if key == stackTraceField {
+			continue
+		}
+		if _, skipped := skip[key]; !skipped {
+			zapFields = append(zapFields, field(key, fields[key]))
+		}
+	}
+	return zapFields
+}
+
+func (p *provider) log(ctx context.Context, level providers.LogLevel, report bool, args []interface{}) {
+	zapLevel := levels[level]
+	// Checked first, so disabled levels cost nothing more
+	if !p.logger.Core().Enabled(zapLevel) {
+		return
+	}
+	entry := p.logger.Check(zapLevel, fmt.Sprint(args...))
+	if entry == nil {
+		return
+	}
+	if trace, ok := log.FieldsFromContext(ctx)[stackTraceField].(string); ok && trace != "" {
+		entry.Stack = trace
+	}
+	fields := p.fields(ctx, nil, 1)
+	if report {
+		fields = append(fields, zap.Bool(reportField, true))
+	}
+	entry.Write(fields...)
+}
+
+func (p *provider) record(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	entry := p.logger.Check(zapcore.InfoLevel, "Reporting metrics")
+	if entry == nil {
+		return
+	}
+	if eventName != "" {
+		ctx = log.ContextWithFields(ctx, log.Fields{"eventName": eventName})
+	}
+	// Metrics take precedence over fields of the same name
+	fields := p.fields(ctx, metrics, len(metrics))
+	metricKeys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		metricKeys = append(metricKeys, key)
+	}
+	sort.Strings(metricKeys)
+	for _, key := range metricKeys {
+		fields = append(fields, field(key, metrics[key]))
+	}
+	entry.Write(fields...)
+}
+
+func (p *provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Error, report, args)
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Warn, report, args)
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Info, report, args)
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Debug, report, args)
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName, metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
+
+func (p *provider) Wait() {
+	// Terminals and pipes can't be synced, which isn't worth complaining about
+	if err := p.logger.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTTY) {
+		fmt.Fprintf(os.Stderr, "Failed to sync zap logger, %v\n", err)
+	}
+	p.LogProvider.Wait()
+}
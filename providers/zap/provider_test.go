@@ -0,0 +1,219 @@
+package zap
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	cl_logrus "github.com/myhelix/contextlogger/providers/logrus"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var observed *observer.ObservedLogs
+var testProvider providers.LogProvider
+
+func setup(t *testing.T, level zapcore.Level) {
+	RegisterTestingT(t)
+
+	var core zapcore.Core
+	core, observed = observer.New(level)
+	provider, err := LogProvider(nil, Config{Logger: zap.New(core)})
+	Expect(err).To(BeNil())
+	testProvider = provider
+}
+
+func TestLevels(t *testing.T) {
+	setup(t, zapcore.InfoLevel)
+
+	ctx := context.Background()
+	testProvider.Error(ctx, true, "Broken ", 1)
+	testProvider.Warn(ctx, false, "Careful")
+	testProvider.Info(ctx, false, "Hello")
+	testProvider.Debug(ctx, false, "Filtered")
+
+	entries := observed.AllUntimed()
+	Expect(entries).To(HaveLen(3))
+	Expect(entries[0].Level).To(Equal(zapcore.ErrorLevel))
+	Expect(entries[0].Message).To(Equal("Broken 1"))
+	Expect(entries[0].ContextMap()).To(Equal(map[string]interface{}{"report": true}))
+	Expect(entries[1].Level).To(Equal(zapcore.WarnLevel))
+	Expect(entries[1].Context).To(BeEmpty())
+	Expect(entries[2].Level).To(Equal(zapcore.InfoLevel))
+	Expect(entries[2].Message).To(Equal("Hello"))
+}
+
+type stringer struct{}
+
+func (stringer) String() string { return "stringer" }
+
+func TestTypedFields(t *testing.T) {
+	setup(t, zapcore.DebugLevel)
+
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"user": "sam", "attempt": 3})
+	ctx = log.ContextWithFields(ctx, log.Fields{
+		"ok":      false,
+		"elapsed": time.Second,
+		"ratio":   0.5,
+		"at":      at,
+		"error":   errors.New("it broke"),
+		"small":   uint8(7),
+		"name":    stringer{},
+		"tags":    []string{"a", "b"},
+	})
+	testProvider.Debug(ctx, false, "Typed")
+
+	entries := observed.AllUntimed()
+	Expect(entries).To(HaveLen(1))
+	types := map[string]zapcore.FieldType{}
+	var keys []string
+	for _, f := range entries[0].Context {
+		types[f.Key] = f.Type
+		keys = append(keys, f.Key)
+	}
+	// In the order they were added
+	Expect(keys).To(Equal([]string{"attempt", "user", "at", "elapsed", "error", "name", "ok", "ratio", "small", "tags"}))
+	Expect(types).To(Equal(map[string]zapcore.FieldType{
+		"user":    zapcore.StringType,
+		"attempt": zapcore.Int64Type,
+		"ok":      zapcore.BoolType,
+		"elapsed": zapcore.DurationType,
+		"ratio":   zapcore.Float64Type,
+		"at":      zapcore.TimeType,
+		"error":   zapcore.ErrorType,
+		"small":   zapcore.Uint8Type,
+		"name":    zapcore.StringerType,
+		"tags":    zapcore.ArrayMarshalerType,
+	}))
+	Expect(entries[0].ContextMap()["error"]).To(Equal("it broke"))
+}
+
+func TestStackTrace(t *testing.T) {
+	setup(t, zapcore.DebugLevel)
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"~stackTrace": "main.main()\n\tmain.go:12", "user": "sam"})
+	testProvider.Error(ctx, true, "Broken")
+
+	entries := observed.AllUntimed()
+	Expect(entries).To(HaveLen(1))
+	Expect(entries[0].Stack).To(Equal("main.main()\n\tmain.go:12"))
+	Expect(entries[0].ContextMap()).To(Equal(map[string]interface{}{"user": "sam", "report": true}))
+}
+
+func TestRecord(t *testing.T) {
+	setup(t, zapcore.InfoLevel)
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"user": "sam", "bytes": "ignored"})
+	testProvider.Record(ctx, log.Metrics{"bytes": 1024, "elapsed": time.Second})
+	testProvider.RecordEvent(ctx, "upload", log.Metrics{"bytes": 2048})
+
+	entries := observed.AllUntimed()
+	Expect(entries).To(HaveLen(2))
+	Expect(entries[0].Message).To(Equal("Reporting metrics"))
+	Expect(entries[0].ContextMap()).To(Equal(map[string]interface{}{"user": "sam", "bytes": int64(1024), "elapsed": time.Second}))
+	Expect(entries[1].ContextMap()).To(Equal(map[string]interface{}{"user": "sam", "eventName": "upload", "bytes": int64(2048)}))
+
+	// Below the logger's level
+	setup(t, zapcore.WarnLevel)
+	testProvider.Record(ctx, log.Metrics{"bytes": 1024})
+	Expect(observed.Len()).To(BeZero())
+}
+
+type syncer struct {
+	zapcore.WriteSyncer
+	synced int
+}
+
+func (s *syncer) Sync() error {
+	s.synced++
+	return nil
+}
+
+func TestWaitSyncs(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &syncer{WriteSyncer: zapcore.AddSync(ioutil.Discard)}
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), s, zapcore.InfoLevel)
+	provider, err := LogProvider(nil, Config{Logger: zap.New(core)})
+	Expect(err).To(BeNil())
+	provider.Wait()
+	Expect(s.synced).To(Equal(1))
+
+	_, err = LogProvider(nil, Config{})
+	Expect(err).To(MatchError("zap: Logger is required"))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+
+	path := filepath.Join(t.TempDir(), "zap.log")
+	provider, err := config.Build([]byte(`
+providers:
+  - name: zap
+    options:
+      output: file://` + path + `
+      level: warn
+`))
+	Expect(err).To(BeNil())
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"user": "sam"})
+	provider.Info(ctx, false, "Filtered")
+	provider.Warn(ctx, true, "Written")
+	provider.Wait()
+	written, err := ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	Expect(string(written)).To(MatchRegexp(`^{"level":"warn","ts":"[^"]+","msg":"Written","user":"sam","report":true}\n$`))
+
+	_, err = config.Build([]byte(`{"providers": [{"name": "zap", "options": {"format": "xml"}}]}`))
+	Expect(err).To(MatchError(ContainSubstring(`option "format" must be one of json|console, not "xml"`)))
+}
+
+var benchmarkFields = log.Fields{
+	"user":    "sam",
+	"attempt": 3,
+	"ok":      false,
+	"elapsed": 0.25,
+	"error":   errors.New("it broke"),
+}
+
+func benchmarkProvider(b *testing.B, provider providers.LogProvider) {
+	ctx := log.ContextWithFields(context.Background(), benchmarkFields)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		provider.Info(ctx, false, "Something happened")
+	}
+}
+
+func BenchmarkZap(b *testing.B) {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(ioutil.Discard), zapcore.InfoLevel)
+	provider, _ := LogProvider(nil, Config{Logger: zap.New(core)})
+	benchmarkProvider(b, provider)
+}
+
+func BenchmarkLogrusJSONFormatter(b *testing.B) {
+	provider, _ := cl_logrus.LogProvider(nil, cl_logrus.Config{
+		Output:    ioutil.Discard,
+		Level:     "info",
+		Formatter: &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano},
+	})
+	benchmarkProvider(b, provider)
+}
+
+// Not included in the log at all, which zap makes cheap
+func BenchmarkZapDisabled(b *testing.B) {
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(ioutil.Discard), zapcore.WarnLevel)
+	provider, _ := LogProvider(nil, Config{Logger: zap.New(core)})
+	benchmarkProvider(b, provider)
+}
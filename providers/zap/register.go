@@ -0,0 +1,36 @@
+package zap
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"os"
+)
+
+func init() {
+	config.Register("zap", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		output := options.Writer("output", os.Stderr)
+		level, err := providers.ParseLevel(options.String("level", "info"))
+		if err != nil {
+			options.Errorf("option \"level\": %v", err)
+		}
+		format := options.OneOf("format", "json", "console")
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+
+		// zap's production settings, but with readable times
+		encoderConfig := zap.NewProductionEncoderConfig()
+		encoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+		encoder := zapcore.NewJSONEncoder(encoderConfig)
+		if format == "console" {
+			encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+			encoder = zapcore.NewConsoleEncoder(encoderConfig)
+		}
+		core := zapcore.NewCore(encoder, zapcore.AddSync(output), levels[level])
+		return LogProvider(nextProvider, Config{Logger: zap.New(core)})
+	})
+}
@@ -0,0 +1,181 @@
+/*
+This package logs through a zerolog Logger (github.com/rs/zerolog). Context fields are added with
+zerolog's typed methods (Str, Int, Dur, AnErr and so on), falling back to Interface for other
+types, and calls below the Logger's level return before any event is built, so they don't allocate.
+
+{Error,Warn,Info,Debug}Report calls get a "report": true field, and a stack trace from the merry
+provider is written under zerolog.ErrorStackFieldName. Record and RecordEvent are logged as
+"Reporting metrics" at info, with the metrics as fields.
+*/
+package zerolog
+
+import (
+	"github.com/rs/zerolog"
+
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Config struct {
+	// Required
+	Logger *zerolog.Logger
+}
+
+// Added to {Error,Warn,Info,Debug}Report calls
+const reportField = "report"
+
+// From the merry provider
+const stackTraceField = "~stackTrace"
+
+var levels = map[providers.LogLevel]zerolog.Level{
+	providers.Debug: zerolog.DebugLevel,
+	providers.Info:  zerolog.InfoLevel,
+	providers.Warn:  zerolog.WarnLevel,
+	providers.Error: zerolog.ErrorLevel,
+}
+
+type provider struct {
+	providers.LogProvider
+	logger *zerolog.Logger
+}
+
+func LogProvider(nextProvider providers.LogProvider, config Config) (providers.LogProvider, error) {
+	if config.Logger == nil {
+		return nil, fmt.Errorf("zerolog: Logger is required")
+	}
+	return &provider{
+		LogProvider: chaining.LogProvider(nextProvider),
+		logger:      config.Logger,
+	}, nil
+}
+
+// Add a field with zerolog's method for its type, so it's encoded without reflection
+func addField(event *zerolog.Event, key string, val interface{}) {
+	switch v := val.(type) {
+	case string:
+		event.Str(key, v)
+	case int:
+		event.Int(key, v)
+	case int64:
+		event.Int64(key, v)
+	case int32:
+		event.Int32(key, v)
+	case int16:
+		event.Int16(key, v)
+	case int8:
+		event.Int8(key, v)
+	case uint:
+		event.Uint(key, v)
+	case uint64:
+		event.Uint64(key, v)
+	case uint32:
+		event.Uint32(key, v)
+	case uint16:
+		event.Uint16(key, v)
+	case uint8:
+		event.Uint8(key, v)
+	case float64:
+		event.Float64(key, v)
+	case float32:
+		event.Float32(key, v)
+	case bool:
+		event.Bool(key, v)
+	case time.Duration:
+		event.Dur(key, v)
+	case time.Time:
+		event.Time(key, v)
+	case error:
+		event.AnErr(key, v)
+	case fmt.Stringer:
+		event.Stringer(key, v)
+	default:
+		event.Interface(key, v)
+	}
+}
+
+// Add the context fields in the order they were added, leaving out any that are in skip
+func addFields(event *zerolog.Event, ctx context.Context, skip map[string]interface{}) {
+	fields := log.FieldsFromContext(ctx)
+	keys := log.OrderedKeys(ctx)
+	for _, key := range keys {
+		if key == stackTraceField {
+			if trace, ok := fields[key].(string); ok {
+				event.Str(zerolog.ErrorStackFieldName, trace)
+				continue
+			}
+		}
+		if _, skipped := skip[key]; !skipped {
+			addField(event, key, fields[key])
+		}
+	}
+}
+
+func (p *provider) log(ctx context.Context, level providers.LogLevel, report bool, args []interface{}) {
+	// Nil if the level is disabled, in which case there's nothing more to do
+	event := p.logger.WithLevel(levels[level])
+	if event == nil {
+		return
+	}
+	addFields(event, ctx, nil)
+	if report {
+		event.Bool(reportField, true)
+	}
+	event.Msg(fmt.Sprint(args...))
+}
+
+func (p *provider) record(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	event := p.logger.Info()
+	if event == nil {
+		return
+	}
+	if eventName != "" {
+		ctx = log.ContextWithFields(ctx, log.Fields{"eventName": eventName})
+	}
+	// Metrics take precedence over fields of the same name
+	addFields(event, ctx, metrics)
+	metricKeys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		metricKeys = append(metricKeys, key)
+	}
+	sort.Strings(metricKeys)
+	for _, key := range metricKeys {
+		addField(event, key, metrics[key])
+	}
+	event.Msg("Reporting metrics")
+}
+
+func (p *provider) Error(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Error, report, args)
+	p.LogProvider.Error(ctx, report, args...)
+}
+
+func (p *provider) Warn(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Warn, report, args)
+	p.LogProvider.Warn(ctx, report, args...)
+}
+
+func (p *provider) Info(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Info, report, args)
+	p.LogProvider.Info(ctx, report, args...)
+}
+
+func (p *provider) Debug(ctx context.Context, report bool, args ...interface{}) {
+	p.log(ctx, providers.Debug, report, args)
+	p.LogProvider.Debug(ctx, report, args...)
+}
+
+func (p *provider) Record(ctx context.Context, metrics map[string]interface{}) {
+	p.record(ctx, "", metrics)
+	p.LogProvider.Record(ctx, metrics)
+}
+
+func (p *provider) RecordEvent(ctx context.Context, eventName string, metrics map[string]interface{}) {
+	p.record(ctx, eventName, metrics)
+	p.LogProvider.RecordEvent(ctx, eventName, metrics)
+}
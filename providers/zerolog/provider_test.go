@@ -0,0 +1,158 @@
+package zerolog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/log"
+	"github.com/myhelix/contextlogger/providers"
+	"github.com/myhelix/contextlogger/providers/chaining"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+)
+
+var output *bytes.Buffer
+var testProvider providers.LogProvider
+
+func setup(t *testing.T, level zerolog.Level) {
+	RegisterTestingT(t)
+
+	output = new(bytes.Buffer)
+	logger := zerolog.New(output).Level(level)
+	provider, err := LogProvider(nil, Config{Logger: &logger})
+	Expect(err).To(BeNil())
+	testProvider = provider
+}
+
+func lines() []string {
+	return strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+}
+
+func TestLevels(t *testing.T) {
+	setup(t, zerolog.InfoLevel)
+
+	ctx := context.Background()
+	testProvider.Error(ctx, true, "Broken ", 1)
+	testProvider.Warn(ctx, false, "Careful")
+	testProvider.Info(ctx, false, "Hello")
+	testProvider.Debug(ctx, false, "Filtered")
+
+	Expect(lines()).To(Equal([]string{
+		`{"level":"error","report":true,"message":"Broken 1"}`,
+		`{"level":"warn","message":"Careful"}`,
+		`{"level":"info","message":"Hello"}`,
+	}))
+}
+
+type stringer struct{}
+
+func (stringer) String() string { return "stringer" }
+
+func TestTypedFields(t *testing.T) {
+	setup(t, zerolog.DebugLevel)
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"user": "sam", "attempt": 3})
+	ctx = log.ContextWithFields(ctx, log.Fields{
+		"ok":      false,
+		"elapsed": 1500 * time.Millisecond,
+		"ratio":   0.5,
+		"at":      time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		"error":   errors.New("it broke"),
+		"small":   uint8(7),
+		"name":    stringer{},
+		"tags":    []string{"a", "b"},
+	})
+	testProvider.Debug(ctx, false, "Typed")
+
+	// In the order they were added
+	Expect(lines()).To(Equal([]string{
+		`{"level":"debug","attempt":3,"user":"sam","at":"2020-01-02T03:04:05Z","elapsed":1500,"error":"it broke",` +
+			`"name":"stringer","ok":false,"ratio":0.5,"small":7,"tags":["a","b"],"message":"Typed"}`,
+	}))
+}
+
+func TestStackTrace(t *testing.T) {
+	setup(t, zerolog.DebugLevel)
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"~stackTrace": "main.main()\n\tmain.go:12", "user": "sam"})
+	testProvider.Error(ctx, true, "Broken")
+	Expect(lines()).To(Equal([]string{
+		`{"level":"error","user":"sam","stack":"main.main()\n\tmain.go:12","report":true,"message":"Broken"}`,
+	}))
+}
+
+func TestRecord(t *testing.T) {
+	setup(t, zerolog.InfoLevel)
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"user": "sam", "bytes": "ignored"})
+	testProvider.Record(ctx, log.Metrics{"bytes": 1024, "elapsed": time.Second})
+	testProvider.RecordEvent(ctx, "upload", log.Metrics{"bytes": 2048})
+	Expect(lines()).To(Equal([]string{
+		`{"level":"info","user":"sam","bytes":1024,"elapsed":1000,"message":"Reporting metrics"}`,
+		`{"level":"info","user":"sam","eventName":"upload","bytes":2048,"message":"Reporting metrics"}`,
+	}))
+
+	// Below the logger's level
+	setup(t, zerolog.WarnLevel)
+	testProvider.Record(ctx, log.Metrics{"bytes": 1024})
+	Expect(output.Len()).To(BeZero())
+
+	_, err := LogProvider(nil, Config{})
+	Expect(err).To(MatchError("zerolog: Logger is required"))
+}
+
+func TestNoAllocations(t *testing.T) {
+	RegisterTestingT(t)
+	logger := zerolog.New(ioutil.Discard).Level(zerolog.InfoLevel)
+	provider, _ := LogProvider(nil, Config{Logger: &logger})
+
+	ctx := log.ContextWithFields(context.Background(), log.Fields{
+		"user":    "sam",
+		"attempt": 3,
+		"ok":      false,
+		"elapsed": 0.25,
+	})
+	allocs := func(provider providers.LogProvider) float64 {
+		return testing.AllocsPerRun(100, func() {
+			provider.Debug(ctx, false, "disabled")
+		})
+	}
+	// Nothing more than passing the call along the chain, which boxes the arguments
+	Expect(allocs(provider)).To(Equal(allocs(chaining.LogProvider(nil))))
+}
+
+func TestRegistered(t *testing.T) {
+	RegisterTestingT(t)
+
+	path := filepath.Join(t.TempDir(), "zerolog.log")
+	provider, err := config.Build([]byte(`
+providers:
+  - name: zerolog
+    options:
+      output: file://` + path + `
+      level: warn
+`))
+	Expect(err).To(BeNil())
+	ctx := log.ContextWithFields(context.Background(), log.Fields{"user": "sam"})
+	provider.Info(ctx, false, "Filtered")
+	provider.Warn(ctx, true, "Written")
+	provider.Wait()
+	written, err := ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	var line map[string]interface{}
+	Expect(json.Unmarshal(written, &line)).To(Succeed())
+	Expect(line).To(HaveKey("time"))
+	delete(line, "time")
+	Expect(line).To(Equal(map[string]interface{}{"level": "warn", "user": "sam", "report": true, "message": "Written"}))
+
+	_, err = config.Build([]byte(`{"providers": [{"name": "zerolog", "options": {"format": "xml"}}]}`))
+	Expect(err).To(MatchError(ContainSubstring(`option "format" must be one of json|console, not "xml"`)))
+}
@@ -0,0 +1,29 @@
+package zerolog
+
+import (
+	"github.com/rs/zerolog"
+
+	"github.com/myhelix/contextlogger/config"
+	"github.com/myhelix/contextlogger/providers"
+
+	"os"
+	"time"
+)
+
+func init() {
+	config.Register("zerolog", config.Sink, func(nextProvider providers.LogProvider, options *config.Options) (providers.LogProvider, error) {
+		output := options.Writer("output", os.Stderr)
+		level, err := providers.ParseLevel(options.String("level", "info"))
+		if err != nil {
+			options.Errorf("option \"level\": %v", err)
+		}
+		if options.OneOf("format", "json", "console") == "console" {
+			output = zerolog.ConsoleWriter{Out: output, NoColor: true, TimeFormat: time.RFC3339}
+		}
+		if err := options.Err(); err != nil {
+			return nil, err
+		}
+		logger := zerolog.New(output).Level(levels[level]).With().Timestamp().Logger()
+		return LogProvider(nextProvider, Config{Logger: &logger})
+	})
+}